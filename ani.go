@@ -0,0 +1,198 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"time"
+)
+
+// Animation represents a decoded animated cursor (ANI) file. Its frames are
+// complete ICO or CUR files, each possibly holding several sizes, played in
+// the order given by Steps.
+type Animation struct {
+	Frames []*ICO
+	Steps  []AnimationStep
+}
+
+// AnimationStep is one step of an animation's playback sequence
+type AnimationStep struct {
+	Frame int           // Index into Frames
+	Delay time.Duration // How long the frame is shown
+}
+
+// aniHeader is the payload of an ANI file's anih chunk
+type aniHeader struct {
+	Size       uint32 // Size of this structure, 36
+	Frames     uint32 // Number of frames
+	Steps      uint32 // Number of steps in the playback sequence
+	Width      uint32 // Unused when frames are ICO data
+	Height     uint32
+	BitCount   uint32
+	Planes     uint32
+	Rate       uint32 // Default display time of each step, in jiffies (1/60 s)
+	Attributes uint32 // aniFlagICO and aniFlagSequence
+}
+
+// Flags of aniHeader.Attributes
+const (
+	aniFlagICO      = 1 // Frames are ICO or CUR files rather than raw bitmaps
+	aniFlagSequence = 2 // The file has a seq chunk
+)
+
+// jiffies converts an ANI display rate, in 1/60 s units, to a duration
+func jiffies(n uint32) time.Duration {
+	return time.Duration(n) * time.Second / 60
+}
+
+// aniChunks holds the chunks of an ANI file that DecodeANI uses
+type aniChunks struct {
+	header   []byte
+	rate     []byte
+	sequence []byte
+	frames   [][]byte
+}
+
+// DecodeANI decodes an animated cursor (ANI) file from the given reader.
+// Frames stored as raw bitmaps instead of ICO data are not supported.
+func DecodeANI(r io.Reader) (*Animation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ANI data: %w", err)
+	}
+
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "ACON" {
+		return nil, fmt.Errorf("invalid ANI file: missing RIFF ACON header")
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:8]))
+	if size < 4 || size > len(data)-8 {
+		return nil, fmt.Errorf("invalid ANI file: RIFF size %d, have %d bytes", size, len(data)-8)
+	}
+
+	var chunks aniChunks
+	if err := readANIChunks(data[12:8+size], &chunks, false); err != nil {
+		return nil, err
+	}
+
+	if len(chunks.header) < 36 {
+		return nil, fmt.Errorf("invalid ANI file: missing anih chunk")
+	}
+	var header aniHeader
+	binary.Read(bytes.NewReader(chunks.header), binary.LittleEndian, &header)
+	if header.Attributes&aniFlagICO == 0 {
+		return nil, fmt.Errorf("unsupported ANI file: raw bitmap frames")
+	}
+
+	if len(chunks.frames) == 0 {
+		return nil, fmt.Errorf("ANI file contains no frames")
+	}
+	anim := &Animation{}
+	for i, frameData := range chunks.frames {
+		frame, err := Decode(bytes.NewReader(frameData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode frame %d: %w", i, err)
+		}
+		anim.Frames = append(anim.Frames, frame)
+	}
+
+	steps := int(header.Steps)
+	if steps == 0 {
+		steps = len(anim.Frames)
+	}
+	for i := 0; i < steps; i++ {
+		step := AnimationStep{Frame: i, Delay: jiffies(header.Rate)}
+		if header.Attributes&aniFlagSequence != 0 && chunks.sequence != nil {
+			if 4*i+4 > len(chunks.sequence) {
+				return nil, fmt.Errorf("seq chunk too short for %d steps", steps)
+			}
+			step.Frame = int(binary.LittleEndian.Uint32(chunks.sequence[4*i:]))
+		}
+		if chunks.rate != nil {
+			if 4*i+4 > len(chunks.rate) {
+				return nil, fmt.Errorf("rate chunk too short for %d steps", steps)
+			}
+			step.Delay = jiffies(binary.LittleEndian.Uint32(chunks.rate[4*i:]))
+		}
+		if step.Frame < 0 || step.Frame >= len(anim.Frames) {
+			return nil, fmt.Errorf("step %d refers to frame %d of %d", i, step.Frame, len(anim.Frames))
+		}
+		anim.Steps = append(anim.Steps, step)
+	}
+
+	return anim, nil
+}
+
+// readANIChunks walks a sequence of RIFF chunks, descending into the fram
+// list. Inside it, icon chunks are collected as frames.
+func readANIChunks(data []byte, chunks *aniChunks, inFrameList bool) error {
+	for off := 0; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		if size > len(data)-off-8 {
+			return fmt.Errorf("chunk %q at offset %d has invalid size %d", id, off, size)
+		}
+		body := data[off+8 : off+8+size]
+
+		switch {
+		case inFrameList && id == "icon":
+			chunks.frames = append(chunks.frames, body)
+		case id == "anih":
+			chunks.header = body
+		case id == "rate":
+			chunks.rate = body
+		case id == "seq ":
+			chunks.sequence = body
+		case id == "LIST" && size >= 4 && string(body[:4]) == "fram":
+			if err := readANIChunks(body[4:], chunks, true); err != nil {
+				return err
+			}
+		}
+
+		// Chunks are padded to an even length
+		off += 8 + size + size&1
+	}
+	return nil
+}
+
+// Duration returns the total playback time of one loop of the animation
+func (a *Animation) Duration() time.Duration {
+	var total time.Duration
+	for _, step := range a.Steps {
+		total += step.Delay
+	}
+	return total
+}
+
+// decodeANI returns the best image of the first step for image package
+// compatibility
+func decodeANI(r io.Reader) (image.Image, error) {
+	anim, err := DecodeANI(r)
+	if err != nil {
+		return nil, err
+	}
+	frame := anim.Frames[0]
+	if len(anim.Steps) > 0 {
+		frame = anim.Frames[anim.Steps[0].Frame]
+	}
+	return frame.GetBestImage(), nil
+}
+
+// decodeANIConfig returns config for the best image of the first step
+func decodeANIConfig(r io.Reader) (image.Config, error) {
+	img, err := decodeANI(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.RGBAModel,
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+	}, nil
+}
+
+func init() {
+	image.RegisterFormat("ani", "RIFF????ACON", decodeANI, decodeANIConfig)
+}
@@ -0,0 +1,138 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+	"time"
+)
+
+// riffChunk returns a RIFF chunk with its header and padding.
+func riffChunk(id string, body []byte) []byte {
+	chunk := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(body)))
+	chunk = append(chunk, body...)
+	if len(body)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func uint32s(values ...uint32) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, values)
+	return buf.Bytes()
+}
+
+// buildANI assembles an ANI file from encoded CUR frames, with optional
+// rate and seq chunks.
+func buildANI(t *testing.T, frames [][]byte, rate, seq []uint32, defaultRate uint32) []byte {
+	t.Helper()
+
+	steps := len(frames)
+	attributes := uint32(aniFlagICO)
+	if seq != nil {
+		steps = len(seq)
+		attributes |= aniFlagSequence
+	}
+
+	var body []byte
+	body = append(body, "ACON"...)
+	body = append(body, riffChunk("anih", uint32s(36, uint32(len(frames)), uint32(steps), 0, 0, 0, 0, defaultRate, attributes))...)
+	if rate != nil {
+		body = append(body, riffChunk("rate", uint32s(rate...))...)
+	}
+	if seq != nil {
+		body = append(body, riffChunk("seq ", uint32s(seq...))...)
+	}
+	list := []byte("fram")
+	for _, frame := range frames {
+		list = append(list, riffChunk("icon", frame)...)
+	}
+	body = append(body, riffChunk("LIST", list)...)
+	return riffChunk("RIFF", body)
+}
+
+// createCursorFrame encodes a single-size cursor with the given hotspot.
+func createCursorFrame(t *testing.T, size int, hotspot image.Point) []byte {
+	t.Helper()
+	cur := &ICO{Header: Header{Type: TypeCUR}}
+	cur.AddImage(createTestImage(size), &AddOptions{Hotspot: hotspot})
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, cur, nil); err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeANI(t *testing.T) {
+	frames := [][]byte{createCursorFrame(t, 32, image.Pt(1, 2)), createCursorFrame(t, 32, image.Pt(3, 4))}
+	data := buildANI(t, frames, nil, nil, 6)
+
+	anim, err := DecodeANI(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if len(anim.Frames) != 2 || len(anim.Steps) != 2 {
+		t.Fatalf("Expected 2 frames and steps, got %d and %d", len(anim.Frames), len(anim.Steps))
+	}
+	for i, step := range anim.Steps {
+		if step.Frame != i || step.Delay != 100*time.Millisecond {
+			t.Errorf("Step %d: %+v", i, step)
+		}
+	}
+	if hs := anim.Frames[1].Entries[0].Hotspot(); hs != image.Pt(3, 4) {
+		t.Errorf("Expected second frame hotspot 3,4, got %v", hs)
+	}
+	if d := anim.Duration(); d != 200*time.Millisecond {
+		t.Errorf("Expected 200ms loop, got %v", d)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil || format != "ani" || img.Bounds().Dx() != 32 {
+		t.Errorf("Expected image package to decode 32px ani, got %q, %v", format, err)
+	}
+}
+
+func TestDecodeANISequence(t *testing.T) {
+	frames := [][]byte{createCursorFrame(t, 16, image.Point{}), createCursorFrame(t, 16, image.Point{})}
+	data := buildANI(t, frames, []uint32{1, 2, 3}, []uint32{1, 0, 1}, 10)
+
+	anim, err := DecodeANI(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	want := []AnimationStep{{1, jiffies(1)}, {0, jiffies(2)}, {1, jiffies(3)}}
+	if len(anim.Steps) != len(want) {
+		t.Fatalf("Got %d steps, want %d", len(anim.Steps), len(want))
+	}
+	for i := range want {
+		if anim.Steps[i] != want[i] {
+			t.Errorf("Step %d: %+v, want %+v", i, anim.Steps[i], want[i])
+		}
+	}
+}
+
+func TestDecodeANIErrors(t *testing.T) {
+	frame := createCursorFrame(t, 16, image.Point{})
+	valid := buildANI(t, [][]byte{frame}, nil, nil, 10)
+
+	cases := map[string][]byte{
+		"empty":        {},
+		"not RIFF":     append([]byte("RIFX"), valid[4:]...),
+		"truncated":    valid[:len(valid)-20],
+		"no frames":    buildANI(t, nil, nil, nil, 10),
+		"bad frame":    buildANI(t, [][]byte{[]byte("garbage")}, nil, nil, 10),
+		"bad sequence": buildANI(t, [][]byte{frame}, nil, []uint32{5}, 10),
+		"short rate":   buildANI(t, [][]byte{frame, frame}, []uint32{1}, nil, 10),
+	}
+	for name, data := range cases {
+		if _, err := DecodeANI(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package ico
+
+import (
+	"image"
+
+	"github.com/thatoddmailbox/go-ico/resample"
+)
+
+// FromMaster builds an ICO with one 32-bit entry per requested size, each
+// resampled from a single high-resolution master image with a Lanczos3
+// filter in linear light. Non-square masters are centered on a transparent
+// square. Sizes outside the 1-256 range an ICO directory can describe, and
+// repeated sizes, are skipped.
+func FromMaster(img image.Image, sizes []int) *ICO {
+	ico := &ICO{Header: Header{Type: 1}}
+
+	seen := make(map[int]bool)
+	for _, size := range sizes {
+		if size < 1 || size > 256 || seen[size] {
+			continue
+		}
+		seen[size] = true
+
+		ico.Entries = append(ico.Entries, DirectoryEntry{
+			Width:        uint8(size), // 256 wraps to 0, as the format expects
+			Height:       uint8(size),
+			ColorPlanes:  1,
+			BitsPerPixel: 32,
+		})
+		ico.Images = append(ico.Images, scaleToSquare(img, size, &resample.Options{Filter: resample.Lanczos3}))
+	}
+
+	ico.Header.Count = uint16(len(ico.Entries))
+	return ico
+}
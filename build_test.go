@@ -0,0 +1,49 @@
+package ico
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFromMaster(t *testing.T) {
+	master := image.NewNRGBA(image.Rect(0, 0, 1024, 1024))
+	for i := 0; i < len(master.Pix); i += 4 {
+		master.Pix[i+2] = 255
+		master.Pix[i+3] = 255
+	}
+
+	sizes := []int{16, 20, 24, 32, 40, 48, 64, 96, 128, 256}
+	ico := FromMaster(master, sizes)
+
+	if int(ico.Header.Count) != len(sizes) || len(ico.Entries) != len(sizes) || len(ico.Images) != len(sizes) {
+		t.Fatalf("Expected %d entries, got header %d, %d entries, %d images",
+			len(sizes), ico.Header.Count, len(ico.Entries), len(ico.Images))
+	}
+
+	for i, size := range sizes {
+		entry := ico.Entries[i]
+		if entry.GetWidth() != size || entry.GetHeight() != size {
+			t.Errorf("Entry %d: expected %dx%d, got %dx%d", i, size, size, entry.GetWidth(), entry.GetHeight())
+		}
+		if b := ico.Images[i].Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("Image %d: expected %dx%d, got %dx%d", i, size, size, b.Dx(), b.Dy())
+		}
+		if got := color.NRGBAModel.Convert(ico.Images[i].At(size/2, size/2)); got != (color.NRGBA{B: 255, A: 255}) {
+			t.Errorf("Image %d: expected solid blue, got %v", i, got)
+		}
+	}
+
+	if ico.Entries[len(sizes)-1].Width != 0 {
+		t.Errorf("Expected 256 to be stored as 0, got %d", ico.Entries[len(sizes)-1].Width)
+	}
+}
+
+func TestFromMasterSkipsInvalidSizes(t *testing.T) {
+	master := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	ico := FromMaster(master, []int{0, 16, 16, 512, 32})
+
+	if len(ico.Entries) != 2 || ico.Header.Count != 2 {
+		t.Fatalf("Expected 2 entries, got %d (header %d)", len(ico.Entries), ico.Header.Count)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+var (
+	imageDir = flag.String("o", "", "Write an image highlighting the changed pixels of each changed entry to this directory")
+	quiet    = flag.Bool("q", false, "Only report whether the files differ")
+	verbose  = flag.Bool("v", false, "Also list unchanged entries")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <old.ico> <new.ico>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Compare the entries, directory fields and pixels of two ICO or CUR files.\n")
+		fmt.Fprintf(os.Stderr, "Either file may be - for stdin. Exits with status 0 if the files are the\n")
+		fmt.Fprintf(os.Stderr, "same, 1 if they differ and 2 on errors.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s old.ico new.ico                       # Summarize the changes\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -o diffs old.ico new.ico              # Also save highlighted diff images\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  git show HEAD~1:app.ico | %s - app.ico   # Compare with the last commit\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if flag.Arg(0) == "-" && flag.Arg(1) == "-" {
+		fatalf("standard input can only be read once")
+	}
+
+	oldICO, err := loadICO(flag.Arg(0))
+	if err != nil {
+		fatalf("%s: %v", flag.Arg(0), err)
+	}
+	newICO, err := loadICO(flag.Arg(1))
+	if err != nil {
+		fatalf("%s: %v", flag.Arg(1), err)
+	}
+
+	result := ico.Diff(oldICO, newICO)
+	if result.Identical() {
+		if !*quiet {
+			fmt.Println("Files are identical")
+		}
+		return
+	}
+	if *quiet {
+		fmt.Printf("Files %s and %s differ\n", flag.Arg(0), flag.Arg(1))
+		os.Exit(1)
+	}
+
+	if err := report(result); err != nil {
+		fatalf("%v", err)
+	}
+	os.Exit(1)
+}
+
+// fatalf logs an error and exits with status 2, as 1 means the files differ
+func fatalf(format string, args ...any) {
+	log.Printf("Error: "+format, args...)
+	os.Exit(2)
+}
+
+// loadICO decodes a file, or stdin if path is -
+func loadICO(path string) (*ico.ICO, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	icoFile, err := ico.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ICO: %w", err)
+	}
+	return icoFile, nil
+}
+
+// report prints the differences and writes the diff images
+func report(r *ico.DiffResult) error {
+	for _, c := range r.Header {
+		fmt.Printf("Header %s: %d -> %d\n", c.Field, c.Old, c.New)
+	}
+	for _, e := range r.Removed {
+		fmt.Printf("- %s (entry %d) removed\n", describe(e), e.Index)
+	}
+	for _, e := range r.Added {
+		fmt.Printf("+ %s (entry %d) added\n", describe(e), e.Index)
+	}
+
+	if *imageDir != "" {
+		if err := os.MkdirAll(*imageDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	for i := range r.Matched {
+		d := &r.Matched[i]
+		if d.Identical() {
+			if *verbose {
+				fmt.Printf("= %s (entry %d) unchanged\n", describe(d.New), d.B)
+			}
+			continue
+		}
+
+		fmt.Printf("~ %s (entry %d -> %d)", describe(d.Old), d.A, d.B)
+		if d.PixelsChanged == 0 {
+			fmt.Printf(": same pixels\n")
+		} else {
+			total := d.Old.Width * d.Old.Height
+			fmt.Printf(": %d of %d pixels changed (%.1f%%), max delta %d, PSNR %.1f dB\n",
+				d.PixelsChanged, total, 100*float64(d.PixelsChanged)/float64(total), d.MaxDelta, d.PSNR)
+		}
+		if d.Old.Format != d.New.Format {
+			fmt.Printf("    Format: %s -> %s\n", d.Old.Format, d.New.Format)
+		}
+		for _, c := range d.Fields {
+			fmt.Printf("    %s: %d -> %d\n", c.Field, c.Old, c.New)
+		}
+
+		if *imageDir != "" && d.PixelsChanged > 0 {
+			name := fmt.Sprintf("diff_%dx%d_%dbpp_%d.png", d.Old.Width, d.Old.Height, d.Old.BitsPerPixel, d.A)
+			if err := saveImage(filepath.Join(*imageDir, name), d); err != nil {
+				return err
+			}
+			fmt.Printf("    Saved %s\n", filepath.Join(*imageDir, name))
+		}
+	}
+	return nil
+}
+
+// describe formats the size, depth and format of an entry
+func describe(e ico.EntryInfo) string {
+	return fmt.Sprintf("%dx%d %d bpp %s", e.Width, e.Height, e.BitsPerPixel, e.Format)
+}
+
+// saveImage writes the highlighted diff of an entry as a PNG
+func saveImage(path string, d *ico.EntryDiff) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create diff image: %w", err)
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, d.Image()); err != nil {
+		return fmt.Errorf("failed to write diff image: %w", err)
+	}
+	return nil
+}
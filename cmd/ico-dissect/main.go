@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+var (
+	jsonOutput = flag.Bool("json", false, "Print the layout as JSON")
+	decimal    = flag.Bool("d", false, "Print offsets in decimal instead of hexadecimal")
+	hexBytes   = flag.Int("hex", 16, "Show up to this many bytes of gaps, trailing data and unused bytes (0 = none)")
+	verbose    = flag.Bool("v", false, "List every palette color")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <ico-file> [ico-file...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Print an annotated map of the bytes of ICO and CUR files, or of stdin with -.\n")
+		fmt.Fprintf(os.Stderr, "Exits with status 1 if any file has problems.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s favicon.ico                    # Map a file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -v -d legacy.ico               # Decimal offsets and full palettes\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -json broken.ico | jq .Problems  # Machine-readable output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  curl -s example.com/favicon.ico | %s -\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	stdin := 0
+	for _, arg := range flag.Args() {
+		if arg == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		log.Fatalf("Error: standard input can only be read once")
+	}
+
+	failed := false
+	for i, path := range flag.Args() {
+		data, err := readInput(path)
+		if err != nil {
+			log.Printf("Error processing %s: %v", path, err)
+			failed = true
+			continue
+		}
+
+		layout := ico.Dissect(bytes.NewReader(data))
+		if hasProblems(layout) {
+			failed = true
+		}
+
+		if *jsonOutput {
+			err = printJSON(path, layout)
+		} else {
+			if i > 0 {
+				fmt.Println()
+			}
+			err = printLayout(os.Stdout, path, data, layout)
+		}
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readInput reads a file, or stdin if path is -
+func readInput(path string) ([]byte, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return data, nil
+}
+
+// hasProblems reports whether the layout or any of its regions has problems
+func hasProblems(layout *ico.Layout) bool {
+	if len(layout.Problems) > 0 || len(layout.Overlaps) > 0 {
+		return true
+	}
+	var walk func([]ico.Region) bool
+	walk = func(regions []ico.Region) bool {
+		for _, r := range regions {
+			if len(r.Problems) > 0 || walk(r.Children) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(layout.Regions)
+}
+
+// printJSON prints one layout as a JSON object on its own line, so several
+// files form a JSON Lines stream
+func printJSON(path string, layout *ico.Layout) error {
+	out, err := json.Marshal(struct {
+		File string
+		*ico.Layout
+	}{path, layout})
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	_, err = fmt.Printf("%s\n", out)
+	return err
+}
+
+// printLayout prints the regions of a file as an indented tree
+func printLayout(w io.Writer, path string, data []byte, layout *ico.Layout) error {
+	p := &printer{w: w, data: data}
+	kind := "icon"
+	if layout.Header.Type == ico.TypeCUR {
+		kind = "cursor"
+	}
+	p.printf("%s: %d bytes, %s with %d entries\n", path, layout.Size, kind, layout.Header.Count)
+
+	for _, r := range layout.Regions {
+		p.region(r, 0)
+	}
+
+	if len(layout.Overlaps) > 0 {
+		p.printf("\nOverlaps:\n")
+		for _, o := range layout.Overlaps {
+			p.printf("  %s  %d bytes shared by %s and %s\n", p.span(o.Offset, o.Size), o.Size,
+				label(layout.Regions[o.A]), label(layout.Regions[o.B]))
+		}
+	}
+	if len(layout.Problems) > 0 {
+		p.printf("\nProblems:\n")
+		for _, problem := range layout.Problems {
+			p.printf("  ! %s\n", problem)
+		}
+	}
+	return p.err
+}
+
+// printer writes the text layout, keeping the first write error
+type printer struct {
+	w    io.Writer
+	data []byte
+	err  error
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+// offset formats a file offset
+func (p *printer) offset(v int) string {
+	if *decimal {
+		return fmt.Sprintf("%8d", v)
+	}
+	return fmt.Sprintf("0x%06X", v)
+}
+
+// span formats the range of bytes from offset up to offset+size
+func (p *printer) span(offset, size int) string {
+	return p.offset(offset) + "-" + p.offset(offset+size)
+}
+
+// region prints a region, its fields, problems and children at depth
+func (p *printer) region(r ico.Region, depth int) {
+	indent := strings.Repeat("  ", depth)
+	p.printf("%s%s %7d  %s\n", indent, p.span(r.Offset, r.Size), r.Size, label(r))
+
+	fieldIndent := indent + strings.Repeat(" ", len(p.span(0, 0))+10)
+	colors := 0
+	for _, f := range r.Fields {
+		if strings.HasPrefix(f.Name, "Color ") && !*verbose {
+			colors++
+			continue
+		}
+		p.printf("%s%s  %-16s %s\n", fieldIndent, p.offset(f.Offset), f.Name, f.Value)
+	}
+	if colors > 0 {
+		p.printf("%s(%d colors, list them with -v)\n", fieldIndent, colors)
+	}
+
+	switch r.Kind {
+	case ico.RegionGap, ico.RegionTrailing, ico.RegionUnused:
+		if *hexBytes > 0 && r.Size > 0 {
+			p.printf("%s%s\n", fieldIndent, p.hex(r.Offset, r.Size))
+		}
+	}
+
+	for _, problem := range r.Problems {
+		p.printf("%s  ! %s\n", indent, problem)
+	}
+	for _, child := range r.Children {
+		p.region(child, depth+1)
+	}
+}
+
+// hex formats the first bytes of a span
+func (p *printer) hex(offset, size int) string {
+	n := min(size, *hexBytes)
+	var b strings.Builder
+	for i, v := range p.data[offset : offset+n] {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%02X", v)
+	}
+	if n < size {
+		b.WriteString(" ...")
+	}
+	return b.String()
+}
+
+// label names a region, with its entry and detail
+func label(r ico.Region) string {
+	s := r.Kind.String()
+	if r.Entry >= 0 && (r.Kind == ico.RegionDirectoryEntry || r.Kind == ico.RegionPayload) {
+		s += fmt.Sprintf(" %d", r.Entry)
+	}
+	if r.Detail != "" {
+		s += ": " + r.Detail
+	}
+	return s
+}
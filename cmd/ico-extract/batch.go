@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// input is an ICO file to process, with the directory its images go to
+type input struct {
+	path   string
+	outDir string
+}
+
+// collectInputs expands the command-line arguments into the files to
+// process. With -r, directories are walked in lexical order and their files
+// filtered by -include and -exclude; each file's images go to the same
+// relative directory under -o, so files with the same name do not clash.
+// An argument of - is standard input, which can be read only once.
+func collectInputs(args []string) ([]input, error) {
+	include := splitList(*includeGlobs)
+	exclude := splitList(*excludeGlobs)
+
+	// Paths in the stdout stream are relative
+	outDir := *outputDir
+	if toStdout {
+		outDir = ""
+	}
+
+	var inputs []input
+	stdin := false
+	for _, arg := range args {
+		if arg == "-" {
+			if stdin {
+				return nil, fmt.Errorf("standard input can only be read once")
+			}
+			stdin = true
+			inputs = append(inputs, input{path: arg, outDir: outDir})
+			continue
+		}
+
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() {
+			// Missing files are reported when they are processed
+			inputs = append(inputs, input{path: arg, outDir: outDir})
+			continue
+		}
+		if !*recursive {
+			return nil, fmt.Errorf("%s is a directory (use -r to process directories)", arg)
+		}
+
+		root := arg
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, path)
+			if rel == "." {
+				return nil
+			}
+			if matchGlobs(exclude, rel) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || !d.Type().IsRegular() || !matchGlobs(include, rel) {
+				return nil
+			}
+			inputs = append(inputs, input{path: path, outDir: filepath.Join(outDir, filepath.Dir(rel))})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+		}
+	}
+	return inputs, nil
+}
+
+// splitList splits a comma-separated list, such as of glob patterns
+func splitList(spec string) []string {
+	var globs []string
+	for _, glob := range strings.Split(spec, ",") {
+		if glob = strings.TrimSpace(glob); glob != "" {
+			globs = append(globs, glob)
+		}
+	}
+	return globs
+}
+
+// matchGlobs reports whether any pattern matches rel, a path relative to
+// the walked directory. Patterns containing a slash match the whole
+// slash-separated path; others match the base name. Names also match in
+// lowercase, so "*.ico" matches "APP.ICO".
+func matchGlobs(globs []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, glob := range globs {
+		name := rel
+		if !strings.Contains(glob, "/") {
+			name = rel[strings.LastIndex(rel, "/")+1:]
+		}
+		if ok, _ := filepath.Match(glob, strings.ToLower(name)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// processAll processes inputs with up to workers files at a time and calls
+// emit with each result in input order, as soon as it and every earlier
+// result are ready. A file is only started once fewer than workers results
+// are waiting to be emitted, so a slow file holds back the ones after it
+// rather than letting their results, which may hold whole images, pile up
+// in memory.
+func processAll(inputs []input, workers int, emit func(*fileResult)) {
+	workers = max(1, workers)
+	results := make([]chan *fileResult, len(inputs))
+	for i := range results {
+		results[i] = make(chan *fileResult, 1)
+	}
+
+	// slots holds one token per file started and not yet emitted
+	slots := make(chan struct{}, workers)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] <- processSafely(inputs[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range inputs {
+			slots <- struct{}{}
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for _, result := range results {
+		emit(<-result)
+		<-slots
+	}
+	wg.Wait()
+}
+
+// processSafely processes one file, turning a panic on a malformed file
+// into an error for that file so that the rest of the batch still runs
+func processSafely(in input) (result *fileResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = &fileResult{Path: in.path}
+			result.setError(fmt.Errorf("internal error: %v", r))
+		}
+	}()
+	return processICOFile(in)
+}
+
+// summary counts the outcome of a run
+type summary struct {
+	files     int
+	failed    int
+	extracted int
+	sheets    int
+}
+
+// add counts a file result. A file fails if it could not be read or any of
+// its entries could not be read or saved.
+func (s *summary) add(r *fileResult) {
+	s.files++
+	failed := r.Error != ""
+	if r.sheet {
+		s.sheets++
+	}
+	for _, e := range r.Entries {
+		if e.Error != "" {
+			failed = true
+		}
+		if e.extracted && e.Error == "" {
+			s.extracted++
+		}
+	}
+	if failed {
+		s.failed++
+	}
+}
+
+func (s *summary) String() string {
+	text := fmt.Sprintf("Processed %d files", s.files)
+	switch {
+	case *sheetMode:
+		text += fmt.Sprintf(", drew %d contact sheets", s.sheets)
+	case previewOptions != nil:
+		text += fmt.Sprintf(", previewed %d images", s.extracted)
+	case !*listOnly:
+		text += fmt.Sprintf(", extracted %d images", s.extracted)
+	}
+	if s.failed > 0 {
+		text += fmt.Sprintf(", %d failed", s.failed)
+	}
+	return text
+}
@@ -1,32 +1,72 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"image"
 	"image/png"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/thatoddmailbox/go-ico"
+	"github.com/thatoddmailbox/go-ico/preview"
 )
 
 var (
-	outputDir = flag.String("o", ".", "Output directory for extracted images")
-	bestOnly  = flag.Bool("best", false, "Extract only the best (highest resolution) image")
-	sizeSpec  = flag.String("size", "", "Extract image closest to specified size (e.g., '32x32')")
-	listOnly  = flag.Bool("list", false, "List available images without extracting")
-	prefix    = flag.String("prefix", "", "Prefix for output filenames")
-	verbose   = flag.Bool("v", false, "Verbose output")
+	outputDir    = flag.String("o", ".", "Output directory for extracted images, or - to write them to stdout")
+	bestOnly     = flag.Bool("best", false, "Extract only the best (highest resolution) image")
+	sizeSpec     = flag.String("size", "", "Extract image closest to specified size (e.g., '32x32')")
+	listOnly     = flag.Bool("list", false, "List available images without extracting")
+	prefix       = flag.String("prefix", "", "Prefix for output filenames")
+	nameTemplate = flag.String("name", "", "Output name template, without extension: {base}, {index}, {w}, {h}, {bpp}, {format}, {hotspot}")
+	outputFormat = flag.String("format", "png", "Output image format: png, bmp, gif, jpeg or ico (each entry as its own icon); or report format: json, csv or table")
+	rawOutput    = flag.Bool("raw", false, "Write each entry's embedded PNG or BMP payload unmodified")
+	reportFormat = flag.String("report", "", "Report format: json (one object per file per line), csv or table; combines with an image -format")
+	jsonOutput   = flag.Bool("json", false, "Report in JSON; same as -format=json")
+	recursive    = flag.Bool("r", false, "Process directories recursively")
+	includeGlobs = flag.String("include", "*.ico,*.cur", "Comma-separated patterns of files to process in directories")
+	excludeGlobs = flag.String("exclude", "", "Comma-separated patterns of files and directories to skip")
+	workers      = flag.Int("j", runtime.NumCPU(), "Number of files to process in parallel")
+	sheetMode    = flag.Bool("sheet", false, "Save a contact sheet PNG of the selected entries instead of each entry")
+	sheetSize    = flag.Int("sheet-size", 0, "Common size entries are scaled to on the contact sheet (default: the largest entry's; -1 to omit)")
+	previewMode  = flag.Bool("preview", false, "Draw the selected images in the terminal instead of saving them")
+	previewColor = flag.String("preview-mode", "", "Preview colors: truecolor, 256 or ascii (default: detected from the environment)")
+	previewWidth = flag.Int("preview-width", 0, "Scale previews to this many columns (default: one column per pixel)")
+	minSize      = flag.Int("min", 0, "Only entries at least this many pixels wide and tall")
+	maxSize      = flag.Int("max", 0, "Only entries at most this many pixels wide and tall")
+	bppSpec      = flag.String("bpp", "", "Only entries of these comma-separated bit depths (e.g. '24,32')")
+	formatIn     = flag.String("format-in", "", "Only entries stored as png or bmp")
+	squareOnly   = flag.Bool("square-only", false, "Only entries as wide as they are tall")
+	verbose      = flag.Bool("v", false, "Verbose output")
 )
 
+// targetSize is the size requested with -size
+var targetSize image.Point
+
+// entryFilter selects the entries to list or extract
+var entryFilter ico.EntryFilter
+
+// toStdout is set when extracted images are written to stdout with -o -,
+// or previews with -preview, in which case they are buffered in memory
+// rather than saved
+var toStdout bool
+
+// previewOptions configures the previews drawn with -preview, and is nil
+// otherwise
+var previewOptions *preview.Options
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] <ico-file> [ico-file...]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Extract images from ICO files and save them as PNG files.\n\n")
+		fmt.Fprintf(os.Stderr, "Extract images from ICO files and save them as PNG or other image files.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -34,7 +74,19 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -best favicon.ico              # Extract only the best image\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -size=32x32 favicon.ico        # Extract image closest to 32x32\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -list favicon.ico              # List available images\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -list -json *.ico             # List as JSON\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -o=icons -prefix=app_ *.ico    # Extract to icons/ with prefix\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -r -exclude=test -o=out assets # Extract a tree, mirrored under out/\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -name={base}-{w} -format=bmp a.ico # Name outputs like a-32.bmp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -raw favicon.ico               # Dump the embedded payloads\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -bpp=32 -min=24 -max=64 a.ico  # Extract 32-bit entries from 24 to 64 pixels\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -preview -max=48 favicon.ico   # Show entries up to 48x48 in the terminal\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -sheet -sheet-size=64 app.ico  # Save app_sheet.png showing every entry\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  curl -s $URL | %s -best -o - - > best.png # Read stdin, write one image\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -o - *.ico | tar -x -C icons  # Write all images as a tar stream\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nWith -o -, a single input with -best or -size writes the image itself to\n")
+		fmt.Fprintf(os.Stderr, "stdout; otherwise every image is written as a member of a tar stream. An\n")
+		fmt.Fprintf(os.Stderr, "input of - reads an ICO file from stdin.\n")
 	}
 
 	flag.Parse()
@@ -44,172 +96,358 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(*outputDir, 0755); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+	// -format also takes the report formats, which never clash with the
+	// image formats
+	switch *outputFormat {
+	case "json", "csv", "table":
+		if *reportFormat != "" && *reportFormat != *outputFormat {
+			log.Fatalf("Error: conflicting report formats: -format=%s and -report=%s", *outputFormat, *reportFormat)
+		}
+		*reportFormat, *outputFormat = *outputFormat, "png"
 	}
-
-	// Process each ICO file
-	for _, icoPath := range flag.Args() {
-		if err := processICOFile(icoPath); err != nil {
-			log.Printf("Error processing %s: %v", icoPath, err)
+	if *jsonOutput {
+		*reportFormat = "json"
+	}
+	if *previewMode {
+		mode := preview.DetectMode()
+		if *previewColor != "" {
+			var err error
+			if mode, err = preview.ParseMode(*previewColor); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
 		}
+		previewOptions = &preview.Options{Mode: mode, Width: *previewWidth}
 	}
-}
-
-func processICOFile(icoPath string) error {
-	if *verbose {
-		fmt.Printf("Processing: %s\n", icoPath)
+	if _, ok := outputExtensions[*outputFormat]; !ok {
+		log.Fatalf("Error: invalid format: %q (use png, bmp, gif, jpeg or ico, or json, csv or table for reports)", *outputFormat)
 	}
-
-	file, err := os.Open(icoPath)
+	// Images written to stdout move the report to stderr
+	toStdout = (*outputDir == "-" || previewOptions != nil) && !*listOnly
+	reportOutput := io.Writer(os.Stdout)
+	if toStdout {
+		reportOutput = os.Stderr
+	}
+	report, err := newReporter(*reportFormat, reportOutput)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		log.Fatalf("Error: %v", err)
 	}
-	defer file.Close()
 
-	// If we only need to list, use DecodeConfig for efficiency
-	if *listOnly {
-		return listImages(file, icoPath)
+	if *sizeSpec != "" {
+		if targetSize, err = parseSize(*sizeSpec); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 	}
 
-	// Decode the full ICO file
-	icoFile, err := ico.Decode(file)
-	if err != nil {
-		return fmt.Errorf("failed to decode ICO: %w", err)
+	if entryFilter, err = parseFilter(); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
-	if *verbose {
-		fmt.Printf("  Found %d images\n", len(icoFile.Images))
+	inputs, err := collectInputs(flag.Args())
+	if err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
-	baseFilename := strings.TrimSuffix(filepath.Base(icoPath), filepath.Ext(icoPath))
+	var stream *outputStream
+	if toStdout {
+		single := previewOptions != nil || (len(inputs) == 1 && (*bestOnly || *sizeSpec != "" || *sheetMode))
+		stream = newOutputStream(os.Stdout, single)
+	}
 
-	if *bestOnly {
-		return extractBestImage(icoFile, baseFilename)
+	// Process the files in parallel, reporting in order
+	start := time.Now()
+	var s summary
+	processAll(inputs, *workers, func(r *fileResult) {
+		verbosef("Processing: %s\n", r.Path)
+		if !*listOnly && r.Error == "" {
+			verbosef("  Found %d images\n", len(r.Entries))
+		}
+		if stream != nil {
+			if err := stream.write(r); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+		report.file(r)
+		s.add(r)
+	})
+	if err := report.close(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if stream != nil {
+		if err := stream.close(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 	}
 
-	if *sizeSpec != "" {
-		return extractImageBySize(icoFile, baseFilename, *sizeSpec)
+	if len(inputs) > 1 || s.failed > 0 {
+		fmt.Fprintf(os.Stderr, "%s in %s\n", &s, time.Since(start).Round(time.Millisecond))
 	}
+	if s.failed > 0 {
+		os.Exit(1)
+	}
+}
 
-	// Extract all images
-	return extractAllImages(icoFile, baseFilename)
+// verbosef prints progress details with -v, to stderr when stdout carries a
+// machine-readable report or images
+func verbosef(format string, args ...any) {
+	if !*verbose {
+		return
+	}
+	if *reportFormat != "" || toStdout {
+		fmt.Fprintf(os.Stderr, format, args...)
+	} else {
+		fmt.Printf(format, args...)
+	}
 }
 
-func listImages(file *os.File, icoPath string) error {
-	config, err := ico.DecodeConfig(file)
+func processICOFile(in input) *fileResult {
+	result := &fileResult{Path: in.path}
+	base := strings.TrimSuffix(filepath.Base(in.path), filepath.Ext(in.path))
+	var data []byte
+	var err error
+	if in.path == "-" {
+		base = stdinName
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(in.path)
+	}
 	if err != nil {
-		return err
+		result.setError(fmt.Errorf("failed to open file: %w", err))
+		return result
 	}
 
-	fmt.Printf("%s:\n", icoPath)
-	fmt.Printf("  Images: %d\n", config.Count)
-	fmt.Printf("  Largest: %dx%d\n", config.Width, config.Height)
-
-	// For detailed listing, we need to decode the full file
-	file.Seek(0, 0)
-	icoFile, err := ico.Decode(file)
+	// Listing needs only the headers, and lists every readable entry even
+	// if another is corrupt
+	info, err := ico.Inspect(bytes.NewReader(data))
 	if err != nil {
-		return err
+		result.setError(fmt.Errorf("failed to read ICO: %w", err))
+		return result
 	}
+	result.describe(info, entryFilter.Match)
 
-	for i, entry := range icoFile.Entries {
-		fmt.Printf("  Image %d: %dx%d, %d bpp, %d bytes\n",
-			i+1, entry.GetWidth(), entry.GetHeight(), entry.BitsPerPixel, entry.Size)
+	if *listOnly {
+		return result
 	}
-	fmt.Println()
-
-	return nil
-}
 
-func extractBestImage(icoFile *ico.ICO, baseFilename string) error {
-	img := icoFile.GetBestImage()
-	if img == nil {
-		return fmt.Errorf("no images found")
+	// Create output directory if it doesn't exist
+	if !toStdout {
+		if err := os.MkdirAll(in.outDir, 0755); err != nil {
+			result.setError(fmt.Errorf("failed to create output directory: %w", err))
+			return result
+		}
+	}
+	x := &extractor{
+		data:   data,
+		info:   info,
+		result: result,
+		outDir: in.outDir,
+		base:   base,
+		used:   make(map[string]bool),
 	}
 
-	bounds := img.Bounds()
-	filename := fmt.Sprintf("%s%s_best_%dx%d.png", *prefix, baseFilename, bounds.Dx(), bounds.Dy())
-	outputPath := filepath.Join(*outputDir, filename)
+	// Raw payloads are dumped without decoding, so even corrupt entries can
+	// be saved for inspection; choosing the best image requires decoding
+	if *rawOutput && !*bestOnly && *sizeSpec == "" && previewOptions == nil && !*sheetMode {
+		x.extractAll()
+		return result
+	}
 
-	if err := savePNG(img, outputPath); err != nil {
-		return err
+	// Decode the full ICO file
+	icoFile, err := ico.Decode(bytes.NewReader(data))
+	if err != nil {
+		result.setError(fmt.Errorf("failed to decode ICO: %w", err))
+		return result
 	}
+	x.images = slices.Clone(icoFile.Images)
+	x.payloads = slices.Clone(icoFile.Payloads)
+
+	// The best image is chosen among the entries that pass the filters
+	icoFile.Filter(entryFilter.Match)
+	if *sheetMode {
+		x.sheet(icoFile)
+	} else if *bestOnly {
+		x.extractImage(icoFile.GetBestImage(), defaultNameBest)
+	} else if *sizeSpec != "" {
+		x.extractImage(icoFile.GetImageBySize(targetSize.X, targetSize.Y), defaultNameSize)
+	} else {
+		x.extractAll()
+	}
+	return result
+}
 
-	fmt.Printf("Extracted best image: %s (%dx%d)\n", outputPath, bounds.Dx(), bounds.Dy())
-	return nil
+// extractor writes the entries of one file. The entries of its result are
+// those that pass the filters; the other fields cover every entry, by
+// directory index.
+type extractor struct {
+	data     []byte
+	info     *ico.Info
+	images   []image.Image // Nil when dumping raw payloads
+	payloads [][]byte
+	result   *fileResult
+	outDir   string
+	base     string          // File name without extension
+	used     map[string]bool // Output names already written
 }
 
-func extractImageBySize(icoFile *ico.ICO, baseFilename, sizeSpec string) error {
-	parts := strings.Split(sizeSpec, "x")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid size specification: %s (use format like '32x32')", sizeSpec)
+// extractImage extracts the entry whose decoded image is img. A nil img
+// means no entry passed the filters, so there is nothing to extract.
+func (x *extractor) extractImage(img image.Image, defaultName string) {
+	if img == nil {
+		return
 	}
-
-	width, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return fmt.Errorf("invalid width: %s", parts[0])
+	for i, e := range x.result.Entries {
+		if x.images[e.Index-1] == img {
+			x.extract(i, defaultName)
+			return
+		}
 	}
+	x.result.setError(fmt.Errorf("no images found"))
+}
 
-	height, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return fmt.Errorf("invalid height: %s", parts[1])
+func (x *extractor) extractAll() {
+	for i := range x.result.Entries {
+		x.extract(i, defaultNameAll)
 	}
+}
 
-	img := icoFile.GetImageBySize(width, height)
+// sheet saves or previews the contact sheet of the entries in icoFile, if
+// any passed the filters
+func (x *extractor) sheet(icoFile *ico.ICO) {
+	img := ico.ContactSheet(icoFile, &ico.SheetOptions{Size: *sheetSize})
 	if img == nil {
-		return fmt.Errorf("no images found")
+		return
 	}
+	if previewOptions != nil {
+		x.result.saveSheet("", func(w io.Writer) error {
+			fmt.Fprintf(w, "%s: contact sheet of %d images\n", x.result.Path, len(icoFile.Images))
+			if err := preview.Render(w, img, previewOptions); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintln(w)
+			return err
+		})
+		return
+	}
+	x.result.saveSheet(filepath.Join(x.outDir, *prefix+x.base+"_sheet.png"), func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
 
-	bounds := img.Bounds()
-	filename := fmt.Sprintf("%s%s_%dx%d.png", *prefix, baseFilename, bounds.Dx(), bounds.Dy())
-	outputPath := filepath.Join(*outputDir, filename)
+// extract writes the entry at position i of the result as named by -name,
+// or defaultName, in the -format output format or as its raw payload, or
+// draws its preview
+func (x *extractor) extract(i int, defaultName string) {
+	e := &x.result.Entries[i]
+	if previewOptions != nil {
+		img := x.images[e.Index-1]
+		x.result.save(i, "", func(w io.Writer) error {
+			fmt.Fprintf(w, "%s: image %d, %dx%d, %d bpp, %s\n",
+				x.result.Path, e.Index, e.Width, e.Height, e.BitsPerPixel, e.Format)
+			if err := preview.Render(w, img, previewOptions); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintln(w)
+			return err
+		})
+		return
+	}
 
-	if err := savePNG(img, outputPath); err != nil {
-		return err
+	template := *nameTemplate
+	if template == "" {
+		template = defaultName
 	}
 
-	fmt.Printf("Extracted image closest to %dx%d: %s (actual: %dx%d)\n",
-		width, height, outputPath, bounds.Dx(), bounds.Dy())
-	return nil
+	name := *prefix + expandName(template, x.base, *e)
+	ext := outputExtensions[*outputFormat]
+	if *rawOutput {
+		ext = rawExtension(e.Format)
+	}
+	// Keep entries that expand to the same name apart
+	if x.used[name+ext] {
+		name += "_" + strconv.Itoa(e.Index)
+	}
+	x.used[name+ext] = true
+
+	index := e.Index - 1
+	entry := x.info.Entries[index]
+	x.result.save(i, filepath.Join(x.outDir, name+ext), func(w io.Writer) error {
+		switch {
+		case *rawOutput:
+			start, end := uint64(entry.Entry.Offset), uint64(entry.Entry.Offset)+uint64(entry.Entry.Size)
+			if end > uint64(len(x.data)) {
+				return entry.Err
+			}
+			_, err := w.Write(x.data[start:end])
+			return err
+		case *outputFormat == "ico":
+			return writeSingleICO(w, x.info.Header.Type, entry.Entry, x.payloads[index])
+		default:
+			return encodeImage(w, x.images[index], *outputFormat)
+		}
+	})
 }
 
-func extractAllImages(icoFile *ico.ICO, baseFilename string) error {
-	if len(icoFile.Images) == 0 {
-		return fmt.Errorf("no images found")
+// parseFilter builds the entry filter from -min, -max, -bpp, -format-in and
+// -square-only
+func parseFilter() (ico.EntryFilter, error) {
+	f := ico.EntryFilter{MinSize: *minSize, MaxSize: *maxSize, SquareOnly: *squareOnly}
+	if f.MinSize < 0 || f.MaxSize < 0 || (f.MaxSize > 0 && f.MaxSize < f.MinSize) {
+		return f, fmt.Errorf("invalid size range: %d to %d", f.MinSize, f.MaxSize)
 	}
 
-	for i, img := range icoFile.Images {
-		bounds := img.Bounds()
-		entry := icoFile.Entries[i]
-
-		filename := fmt.Sprintf("%s%s_%d_%dx%d_%dbpp.png",
-			*prefix, baseFilename, i+1, bounds.Dx(), bounds.Dy(), entry.BitsPerPixel)
-		outputPath := filepath.Join(*outputDir, filename)
+	for _, spec := range splitList(*bppSpec) {
+		bpp, err := strconv.Atoi(spec)
+		if err != nil || bpp <= 0 {
+			return f, fmt.Errorf("invalid bit depth: %s", spec)
+		}
+		f.BitsPerPixel = append(f.BitsPerPixel, bpp)
+	}
 
-		if err := savePNG(img, outputPath); err != nil {
-			log.Printf("Failed to save image %d: %v", i+1, err)
-			continue
+	for _, spec := range splitList(*formatIn) {
+		switch strings.ToLower(spec) {
+		case "png":
+			f.Formats = append(f.Formats, ico.FormatPNG)
+		case "bmp":
+			f.Formats = append(f.Formats, ico.FormatBMP)
+		default:
+			return f, fmt.Errorf("invalid input format: %q (use png or bmp)", spec)
 		}
+	}
+	return f, nil
+}
 
-		fmt.Printf("Extracted image %d: %s (%dx%d, %d bpp)\n",
-			i+1, outputPath, bounds.Dx(), bounds.Dy(), entry.BitsPerPixel)
+// parseSize parses a size such as "32x32"
+func parseSize(spec string) (image.Point, error) {
+	parts := strings.Split(spec, "x")
+	if len(parts) != 2 {
+		return image.Point{}, fmt.Errorf("invalid size specification: %s (use format like '32x32')", spec)
 	}
 
-	return nil
+	width, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid width: %s", parts[0])
+	}
+
+	height, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid height: %s", parts[1])
+	}
+	return image.Pt(width, height), nil
 }
 
-func savePNG(img image.Image, path string) error {
+// writeFile creates path and fills it with write, removing it on failure
+func writeFile(path string, write func(io.Writer) error) error {
 	file, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
-	defer file.Close()
 
-	if err := png.Encode(file, img); err != nil {
-		return fmt.Errorf("failed to encode PNG: %w", err)
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
 	}
-
 	return nil
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/thatoddmailbox/go-ico"
+	"github.com/thatoddmailbox/go-ico/quantize"
+)
+
+// Default -name templates for each extraction mode
+const (
+	defaultNameAll  = "{base}_{index}_{w}x{h}_{bpp}bpp"
+	defaultNameBest = "{base}_best_{w}x{h}"
+	defaultNameSize = "{base}_{w}x{h}"
+)
+
+// outputExtensions maps each -format value to its file extension
+var outputExtensions = map[string]string{
+	"png":  ".png",
+	"bmp":  ".bmp",
+	"gif":  ".gif",
+	"jpeg": ".jpg",
+	"ico":  ".ico",
+}
+
+// expandName replaces the placeholders of a -name template with the fields
+// of entry e of a file named base
+func expandName(template, base string, e entryResult) string {
+	width, height, bpp := e.Width, e.Height, e.BitsPerPixel
+	if width == 0 || height == 0 {
+		width, height = e.DeclaredWidth, e.DeclaredHeight
+	}
+	if bpp == 0 {
+		bpp = e.DeclaredBPP
+	}
+	hotspot := "0_0"
+	if e.Hotspot != "" {
+		hotspot = strings.Replace(e.Hotspot, ",", "_", 1)
+	}
+
+	return strings.NewReplacer(
+		"{base}", base,
+		"{index}", strconv.Itoa(e.Index),
+		"{w}", strconv.Itoa(width),
+		"{h}", strconv.Itoa(height),
+		"{bpp}", strconv.Itoa(bpp),
+		"{format}", e.Format,
+		"{hotspot}", hotspot,
+	).Replace(template)
+}
+
+// rawExtension returns the extension for a raw payload: PNG payloads are
+// complete PNG files, while BMP payloads are headerless DIBs with an AND mask
+func rawExtension(format string) string {
+	if format == "png" {
+		return ".png"
+	}
+	return ".dib"
+}
+
+// encodeImage writes img in an output format other than ico
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(w, img)
+	case "bmp":
+		err = writeBMP(w, img)
+	case "gif":
+		err = writeGIF(w, img)
+	case "jpeg":
+		err = jpeg.Encode(w, flatten(img, color.White), &jpeg.Options{Quality: 95})
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", strings.ToUpper(format), err)
+	}
+	return nil
+}
+
+// writeSingleICO writes one entry of a file, with its payload unchanged, as
+// an ICO or CUR file of its own
+func writeSingleICO(w io.Writer, fileType uint16, entry ico.DirectoryEntry, payload []byte) error {
+	entry.Offset = 6 + 16
+	entry.Size = uint32(len(payload))
+	if err := binary.Write(w, binary.LittleEndian, ico.Header{Type: fileType, Count: 1}); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, entry); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// writeBMP writes img as a 32-bit BMP file with alpha, using a BITMAPV4HEADER
+// with channel masks
+func writeBMP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	const fileHeaderSize, infoHeaderSize = 14, 108
+	pixelSize := 4 * b.Dx() * b.Dy()
+
+	header := make([]byte, fileHeaderSize+infoHeaderSize)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(header)+pixelSize))
+	binary.LittleEndian.PutUint32(header[10:], uint32(len(header)))
+
+	info := header[fileHeaderSize:]
+	binary.LittleEndian.PutUint32(info, infoHeaderSize)
+	binary.LittleEndian.PutUint32(info[4:], uint32(b.Dx()))
+	binary.LittleEndian.PutUint32(info[8:], uint32(b.Dy())) // Bottom-up
+	binary.LittleEndian.PutUint16(info[12:], 1)
+	binary.LittleEndian.PutUint16(info[14:], 32)
+	binary.LittleEndian.PutUint32(info[16:], 3) // BI_BITFIELDS
+	binary.LittleEndian.PutUint32(info[20:], uint32(pixelSize))
+	binary.LittleEndian.PutUint32(info[40:], 0x00FF0000) // Red mask
+	binary.LittleEndian.PutUint32(info[44:], 0x0000FF00) // Green mask
+	binary.LittleEndian.PutUint32(info[48:], 0x000000FF) // Blue mask
+	binary.LittleEndian.PutUint32(info[52:], 0xFF000000) // Alpha mask
+	copy(info[56:], "BGRs")                              // LCS_sRGB, stored little-endian
+
+	pixels := make([]byte, 0, pixelSize)
+	for y := b.Max.Y - 1; y >= b.Min.Y; y-- {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			pixels = append(pixels, c.B, c.G, c.R, c.A)
+		}
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(pixels)
+	return err
+}
+
+// writeGIF writes img as a GIF with an adaptive palette. Pixels less than
+// half opaque become transparent, since GIF has no partial transparency.
+func writeGIF(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	flat := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	transparent := false
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if c.A < 128 {
+				transparent = true
+				continue
+			}
+			c.A = 255
+			flat.SetNRGBA(x, y, c)
+		}
+	}
+
+	// Keep the last palette entry free for transparency
+	palette := quantize.MedianCut{}.Quantize(make(color.Palette, 0, 255), flat)
+	paletted := quantize.Map(flat, palette, true)
+	if transparent {
+		paletted.Palette = append(paletted.Palette, color.NRGBA{})
+		index := uint8(len(paletted.Palette) - 1)
+		for i := 0; i < len(flat.Pix); i += 4 {
+			if flat.Pix[i+3] == 0 {
+				paletted.Pix[i/4] = index
+			}
+		}
+	}
+	return gif.Encode(w, paletted, nil)
+}
+
+// flatten composites img over an opaque background, for formats without
+// alpha
+func flatten(img image.Image, background color.Color) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+// fileResult is the outcome of processing one ICO file
+type fileResult struct {
+	Path    string        `json:"path"`
+	Type    string        `json:"type,omitempty"` // "icon" or "cursor"
+	Entries []entryResult `json:"entries"`
+	Sheet   string        `json:"sheet,omitempty"` // Contact sheet written with -sheet
+	Error   string        `json:"error,omitempty"`
+
+	sheet bool   // Set once the contact sheet is saved or previewed
+	data  []byte // Contact sheet buffered for stdout
+}
+
+// entryResult describes one directory entry and, if it was extracted,
+// where it was written
+type entryResult struct {
+	Index          int    `json:"index"` // From 1, as in the text output
+	DeclaredWidth  int    `json:"declared_width"`
+	DeclaredHeight int    `json:"declared_height"`
+	Width          int    `json:"width"`  // From the payload header
+	Height         int    `json:"height"` // From the payload header
+	DeclaredBPP    int    `json:"declared_bpp"`
+	BitsPerPixel   int    `json:"bpp"`               // From the payload header
+	Format         string `json:"format,omitempty"`  // "png" or "bmp"
+	Hotspot        string `json:"hotspot,omitempty"` // "x,y", in cursors
+	Size           uint32 `json:"size"`
+	Offset         uint32 `json:"offset"`
+	Output         string `json:"output,omitempty"`
+	Error          string `json:"error,omitempty"`
+
+	extracted bool
+	data      []byte // Output buffered for stdout
+}
+
+// setError records an error that stopped the file from being processed
+func (r *fileResult) setError(err error) {
+	r.Error = err.Error()
+}
+
+// describe fills in the header type and the entries of an inspected file
+// for which keep returns true
+func (r *fileResult) describe(info *ico.Info, keep func(ico.EntryInfo) bool) {
+	r.Type = "icon"
+	if info.Header.Type == ico.TypeCUR {
+		r.Type = "cursor"
+	}
+
+	r.Entries = []entryResult{}
+	for i, entry := range info.Entries {
+		if !keep(entry) {
+			continue
+		}
+		e := entryResult{
+			Index:          i + 1,
+			DeclaredWidth:  entry.Entry.GetWidth(),
+			DeclaredHeight: entry.Entry.GetHeight(),
+			Width:          entry.Width,
+			Height:         entry.Height,
+			BitsPerPixel:   entry.BitsPerPixel,
+			Format:         entry.Format.String(),
+			Size:           entry.Entry.Size,
+			Offset:         entry.Entry.Offset,
+		}
+		// Cursors store the hotspot in place of the bit depth
+		if info.Header.Type == ico.TypeCUR {
+			hotspot := entry.Entry.Hotspot()
+			e.Hotspot = fmt.Sprintf("%d,%d", hotspot.X, hotspot.Y)
+		} else {
+			e.DeclaredBPP = int(entry.Entry.BitsPerPixel)
+		}
+		if entry.Err != nil {
+			e.Format = ""
+			e.Error = entry.Err.Error()
+		}
+		r.Entries = append(r.Entries, e)
+	}
+}
+
+// save writes entry i to path with write and records the outcome. With
+// -o - or -preview, the output is buffered for stdout instead.
+func (r *fileResult) save(i int, path string, write func(io.Writer) error) {
+	e := &r.Entries[i]
+	e.extracted = true
+	data, err := store(path, write)
+	if err != nil {
+		e.Error = err.Error()
+		return
+	}
+	e.Output = path
+	e.data = data
+}
+
+// saveSheet writes the contact sheet to path with write and records the
+// outcome
+func (r *fileResult) saveSheet(path string, write func(io.Writer) error) {
+	data, err := store(path, write)
+	if err != nil {
+		r.setError(fmt.Errorf("failed to save contact sheet: %w", err))
+		return
+	}
+	r.Sheet = path
+	r.sheet = true
+	r.data = data
+}
+
+// store writes an output to path with write, or with -o - or -preview
+// returns it to be written to stdout
+func store(path string, write func(io.Writer) error) ([]byte, error) {
+	if !toStdout {
+		return nil, writeFile(path, write)
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// reporter prints the result of each file as it completes
+type reporter interface {
+	file(r *fileResult)
+	close() error
+}
+
+// newReporter returns the reporter for a report format given with
+// -format or -report
+func newReporter(format string, w io.Writer) (reporter, error) {
+	switch format {
+	case "":
+		return &textReporter{w: w}, nil
+	case "json":
+		return &jsonReporter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvReporter{w: csv.NewWriter(w)}, nil
+	case "table":
+		return &tableReporter{w: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)}, nil
+	}
+	return nil, fmt.Errorf("invalid report format: %q (use json, csv or table)", format)
+}
+
+// textReporter prints the human-readable messages of earlier versions,
+// with errors on the log
+type textReporter struct {
+	w io.Writer
+}
+
+func (t *textReporter) file(r *fileResult) {
+	if r.Error != "" {
+		log.Printf("Error processing %s: %s", r.Path, r.Error)
+		return
+	}
+
+	if *listOnly {
+		largest := image.Point{}
+		for _, e := range r.Entries {
+			if e.DeclaredWidth*e.DeclaredHeight > largest.X*largest.Y {
+				largest = image.Pt(e.DeclaredWidth, e.DeclaredHeight)
+			}
+		}
+		fmt.Fprintf(t.w, "%s:\n", r.Path)
+		fmt.Fprintf(t.w, "  Images: %d\n", len(r.Entries))
+		fmt.Fprintf(t.w, "  Largest: %dx%d\n", largest.X, largest.Y)
+		for _, e := range r.Entries {
+			bpp := e.BitsPerPixel
+			if bpp == 0 {
+				bpp = e.DeclaredBPP
+			}
+			fmt.Fprintf(t.w, "  Image %d: %dx%d, %d bpp, %d bytes",
+				e.Index, e.DeclaredWidth, e.DeclaredHeight, bpp, e.Size)
+			switch {
+			case e.Error != "":
+				fmt.Fprintf(t.w, " (error: %s)", e.Error)
+			case e.Width != e.DeclaredWidth || e.Height != e.DeclaredHeight:
+				fmt.Fprintf(t.w, ", %s (payload is %dx%d)", e.Format, e.Width, e.Height)
+			default:
+				fmt.Fprintf(t.w, ", %s", e.Format)
+			}
+			if e.Hotspot != "" {
+				fmt.Fprintf(t.w, ", hotspot %s", e.Hotspot)
+			}
+			fmt.Fprintln(t.w)
+		}
+		fmt.Fprintln(t.w)
+		return
+	}
+
+	if r.sheet && previewOptions == nil {
+		fmt.Fprintf(t.w, "Saved contact sheet of %d images: %s\n", len(r.Entries), r.Sheet)
+	}
+	for _, e := range r.Entries {
+		switch {
+		case !e.extracted:
+		case e.Error != "":
+			log.Printf("Failed to save image %d: %s", e.Index, e.Error)
+		case previewOptions != nil:
+			// The preview is the output
+		case *bestOnly:
+			fmt.Fprintf(t.w, "Extracted best image: %s (%dx%d)\n", e.Output, e.Width, e.Height)
+		case *sizeSpec != "":
+			fmt.Fprintf(t.w, "Extracted image closest to %dx%d: %s (actual: %dx%d)\n",
+				targetSize.X, targetSize.Y, e.Output, e.Width, e.Height)
+		default:
+			fmt.Fprintf(t.w, "Extracted image %d: %s (%dx%d, %d bpp)\n",
+				e.Index, e.Output, e.Width, e.Height, e.BitsPerPixel)
+		}
+	}
+}
+
+func (t *textReporter) close() error {
+	return nil
+}
+
+// jsonReporter writes each file result as a JSON object on its own line
+type jsonReporter struct {
+	enc *json.Encoder
+	err error
+}
+
+func (j *jsonReporter) file(r *fileResult) {
+	if r.Entries == nil {
+		r.Entries = []entryResult{}
+	}
+	if err := j.enc.Encode(r); err != nil && j.err == nil {
+		j.err = fmt.Errorf("failed to write report: %w", err)
+	}
+}
+
+func (j *jsonReporter) close() error {
+	return j.err
+}
+
+// columns are the fields of the CSV and table reports, one row per entry
+var columns = []string{
+	"path", "type", "index", "declared_width", "declared_height", "width", "height",
+	"declared_bpp", "bpp", "format", "hotspot", "size", "offset", "output", "error",
+}
+
+// rows flattens a file result into one row per entry, or a single row
+// holding the error of a file that could not be read
+func rows(r *fileResult) [][]string {
+	if len(r.Entries) == 0 {
+		row := make([]string, len(columns))
+		row[0], row[1], row[len(row)-1] = r.Path, r.Type, r.Error
+		return [][]string{row}
+	}
+
+	var out [][]string
+	for _, e := range r.Entries {
+		errText := e.Error
+		if errText == "" {
+			errText = r.Error
+		}
+		out = append(out, []string{
+			r.Path, r.Type, strconv.Itoa(e.Index),
+			strconv.Itoa(e.DeclaredWidth), strconv.Itoa(e.DeclaredHeight),
+			strconv.Itoa(e.Width), strconv.Itoa(e.Height),
+			strconv.Itoa(e.DeclaredBPP), strconv.Itoa(e.BitsPerPixel), e.Format, e.Hotspot,
+			strconv.FormatUint(uint64(e.Size), 10), strconv.FormatUint(uint64(e.Offset), 10),
+			e.Output, errText,
+		})
+	}
+	return out
+}
+
+// csvReporter writes a header row followed by one row per entry
+type csvReporter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func (c *csvReporter) file(r *fileResult) {
+	if !c.started {
+		c.w.Write(columns)
+		c.started = true
+	}
+	c.w.WriteAll(rows(r))
+}
+
+func (c *csvReporter) close() error {
+	if !c.started {
+		c.w.Write(columns)
+	}
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// tableReporter aligns the CSV columns for reading in a terminal. Rows are
+// buffered until close, so the column widths fit every row.
+type tableReporter struct {
+	w       *tabwriter.Writer
+	started bool
+}
+
+func (t *tableReporter) file(r *fileResult) {
+	if !t.started {
+		t.row(columns)
+		t.started = true
+	}
+	for _, row := range rows(r) {
+		t.row(row)
+	}
+}
+
+func (t *tableReporter) row(fields []string) {
+	for i, f := range fields {
+		if i > 0 {
+			fmt.Fprint(t.w, "\t")
+		}
+		if f == "" {
+			f = "-"
+		}
+		fmt.Fprint(t.w, f)
+	}
+	fmt.Fprintln(t.w)
+}
+
+func (t *tableReporter) close() error {
+	if !t.started {
+		t.row(columns)
+	}
+	if err := t.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// stdinName is the base name of images extracted from standard input
+const stdinName = "stdin"
+
+// outputStream writes extracted images to standard output with -o -:
+// either the single image selected by -best or -size from a single input,
+// or a tar stream with one member per image
+type outputStream struct {
+	w       io.Writer
+	tar     *tar.Writer // Nil when writing a single image
+	modTime time.Time
+}
+
+func newOutputStream(w io.Writer, single bool) *outputStream {
+	s := &outputStream{w: w, modTime: time.Now().Truncate(time.Second)}
+	if !single {
+		s.tar = tar.NewWriter(w)
+	}
+	return s
+}
+
+// write writes the buffered contact sheet and images of a file result, in
+// entry order, and releases them
+func (s *outputStream) write(r *fileResult) error {
+	if r.data != nil {
+		if err := s.writeFile(r.Sheet, r.data); err != nil {
+			return err
+		}
+		r.data = nil
+	}
+	for i := range r.Entries {
+		e := &r.Entries[i]
+		if e.data == nil {
+			continue
+		}
+		if err := s.writeFile(e.Output, e.data); err != nil {
+			return err
+		}
+		e.data = nil
+	}
+	return nil
+}
+
+// writeFile writes one output, as a tar member named name or as is
+func (s *outputStream) writeFile(name string, data []byte) error {
+	if s.tar == nil {
+		if _, err := s.w.Write(data); err != nil {
+			return fmt.Errorf("failed to write image: %w", err)
+		}
+		return nil
+	}
+
+	header := &tar.Header{
+		Name:    filepath.ToSlash(name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: s.modTime,
+	}
+	if err := s.tar.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := s.tar.Write(data); err != nil {
+		return fmt.Errorf("failed to write tar stream: %w", err)
+	}
+	return nil
+}
+
+// close finishes the tar stream
+func (s *outputStream) close() error {
+	if s.tar == nil {
+		return nil
+	}
+	if err := s.tar.Close(); err != nil {
+		return fmt.Errorf("failed to write tar stream: %w", err)
+	}
+	return nil
+}
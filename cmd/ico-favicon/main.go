@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/thatoddmailbox/go-ico/favicon"
+)
+
+var (
+	outputDir  = flag.String("o", ".", "Output directory")
+	siteName   = flag.String("name", "", "Site name for the manifest")
+	shortName  = flag.String("short-name", "", "Short site name for the manifest")
+	themeColor = flag.String("theme-color", "", "Browser theme color (e.g. '#336699')")
+	background = flag.String("background", "#ffffff", "Background of the Apple touch and maskable icons")
+	padding    = flag.Float64("padding", favicon.DefaultPadding, "Fraction of the width left on each side of maskable icons (0 for none)")
+	basePath   = flag.String("base-path", "/", "URL path the files are served from")
+	verbose    = flag.Bool("v", false, "Verbose output")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <image>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Generate favicon.ico, touch and manifest icons, site.webmanifest and the\n")
+		fmt.Fprintf(os.Stderr, "HTML that links them from a master PNG, JPEG or GIF image. The HTML is\n")
+		fmt.Fprintf(os.Stderr, "printed to stdout.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s -o public logo.png                          # Write the icons to public/\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -name=Example -theme-color=#336699 logo.png # Fill in the manifest\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -base-path=/static/ -o static logo.png      # Serve from /static/\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := run(flag.Arg(0)); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+func run(path string) error {
+	master, err := loadImage(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if b := master.Bounds(); *verbose && (b.Dx() < 512 || b.Dy() < 512) {
+		fmt.Fprintf(os.Stderr, "Warning: master image is %dx%d; 512x512 or larger is recommended\n", b.Dx(), b.Dy())
+	}
+
+	opts := &favicon.Options{
+		Name:      *siteName,
+		ShortName: *shortName,
+		Padding:   *padding,
+		BasePath:  *basePath,
+	}
+	if *padding == 0 {
+		// Zero means the default padding in Options
+		opts.Padding = -1
+	}
+	if opts.Background, err = parseColor(*background); err != nil {
+		return err
+	}
+	if *themeColor != "" {
+		if opts.ThemeColor, err = parseColor(*themeColor); err != nil {
+			return err
+		}
+	}
+
+	bundle, err := favicon.Generate(master, opts)
+	if err != nil {
+		return err
+	}
+	if err := bundle.WriteDir(*outputDir); err != nil {
+		return err
+	}
+
+	if *verbose {
+		for _, f := range bundle.Files {
+			fmt.Fprintf(os.Stderr, "Wrote %s (%d bytes)\n", filepath.Join(*outputDir, f.Name), len(f.Data))
+		}
+	}
+	fmt.Print(bundle.HTML)
+	return nil
+}
+
+func loadImage(path string) (image.Image, error) {
+	var r io.Reader
+	if path == "-" {
+		r = os.Stdin
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// parseColor parses a #rgb or #rrggbb color
+func parseColor(spec string) (color.Color, error) {
+	hex := strings.TrimPrefix(spec, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color: %q (use #rrggbb)", spec)
+	}
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+var (
+	outputPath = flag.String("o", "", "Write the corrected file here instead of in place, or - for stdout (single input only)")
+	dryRun     = flag.Bool("n", false, "Report fixes without writing files")
+)
+
+// messages receives the report, which moves to stderr when an ICO file is
+// written to stdout
+var messages io.Writer = os.Stdout
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <ico-file> [ico-file...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Rebuild the directories of broken ICO and CUR files from their actual images,\n")
+		fmt.Fprintf(os.Stderr, "in place, or from stdin to stdout with -. Every fix is reported.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s favicon.ico                    # Repair a file in place\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -n *.ico                       # Only report what is wrong\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -o fixed.ico broken.ico        # Keep the original\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s - < broken.ico > fixed.ico     # Repair stdin to stdout\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	stdin := 0
+	for _, arg := range flag.Args() {
+		if arg == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		log.Fatalf("Error: standard input can only be read once")
+	}
+	if *outputPath != "" && flag.NArg() > 1 {
+		log.Fatalf("Error: -o can only be used with a single input")
+	}
+	if stdin > 0 && *outputPath == "" {
+		*outputPath = "-"
+	}
+	if *outputPath == "-" {
+		messages = os.Stderr
+	}
+
+	failed := false
+	for _, icoPath := range flag.Args() {
+		if err := fixFile(icoPath); err != nil {
+			log.Printf("Error processing %s: %v", icoPath, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// fixFile repairs a single ICO file and writes it to the output path, or
+// back in place if there is none. A consistent file is only rewritten when
+// it goes to another output.
+func fixFile(icoPath string) error {
+	var data []byte
+	var err error
+	if icoPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(icoPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	repaired, fixes, err := ico.Repair(data)
+	if err != nil {
+		return fmt.Errorf("failed to repair: %w", err)
+	}
+
+	if len(fixes) == 0 {
+		fmt.Fprintf(messages, "%s: no problems found\n", icoPath)
+	} else {
+		fmt.Fprintf(messages, "%s: %d fixes\n", icoPath, len(fixes))
+		for _, fix := range fixes {
+			fmt.Fprintf(messages, "  %s\n", fix)
+		}
+	}
+	if *dryRun || (len(fixes) == 0 && *outputPath == "") {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := ico.Encode(&buf, repaired, nil); err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	switch *outputPath {
+	case "":
+		err = replaceFile(icoPath, buf.Bytes())
+	case "-":
+		if _, err = os.Stdout.Write(buf.Bytes()); err != nil {
+			err = fmt.Errorf("failed to write output: %w", err)
+		}
+	default:
+		if err = os.WriteFile(*outputPath, buf.Bytes(), 0644); err != nil {
+			err = fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	return err
+}
+
+// replaceFile atomically replaces path with data, keeping its permissions.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ico-fix-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+var (
+	dryRun         = flag.Bool("n", false, "Report savings without rewriting files")
+	keepDuplicates = flag.Bool("keep-duplicates", false, "Keep entries whose pixels duplicate an earlier entry")
+	minPNGSize     = flag.Int("png-min", 0, "Store entries smaller than this many pixels as BMP, for loaders without PNG support (0 = no limit)")
+	omitANDMask    = flag.Bool("no-and-mask", false, "Omit the AND mask from 32-bit BMP entries")
+	verbose        = flag.Bool("v", false, "Verbose output")
+)
+
+// messages receives the report, which moves to stderr when an ICO file is
+// written to stdout
+var messages io.Writer = os.Stdout
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <ico-file> [ico-file...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Losslessly recompress ICO files in place, or from stdin to stdout with -.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s favicon.ico                    # Optimize a single file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -n *.ico                       # Show potential savings only\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -png-min=256 app.ico           # Keep small entries readable on Windows XP\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s - < in.ico > out.ico           # Optimize stdin to stdout\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	stdin := 0
+	for _, arg := range flag.Args() {
+		if arg == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		log.Fatalf("Error: standard input can only be read once")
+	}
+	if stdin > 0 {
+		messages = os.Stderr
+	}
+
+	opts := ico.OptimizeOptions{
+		KeepDuplicates: *keepDuplicates,
+		MinPNGSize:     *minPNGSize,
+		OmitANDMask:    *omitANDMask,
+	}
+
+	var totalBefore, totalAfter int
+	failed := false
+	for _, icoPath := range flag.Args() {
+		before, after, err := optimizeFile(icoPath, opts)
+		if err != nil {
+			log.Printf("Error processing %s: %v", icoPath, err)
+			failed = true
+			continue
+		}
+		totalBefore += before
+		totalAfter += after
+	}
+
+	if flag.NArg() > 1 {
+		fmt.Fprintf(messages, "Total: %d -> %d bytes (%s)\n", totalBefore, totalAfter, savings(totalBefore, totalAfter))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// optimizeFile optimizes a single ICO file, rewriting it unless this is a dry
+// run or the result would not be smaller. A path of - reads stdin and writes
+// the smaller of the two to stdout. It returns the old and new sizes.
+func optimizeFile(icoPath string, opts ico.OptimizeOptions) (int, int, error) {
+	var data []byte
+	var err error
+	if icoPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(icoPath)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	icoFile, err := ico.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode ICO: %w", err)
+	}
+
+	optimized, err := ico.Optimize(icoFile, opts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to optimize: %w", err)
+	}
+
+	if len(optimized) >= len(data) {
+		if icoPath == "-" && !*dryRun {
+			if _, err := os.Stdout.Write(data); err != nil {
+				return 0, 0, fmt.Errorf("failed to write output: %w", err)
+			}
+		}
+		fmt.Fprintf(messages, "%s: %d bytes, already optimal\n", icoPath, len(data))
+		return len(data), len(data), nil
+	}
+
+	if *verbose {
+		if dropped := len(icoFile.Images) - countImages(optimized); dropped > 0 {
+			fmt.Fprintf(messages, "  Dropped %d duplicate entries\n", dropped)
+		}
+	}
+
+	if !*dryRun {
+		if icoPath == "-" {
+			_, err = os.Stdout.Write(optimized)
+			if err != nil {
+				err = fmt.Errorf("failed to write output: %w", err)
+			}
+		} else {
+			err = replaceFile(icoPath, optimized)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	fmt.Fprintf(messages, "%s: %d -> %d bytes (%s)\n", icoPath, len(data), len(optimized), savings(len(data), len(optimized)))
+	return len(data), len(optimized), nil
+}
+
+// countImages returns the image count from an ICO header.
+func countImages(data []byte) int {
+	config, err := ico.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	return config.Count
+}
+
+// replaceFile atomically replaces path with data, keeping its permissions.
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ico-optimize-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
+
+func savings(before, after int) string {
+	if before == 0 {
+		return "no change"
+	}
+	return fmt.Sprintf("-%.1f%%", 100*float64(before-after)/float64(before))
+}
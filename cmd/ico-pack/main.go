@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/thatoddmailbox/go-ico"
+	"github.com/thatoddmailbox/go-ico/quantize"
+	"github.com/thatoddmailbox/go-ico/resample"
+)
+
+var (
+	outputPath = flag.String("o", "-", "Output file, or - for stdout")
+	sizesSpec  = flag.String("sizes", "", "Comma-separated sizes to generate by resampling (e.g. '16,32,48,256')")
+	pngMin     = flag.Int("png-min", 256, "Store entries at least this many pixels wide or tall as PNG (0 = always PNG)")
+	bitDepth   = flag.Int("bpp", 32, "Bit depth of BMP entries (1, 4, 8, 24 or 32)")
+	dither     = flag.Bool("dither", false, "Dither entries of 8 bpp or fewer")
+	winPalette = flag.Bool("windows-palette", false, "Use the standard Windows palettes for entries of 8 bpp or fewer")
+	hotspot    = flag.String("hotspot", "", "Write a cursor with this hotspot (e.g. '4,2'), in pixels of the largest entry")
+	cursor     = flag.Bool("cur", false, "Write a CUR file (implied by -hotspot or a .cur output name)")
+	verbose    = flag.Bool("v", false, "Verbose output")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <image> [image...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Build an ICO or CUR file from PNG, JPEG or GIF images.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s -o app.ico 16.png 32.png 48.png           # One entry per image\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -sizes=16,32,48,256 -o app.ico logo.png   # Resample a master image\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -bpp=8 -dither -o legacy.ico icon.png     # 256-color BMP entries\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -hotspot=0,0 -o arrow.cur arrow.png       # Build a cursor\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -sizes=16,32 logo.png > favicon.ico       # Write to stdout\n", os.Args[0])
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := run(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+func run() error {
+	switch *bitDepth {
+	case 1, 4, 8, 24, 32:
+	default:
+		return fmt.Errorf("invalid bit depth: %d (use 1, 4, 8, 24 or 32)", *bitDepth)
+	}
+
+	var inputs []image.Image
+	for _, path := range flag.Args() {
+		img, err := loadImage(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		inputs = append(inputs, img)
+	}
+
+	var icoFile *ico.ICO
+	if *sizesSpec != "" {
+		sizes, err := parseSizes(*sizesSpec)
+		if err != nil {
+			return err
+		}
+		icoFile = buildFromSizes(inputs, sizes)
+	} else {
+		icoFile = buildFromInputs(inputs)
+	}
+
+	isCursor := *cursor || *hotspot != "" || strings.EqualFold(filepath.Ext(*outputPath), ".cur")
+	if isCursor {
+		if err := applyHotspot(icoFile, *hotspot); err != nil {
+			return err
+		}
+	}
+
+	opts := &ico.EncodeOptions{
+		Policy: func(icoFile *ico.ICO, i int) ico.EntryEncoding {
+			b := icoFile.Images[i].Bounds()
+			if b.Dx() >= *pngMin || b.Dy() >= *pngMin {
+				return ico.EntryEncoding{Format: ico.FormatPNG, BitsPerPixel: 32}
+			}
+			return ico.EntryEncoding{Format: ico.FormatBMP, BitsPerPixel: *bitDepth}
+		},
+		Dither: *dither,
+	}
+	if *winPalette {
+		opts.Quantizer = quantize.Windows{}
+	}
+
+	var buf bytes.Buffer
+	if err := ico.Encode(&buf, icoFile, opts); err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	if *verbose {
+		for _, img := range icoFile.Images {
+			b := img.Bounds()
+			fmt.Fprintf(os.Stderr, "  Entry: %dx%d\n", b.Dx(), b.Dy())
+		}
+	}
+
+	if *outputPath == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(*outputPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	kind := "icon"
+	if isCursor {
+		kind = "cursor"
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s: %s (%d images, %d bytes)\n", kind, *outputPath, len(icoFile.Images), buf.Len())
+	return nil
+}
+
+func loadImage(path string) (image.Image, error) {
+	var r io.Reader
+	if path == "-" {
+		r = os.Stdin
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+func parseSizes(spec string) ([]int, error) {
+	var sizes []int
+	for _, part := range strings.Split(spec, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || size < 1 || size > 256 {
+			return nil, fmt.Errorf("invalid size: %q (use 1 to 256)", part)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// buildFromSizes produces one entry per size. Each is taken from the smallest
+// input that is at least as large, or the largest input otherwise, and
+// resampled if its size differs.
+func buildFromSizes(inputs []image.Image, sizes []int) *ico.ICO {
+	sorted := append([]image.Image(nil), inputs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return longestSide(sorted[i]) < longestSide(sorted[j])
+	})
+
+	icoFile := &ico.ICO{Header: ico.Header{Type: ico.TypeICO}}
+	for _, size := range sizes {
+		src := sorted[len(sorted)-1]
+		for _, img := range sorted {
+			if longestSide(img) >= size {
+				src = img
+				break
+			}
+		}
+
+		part := ico.FromMaster(src, []int{size})
+		icoFile.Entries = append(icoFile.Entries, part.Entries...)
+		icoFile.Images = append(icoFile.Images, part.Images...)
+	}
+	icoFile.Header.Count = uint16(len(icoFile.Images))
+	return icoFile
+}
+
+// buildFromInputs uses each input at its native size, scaling down only
+// inputs too large for an ICO entry.
+func buildFromInputs(inputs []image.Image) *ico.ICO {
+	icoFile := &ico.ICO{Header: ico.Header{Type: ico.TypeICO}}
+	for _, img := range inputs {
+		b := img.Bounds()
+		if b.Dx() > 256 || b.Dy() > 256 {
+			w, h := 256, 256
+			if b.Dx() > b.Dy() {
+				h = max(1, b.Dy()*256/b.Dx())
+			} else {
+				w = max(1, b.Dx()*256/b.Dy())
+			}
+			if *verbose {
+				fmt.Fprintf(os.Stderr, "  Scaling %dx%d input down to %dx%d\n", b.Dx(), b.Dy(), w, h)
+			}
+			img = resample.Resize(img, w, h, nil)
+		}
+
+		b = img.Bounds()
+		icoFile.Entries = append(icoFile.Entries, ico.DirectoryEntry{
+			Width:        uint8(b.Dx()), // 256 wraps to 0, as the format expects
+			Height:       uint8(b.Dy()),
+			ColorPlanes:  1,
+			BitsPerPixel: 32,
+		})
+		icoFile.Images = append(icoFile.Images, img)
+	}
+	icoFile.Header.Count = uint16(len(icoFile.Images))
+	return icoFile
+}
+
+// applyHotspot turns icoFile into a cursor. The hotspot is given in pixels of
+// the largest entry and scaled proportionally for the others.
+func applyHotspot(icoFile *ico.ICO, spec string) error {
+	var hx, hy int
+	if spec != "" {
+		parts := strings.Split(spec, ",")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid hotspot: %s (use format like '4,2')", spec)
+		}
+		var err error
+		if hx, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+			return fmt.Errorf("invalid hotspot x: %s", parts[0])
+		}
+		if hy, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return fmt.Errorf("invalid hotspot y: %s", parts[1])
+		}
+	}
+
+	var largest image.Rectangle
+	for _, img := range icoFile.Images {
+		if b := img.Bounds(); b.Dx()*b.Dy() > largest.Dx()*largest.Dy() {
+			largest = b
+		}
+	}
+	if hx < 0 || hy < 0 || hx >= largest.Dx() || hy >= largest.Dy() {
+		return fmt.Errorf("hotspot %d,%d is outside the %dx%d image", hx, hy, largest.Dx(), largest.Dy())
+	}
+
+	icoFile.Header.Type = ico.TypeCUR
+	for i, img := range icoFile.Images {
+		b := img.Bounds()
+		icoFile.Entries[i].SetHotspot(image.Pt(hx*b.Dx()/largest.Dx(), hy*b.Dy()/largest.Dy()))
+	}
+	return nil
+}
+
+func longestSide(img image.Image) int {
+	b := img.Bounds()
+	return max(b.Dx(), b.Dy())
+}
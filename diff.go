@@ -0,0 +1,225 @@
+package ico
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// FieldChange is a header or directory entry field whose value differs
+// between two ICO files.
+type FieldChange struct {
+	Field    string
+	Old, New int
+}
+
+// EntryDiff compares an entry of one ICO file with its match in another.
+type EntryDiff struct {
+	A, B int // Indexes of the entries in the old and new ICO
+
+	Old, New EntryInfo     // The entries as described by Filter
+	Fields   []FieldChange // Directory fields that differ, including Size but not Offset
+
+	// Pixel differences, comparing premultiplied RGBA channels
+	PixelsChanged int     // Pixels with any channel that differs
+	MaxDelta      int     // Largest difference of any channel, from 0 to 255
+	PSNR          float64 // Peak signal-to-noise ratio in dB, or +Inf if identical
+
+	oldImage, newImage image.Image
+}
+
+// Identical reports whether the entries have the same pixels, format and
+// directory fields.
+func (d *EntryDiff) Identical() bool {
+	return d.PixelsChanged == 0 && len(d.Fields) == 0 && d.Old.Format == d.New.Format
+}
+
+// Image returns an image that highlights the pixels that differ, as
+// HighlightDiff does.
+func (d *EntryDiff) Image() *image.NRGBA {
+	return HighlightDiff(d.oldImage, d.newImage)
+}
+
+// DiffResult is the difference between two ICO files, as returned by Diff.
+type DiffResult struct {
+	Header  []FieldChange // Header fields that differ
+	Removed []EntryInfo   // Entries of the old ICO with no match
+	Added   []EntryInfo   // Entries of the new ICO with no match
+	Matched []EntryDiff   // Matched entries, in the order of the old ICO
+}
+
+// Identical reports whether the two ICO files hold the same entries with
+// the same pixels and directory fields.
+func (r *DiffResult) Identical() bool {
+	if len(r.Header) > 0 || len(r.Removed) > 0 || len(r.Added) > 0 {
+		return false
+	}
+	for i := range r.Matched {
+		if !r.Matched[i].Identical() {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff compares two ICO files, such as two revisions of an icon. Entries
+// are matched by their dimensions and bit depth, in order when several
+// share both; entries left over are then matched by dimensions alone, so
+// an entry whose bit depth changed is matched rather than removed and
+// added. For each match, Diff reports the directory fields that changed
+// and compares the pixels. Dimensions and bit depths are read from the
+// payloads, as Filter does. Neither a nor b is modified.
+func Diff(a, b *ICO) *DiffResult {
+	a, b = a.synced(), b.synced()
+
+	r := &DiffResult{}
+	if a.Header.Type != b.Header.Type {
+		r.Header = append(r.Header, FieldChange{"Type", int(a.Header.Type), int(b.Header.Type)})
+	}
+	if len(a.Images) != len(b.Images) {
+		r.Header = append(r.Header, FieldChange{"Count", len(a.Images), len(b.Images)})
+	}
+
+	oldInfo := make([]EntryInfo, len(a.Images))
+	for i := range oldInfo {
+		oldInfo[i] = a.entryInfo(i)
+	}
+	newInfo := make([]EntryInfo, len(b.Images))
+	for i := range newInfo {
+		newInfo[i] = b.entryInfo(i)
+	}
+
+	matches := make([]int, len(oldInfo))
+	claimed := make([]bool, len(newInfo))
+	for i := range matches {
+		matches[i] = -1
+	}
+	for _, sameDepth := range []bool{true, false} {
+		for i, o := range oldInfo {
+			if matches[i] >= 0 {
+				continue
+			}
+			for j, n := range newInfo {
+				if !claimed[j] && o.Width == n.Width && o.Height == n.Height && (!sameDepth || o.BitsPerPixel == n.BitsPerPixel) {
+					matches[i], claimed[j] = j, true
+					break
+				}
+			}
+		}
+	}
+
+	for i, j := range matches {
+		if j < 0 {
+			r.Removed = append(r.Removed, oldInfo[i])
+			continue
+		}
+		d := EntryDiff{A: i, B: j, Old: oldInfo[i], New: newInfo[j], oldImage: a.Images[i], newImage: b.Images[j]}
+		d.Fields = diffEntries(a.Entries[i], b.Entries[j], a.Header.Type == TypeCUR && b.Header.Type == TypeCUR)
+		d.PixelsChanged, d.MaxDelta, d.PSNR = comparePixels(a.Images[i], b.Images[j])
+		r.Matched = append(r.Matched, d)
+	}
+	for j, n := range newInfo {
+		if !claimed[j] {
+			r.Added = append(r.Added, n)
+		}
+	}
+	return r
+}
+
+// diffEntries lists the fields that differ between two directory entries,
+// naming the hotspot fields of cursors. Offsets are left out, as they only
+// follow from the sizes of the entries before.
+func diffEntries(a, b DirectoryEntry, cursor bool) []FieldChange {
+	planes, bpp := "ColorPlanes", "BitsPerPixel"
+	if cursor {
+		planes, bpp = "HotspotX", "HotspotY"
+	}
+	fields := []struct {
+		name     string
+		old, new int
+	}{
+		{"Width", a.GetWidth(), b.GetWidth()},
+		{"Height", a.GetHeight(), b.GetHeight()},
+		{"ColorCount", int(a.ColorCount), int(b.ColorCount)},
+		{"Reserved", int(a.Reserved), int(b.Reserved)},
+		{planes, int(a.ColorPlanes), int(b.ColorPlanes)},
+		{bpp, int(a.BitsPerPixel), int(b.BitsPerPixel)},
+		{"Size", int(a.Size), int(b.Size)},
+	}
+
+	var changes []FieldChange
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, FieldChange{f.name, f.old, f.new})
+		}
+	}
+	return changes
+}
+
+// comparePixels compares two images pixel by pixel, aligned at their top
+// left corners, treating pixels outside either image as transparent
+func comparePixels(a, b image.Image) (changed, maxDelta int, psnr float64) {
+	ab, bb := a.Bounds(), b.Bounds()
+	w, h := max(ab.Dx(), bb.Dx()), max(ab.Dy(), bb.Dy())
+	var sum float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ca, cb := pixelAt(a, x, y), pixelAt(b, x, y)
+			delta := channelDelta(ca, cb)
+			if delta > 0 {
+				changed++
+				maxDelta = max(maxDelta, delta)
+			}
+			for _, d := range []int{int(ca.R) - int(cb.R), int(ca.G) - int(cb.G), int(ca.B) - int(cb.B), int(ca.A) - int(cb.A)} {
+				sum += float64(d * d)
+			}
+		}
+	}
+
+	if changed == 0 {
+		return 0, 0, math.Inf(1)
+	}
+	mse := sum / float64(w*h*4)
+	return changed, maxDelta, 10 * math.Log10(255*255/mse)
+}
+
+// pixelAt returns the premultiplied color of img at x, y relative to its
+// top left corner, or transparent outside it
+func pixelAt(img image.Image, x, y int) color.RGBA {
+	b := img.Bounds()
+	if x >= b.Dx() || y >= b.Dy() {
+		return color.RGBA{}
+	}
+	return color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+}
+
+// channelDelta returns the largest difference between the channels of two
+// colors
+func channelDelta(a, b color.RGBA) int {
+	return max(abs(int(a.R)-int(b.R)), abs(int(a.G)-int(b.G)), abs(int(a.B)-int(b.B)), abs(int(a.A)-int(b.A)))
+}
+
+// HighlightDiff returns an opaque image the size of the larger of two
+// images, aligned at their top left corners, that shows where they differ.
+// Pixels that differ are red, brighter the larger the difference; the rest
+// show the new image faded to light gray over white, for context.
+func HighlightDiff(a, b image.Image) *image.NRGBA {
+	ab, bb := a.Bounds(), b.Bounds()
+	w, h := max(ab.Dx(), bb.Dx()), max(ab.Dy(), bb.Dy())
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ca, cb := pixelAt(a, x, y), pixelAt(b, x, y)
+			if delta := channelDelta(ca, cb); delta > 0 {
+				dst.SetNRGBA(x, y, color.NRGBA{uint8(128 + delta*127/255), 0, 0, 0xFF})
+				continue
+			}
+			// Composite over white, then fade the luminance
+			white := 0xFF - int(cb.A)
+			lum := (299*(int(cb.R)+white) + 587*(int(cb.G)+white) + 114*(int(cb.B)+white)) / 1000
+			gray := uint8(192 + lum/4)
+			dst.SetNRGBA(x, y, color.NRGBA{gray, gray, gray, 0xFF})
+		}
+	}
+	return dst
+}
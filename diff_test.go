@@ -0,0 +1,104 @@
+package ico
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestDiffIdentical(t *testing.T) {
+	data := createMixedICO(t)
+	a, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	b, _ := Decode(bytes.NewReader(data))
+
+	r := Diff(a, b)
+	if !r.Identical() || len(r.Matched) != 2 {
+		t.Fatalf("Expected two identical entries, got %+v", r)
+	}
+	if d := r.Matched[1]; d.A != 1 || d.B != 1 || !math.IsInf(d.PSNR, 1) || d.New.Format != FormatPNG {
+		t.Errorf("Unexpected match %+v", d)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := createMultiSizeICO(16, 32, 48)
+	b := createMultiSizeICO(32, 16, 64)
+
+	// Change one pixel of the 16px entry by 100, and the depth of the
+	// 32px entry
+	b.Images[1].(*image.NRGBA).Pix[0] += 100
+	b.Entries[0].BitsPerPixel = 8
+
+	r := Diff(a, b)
+	if r.Identical() {
+		t.Fatal("Expected differences")
+	}
+	if len(r.Header) != 0 {
+		t.Errorf("Expected no header changes, got %+v", r.Header)
+	}
+	if len(r.Removed) != 1 || r.Removed[0].Width != 48 || len(r.Added) != 1 || r.Added[0].Width != 64 {
+		t.Fatalf("Expected 48px removed and 64px added, got %+v and %+v", r.Removed, r.Added)
+	}
+	if len(r.Matched) != 2 {
+		t.Fatalf("Expected 2 matches, got %d", len(r.Matched))
+	}
+
+	small := r.Matched[0]
+	if small.A != 0 || small.B != 1 || small.PixelsChanged != 1 || small.MaxDelta != 100 || len(small.Fields) != 0 {
+		t.Errorf("Unexpected diff of the 16px entry: %+v", small)
+	}
+	if want := 10 * math.Log10(255*255/(100.0*100/(16*16*4))); math.Abs(small.PSNR-want) > 1e-9 {
+		t.Errorf("Expected PSNR %.3f, got %.3f", want, small.PSNR)
+	}
+
+	// The 32px entry is matched by size despite its new depth
+	large := r.Matched[1]
+	if large.A != 1 || large.B != 0 || large.PixelsChanged != 0 ||
+		!slices.Equal(large.Fields, []FieldChange{{"BitsPerPixel", 32, 8}}) {
+		t.Errorf("Unexpected diff of the 32px entry: %+v", large)
+	}
+}
+
+func TestDiffLeavesInputs(t *testing.T) {
+	a := createMultiSizeICO(16, 32)
+	b := createMultiSizeICO(16, 32)
+
+	// Only the offset of the 16px entry differs, as if an earlier entry had
+	// grown, and neither ICO has an entry for its 32px image
+	a.Entries = a.Entries[:1]
+	a.Entries[0].Offset, a.Entries[0].Size = 38, 100
+	b.Entries = []DirectoryEntry{a.Entries[0]}
+	b.Entries[0].Offset = 200
+
+	if r := Diff(a, b); !r.Identical() {
+		t.Errorf("Expected entries differing only in offset to be identical, got %+v", r)
+	}
+	if len(a.Entries) != 1 || len(b.Entries) != 1 {
+		t.Errorf("Expected Diff to leave the entries alone, got %d and %d", len(a.Entries), len(b.Entries))
+	}
+}
+
+func TestHighlightDiff(t *testing.T) {
+	a := createTestImage(8)
+	b := image.NewNRGBA(a.Rect)
+	copy(b.Pix, a.Pix)
+	b.SetNRGBA(1, 1, color.NRGBA{0, 0, 0, 0xFF})
+
+	img := HighlightDiff(a, b)
+	if got := img.NRGBAAt(1, 1); got != (color.NRGBA{0xFF, 0, 0, 0xFF}) {
+		t.Errorf("Expected a fully changed pixel to be bright red, got %v", got)
+	}
+	// An unchanged white pixel is faded to light gray
+	if got := img.NRGBAAt(7, 7); got != (color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("Expected white to stay white, got %v", got)
+	}
+	if got := img.NRGBAAt(0, 0); got.R != got.G || got.R < 192 {
+		t.Errorf("Expected unchanged pixels in light gray, got %v", got)
+	}
+}
@@ -0,0 +1,512 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// RegionKind identifies what a region of an ICO file holds.
+type RegionKind int
+
+const (
+	RegionHeader         RegionKind = iota // The 6-byte file header
+	RegionDirectoryEntry                   // A 16-byte directory entry
+	RegionPayload                          // The image data of a directory entry
+	RegionBMPHeader                        // BMP info header of a payload
+	RegionBitfields                        // BI_BITFIELDS channel masks after a 40-byte BMP header
+	RegionPalette                          // BMP color table
+	RegionXORMask                          // BMP pixel data
+	RegionANDMask                          // BMP 1-bit transparency mask
+	RegionPNGSignature                     // The 8-byte PNG signature
+	RegionPNGChunk                         // A PNG chunk, with its length, type and CRC
+	RegionUnused                           // Bytes of a payload that no part of it uses
+	RegionGap                              // Bytes between the header, directory and payloads
+	RegionTrailing                         // Bytes after everything else
+)
+
+var regionKindNames = []string{
+	"header", "directory entry", "payload", "BMP info header", "bitfields", "palette",
+	"XOR mask", "AND mask", "PNG signature", "PNG chunk", "unused", "gap", "trailing data",
+}
+
+func (k RegionKind) String() string {
+	if k < 0 || int(k) >= len(regionKindNames) {
+		return fmt.Sprintf("RegionKind(%d)", int(k))
+	}
+	return regionKindNames[k]
+}
+
+// MarshalText encodes the kind as its name, for JSON output
+func (k RegionKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Field is a value decoded from a region.
+type Field struct {
+	Offset int    // From the start of the file
+	Size   int    // In bytes
+	Name   string // Name of the field in the format's specification
+	Value  string // The value, with its meaning where it has one
+}
+
+// Region is a span of bytes of an ICO file and what it holds.
+type Region struct {
+	Kind     RegionKind
+	Offset   int    // From the start of the file
+	Size     int    // In bytes, counting only bytes within the file
+	Entry    int    // Index of the directory entry it belongs to, or -1
+	Detail   string // Short description, such as a chunk type or mask dimensions
+	Fields   []Field
+	Children []Region // Parts of a payload, in file order
+	Problems []string // What is wrong with the region
+}
+
+// End returns the offset just past the region.
+func (r Region) End() int {
+	return r.Offset + r.Size
+}
+
+// Overlap is a span of bytes claimed by two top-level regions.
+type Overlap struct {
+	Offset, Size int
+	A, B         int // Indexes of the regions in Layout.Regions
+}
+
+// Layout is an annotated map of an ICO or CUR file, as returned by Dissect.
+type Layout struct {
+	Size     int      // Size of the file in bytes
+	Header   Header   // As read from the file, even if invalid
+	Regions  []Region // Top-level regions ordered by offset, covering the whole file
+	Overlaps []Overlap
+	Problems []string // Problems with the file as a whole
+}
+
+// Dissect maps the structure of an ICO or CUR file for debugging: the
+// header and directory entries with their fields, each payload split into
+// its BMP info header, palette, XOR and AND masks or its PNG chunks, and
+// the gaps, overlaps and trailing data between them. It never fails: a
+// file that cannot be fully parsed is mapped as far as possible, with
+// problems recorded in the layout and its regions, and any bytes it does
+// not account for are reported as gaps or trailing data.
+func Dissect(r io.Reader) *Layout {
+	data, err := io.ReadAll(r)
+	layout := &Layout{Size: len(data)}
+	if err != nil {
+		layout.Problems = append(layout.Problems, fmt.Sprintf("failed to read data: %v", err))
+	}
+
+	var regions []Region
+	if len(data) < 6 {
+		layout.Problems = append(layout.Problems, fmt.Sprintf("file too short: need 6 bytes for the header, have %d", len(data)))
+	} else {
+		header, count := dissectHeader(data, layout)
+		regions = append(regions, header)
+		regions = append(regions, dissectDirectory(data, count, layout)...)
+	}
+
+	layout.Regions = fillGaps(regions, len(data))
+	layout.Overlaps = findOverlaps(layout.Regions)
+	return layout
+}
+
+// dissectHeader reads the file header and returns its region and the
+// number of directory entries it declares
+func dissectHeader(data []byte, layout *Layout) (Region, int) {
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, &layout.Header)
+	h := layout.Header
+
+	region := Region{Kind: RegionHeader, Offset: 0, Size: 6, Entry: -1}
+	typeName := "unknown"
+	switch h.Type {
+	case TypeICO:
+		typeName = "icon"
+	case TypeCUR:
+		typeName = "cursor"
+	}
+	region.Fields = []Field{
+		{0, 2, "Reserved", fmt.Sprint(h.Reserved)},
+		{2, 2, "Type", fmt.Sprintf("%d (%s)", h.Type, typeName)},
+		{4, 2, "Count", fmt.Sprint(h.Count)},
+	}
+
+	if h.Reserved != 0 {
+		region.Problems = append(region.Problems, "reserved field is not 0")
+	}
+	if h.Type != TypeICO && h.Type != TypeCUR {
+		region.Problems = append(region.Problems, fmt.Sprintf("unsupported type %d: expected 1 (icon) or 2 (cursor)", h.Type))
+	}
+	if h.Count == 0 {
+		region.Problems = append(region.Problems, "file contains no images")
+	}
+	return region, int(h.Count)
+}
+
+// dissectDirectory returns the regions of the directory entries and their
+// payloads
+func dissectDirectory(data []byte, count int, layout *Layout) []Region {
+	cursor := layout.Header.Type == TypeCUR
+	var entries, payloads []Region
+	for i := 0; i < count; i++ {
+		offset := 6 + 16*i
+		if offset+16 > len(data) {
+			layout.Problems = append(layout.Problems, fmt.Sprintf("directory truncated: the file ends within entry %d of %d", i, count))
+			break
+		}
+
+		var e DirectoryEntry
+		binary.Read(bytes.NewReader(data[offset:]), binary.LittleEndian, &e)
+		region := Region{Kind: RegionDirectoryEntry, Offset: offset, Size: 16, Entry: i}
+		region.Fields = []Field{
+			{offset, 1, "Width", sizeValue(e.Width)},
+			{offset + 1, 1, "Height", sizeValue(e.Height)},
+			{offset + 2, 1, "ColorCount", fmt.Sprint(e.ColorCount)},
+			{offset + 3, 1, "Reserved", fmt.Sprint(e.Reserved)},
+		}
+		if cursor {
+			region.Fields = append(region.Fields,
+				Field{offset + 4, 2, "HotspotX", fmt.Sprint(e.ColorPlanes)},
+				Field{offset + 6, 2, "HotspotY", fmt.Sprint(e.BitsPerPixel)})
+		} else {
+			region.Fields = append(region.Fields,
+				Field{offset + 4, 2, "ColorPlanes", fmt.Sprint(e.ColorPlanes)},
+				Field{offset + 6, 2, "BitsPerPixel", fmt.Sprint(e.BitsPerPixel)})
+		}
+		region.Fields = append(region.Fields,
+			Field{offset + 8, 4, "Size", fmt.Sprint(e.Size)},
+			Field{offset + 12, 4, "Offset", fmt.Sprint(e.Offset)})
+
+		payload, ok := dissectPayload(data, i, e, cursor)
+		if ok {
+			payloads = append(payloads, payload)
+		} else {
+			region.Problems = append(region.Problems, payload.Problems...)
+		}
+		entries = append(entries, region)
+	}
+	return append(entries, payloads...)
+}
+
+// sizeValue formats a directory entry width or height
+func sizeValue(v uint8) string {
+	if v == 0 {
+		return "0 (256)"
+	}
+	return fmt.Sprint(v)
+}
+
+// dissectPayload returns the region of the payload of entry i. It reports
+// false, with the problem in the region, if the payload lies entirely
+// outside the file.
+func dissectPayload(data []byte, i int, e DirectoryEntry, cursor bool) (Region, bool) {
+	region := Region{Kind: RegionPayload, Offset: int(e.Offset), Entry: i}
+	if e.Size == 0 {
+		region.Problems = append(region.Problems, "payload size is 0")
+		return region, false
+	}
+	if uint64(e.Offset) >= uint64(len(data)) {
+		region.Problems = append(region.Problems, fmt.Sprintf("payload at %d is beyond the end of the file (%d bytes)", e.Offset, len(data)))
+		return region, false
+	}
+
+	end := uint64(e.Offset) + uint64(e.Size)
+	if end > uint64(len(data)) {
+		region.Problems = append(region.Problems, fmt.Sprintf("payload truncated: declares %d bytes, the file has %d from offset %d",
+			e.Size, len(data)-int(e.Offset), e.Offset))
+		end = uint64(len(data))
+	}
+	payload := data[e.Offset:end]
+	region.Size = len(payload)
+
+	if bytes.HasPrefix(payload, pngSignature) {
+		region.Detail = "PNG"
+		dissectPNG(payload, &region)
+	} else {
+		region.Detail = "BMP"
+		dissectBMP(payload, &region)
+	}
+
+	// Compare the directory entry with the payload's own header
+	if format, width, height, bpp, err := inspectPayload(payload); err == nil {
+		if width != e.GetWidth() || height != e.GetHeight() {
+			region.Problems = append(region.Problems, fmt.Sprintf("directory entry declares %dx%d, payload is %dx%d",
+				e.GetWidth(), e.GetHeight(), width, height))
+		}
+		// Loaders ignore the declared depth of PNG entries, and encoders
+		// commonly declare 32 bpp for opaque RGB images
+		if format == FormatBMP && !cursor && e.BitsPerPixel != 0 && int(e.BitsPerPixel) != bpp {
+			region.Problems = append(region.Problems, fmt.Sprintf("directory entry declares %d bpp, payload has %d",
+				e.BitsPerPixel, bpp))
+		}
+	}
+	return region, true
+}
+
+// dissectBMP splits a BMP payload into its parts
+func dissectBMP(p []byte, region *Region) {
+	base, entry := region.Offset, region.Entry
+	// part appends a part; its pointer is valid until the next is appended
+	part := func(kind RegionKind, offset, size int, detail string) *Region {
+		region.Children = append(region.Children, Region{Kind: kind, Offset: base + offset, Size: size, Entry: entry, Detail: detail})
+		return &region.Children[len(region.Children)-1]
+	}
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(p[offset:]) }
+	u16 := func(offset int) uint16 { return binary.LittleEndian.Uint16(p[offset:]) }
+
+	if len(p) < 4 {
+		region.Problems = append(region.Problems, "payload too short for a BMP info header")
+		part(RegionUnused, 0, len(p), "")
+		return
+	}
+	headerSize := int(u32(0))
+	if headerSize < 40 {
+		region.Problems = append(region.Problems, fmt.Sprintf("unsupported BMP info header size %d: expected at least 40", headerSize))
+		part(RegionUnused, 0, len(p), "")
+		return
+	}
+
+	// Decode the BITMAPINFOHEADER fields that are present
+	part(RegionBMPHeader, 0, min(headerSize, len(p)), "")
+	header := func() *Region { return &region.Children[0] }
+	if headerSize > 40 {
+		header().Detail = fmt.Sprintf("%d-byte extended header", headerSize)
+	}
+	compressions := map[uint32]string{0: "BI_RGB", 1: "BI_RLE8", 2: "BI_RLE4", 3: "BI_BITFIELDS", 4: "BI_JPEG", 5: "BI_PNG", 6: "BI_ALPHABITFIELDS"}
+	fields := []struct {
+		offset, size int
+		name         string
+		format       func(v uint32) string
+	}{
+		{0, 4, "biSize", nil},
+		{4, 4, "biWidth", func(v uint32) string { return fmt.Sprint(int32(v)) }},
+		{8, 4, "biHeight", func(v uint32) string { return fmt.Sprintf("%d (image and AND mask)", int32(v)) }},
+		{12, 2, "biPlanes", nil},
+		{14, 2, "biBitCount", nil},
+		{16, 4, "biCompression", func(v uint32) string {
+			if name, ok := compressions[v]; ok {
+				return fmt.Sprintf("%d (%s)", v, name)
+			}
+			return fmt.Sprint(v)
+		}},
+		{20, 4, "biSizeImage", nil},
+		{24, 4, "biXPelsPerMeter", nil},
+		{28, 4, "biYPelsPerMeter", nil},
+		{32, 4, "biClrUsed", nil},
+		{36, 4, "biClrImportant", nil},
+	}
+	for _, f := range fields {
+		if f.offset+f.size > len(p) {
+			break
+		}
+		v := uint32(u16(f.offset))
+		if f.size == 4 {
+			v = u32(f.offset)
+		}
+		value := fmt.Sprint(v)
+		if f.format != nil {
+			value = f.format(v)
+		}
+		header().Fields = append(header().Fields, Field{base + f.offset, f.size, f.name, value})
+	}
+	if len(p) < 40 {
+		header().Problems = append(header().Problems, fmt.Sprintf("BMP info header truncated: %d of %d bytes", len(p), headerSize))
+		return
+	}
+	if headerSize > len(p) {
+		header().Problems = append(header().Problems, fmt.Sprintf("BMP info header truncated: %d of %d bytes", len(p), headerSize))
+		return
+	}
+
+	width, height := int(int32(u32(4))), int(int32(u32(8)))
+	bpp, compression, colorsUsed := int(u16(14)), u32(16), int(u32(32))
+	if height%2 != 0 {
+		header().Problems = append(header().Problems, fmt.Sprintf("odd biHeight %d: ICO bitmaps have an image and an AND mask of equal height", height))
+	}
+	width, height = abs(width), abs(height)/2
+	if compression != 0 && compression != 3 {
+		header().Problems = append(header().Problems, "compressed bitmaps are not supported in icons")
+	}
+
+	// The rest of the payload is laid out in order; each part is clipped to
+	// the payload, noting what is missing. next returns nil for a part that
+	// is missing entirely.
+	offset := headerSize
+	next := func(kind RegionKind, size int, detail string) *Region {
+		available := max(0, min(size, len(p)-offset))
+		if available == 0 {
+			if size > 0 {
+				region.Problems = append(region.Problems, fmt.Sprintf("%s missing: needs %d bytes", kind, size))
+			}
+			return nil
+		}
+		r := part(kind, offset, available, detail)
+		if available < size {
+			r.Problems = append(r.Problems, fmt.Sprintf("%s truncated: needs %d bytes, payload has %d", kind, size, available))
+		}
+		offset += available
+		return r
+	}
+
+	if compression == 3 && headerSize == 40 {
+		if masks := next(RegionBitfields, 12, ""); masks != nil {
+			for i, name := range []string{"RedMask", "GreenMask", "BlueMask"} {
+				if o := 40 + 4*i; o+4 <= len(p) {
+					masks.Fields = append(masks.Fields, Field{base + o, 4, name, fmt.Sprintf("0x%08X", u32(o))})
+				}
+			}
+		}
+	}
+
+	if bpp <= 8 && bpp > 0 {
+		colors := 1 << bpp
+		if colorsUsed > 0 && colorsUsed < colors {
+			header().Problems = append(header().Problems, fmt.Sprintf("biClrUsed is %d, but Decode reads a full %d-color palette", colorsUsed, colors))
+			colors = colorsUsed
+		}
+		start := offset
+		palette := next(RegionPalette, 4*colors, fmt.Sprintf("%d colors", colors))
+		for i := 0; palette != nil && i < palette.Size/4; i++ {
+			o := start + 4*i
+			palette.Fields = append(palette.Fields, Field{base + o, 4, fmt.Sprintf("Color %d", i),
+				fmt.Sprintf("#%02X%02X%02X", p[o+2], p[o+1], p[o])})
+		}
+	}
+
+	xorStride := (width*bpp + 31) / 32 * 4
+	next(RegionXORMask, xorStride*height, fmt.Sprintf("%dx%d, %d bpp, %d-byte rows", width, height, bpp, xorStride))
+	andStride := (width + 31) / 32 * 4
+	next(RegionANDMask, andStride*height, fmt.Sprintf("%dx%d, %d-byte rows", width, height, andStride))
+
+	if offset < len(p) {
+		part(RegionUnused, offset, len(p)-offset, "after the AND mask")
+	}
+}
+
+// pngColorTypes names the PNG color types
+var pngColorTypes = map[byte]string{0: "grayscale", 2: "RGB", 3: "indexed", 4: "grayscale and alpha", 6: "RGBA"}
+
+// dissectPNG splits a PNG payload into its signature and chunks, checking
+// each chunk's CRC
+func dissectPNG(p []byte, region *Region) {
+	base, entry := region.Offset, region.Entry
+	region.Children = append(region.Children, Region{Kind: RegionPNGSignature, Offset: base, Size: len(pngSignature), Entry: entry})
+
+	offset, ended := len(pngSignature), false
+	for offset < len(p) && !ended {
+		if offset+8 > len(p) {
+			region.Problems = append(region.Problems, fmt.Sprintf("PNG chunk at %d truncated", base+offset))
+			break
+		}
+		length := int(binary.BigEndian.Uint32(p[offset:]))
+		typ := string(p[offset+4 : offset+8])
+		chunk := Region{Kind: RegionPNGChunk, Offset: base + offset, Entry: entry, Detail: typ}
+		chunk.Fields = []Field{
+			{base + offset, 4, "Length", fmt.Sprint(length)},
+			{base + offset + 4, 4, "Type", typ},
+		}
+
+		dataStart := offset + 8
+		end := dataStart + length + 4
+		if length < 0 || end > len(p) || end < dataStart {
+			chunk.Size = len(p) - offset
+			chunk.Problems = append(chunk.Problems, fmt.Sprintf("chunk truncated: needs %d bytes, payload has %d", 12+length, chunk.Size))
+			region.Children = append(region.Children, chunk)
+			offset = len(p)
+			break
+		}
+		chunk.Size = end - offset
+		body := p[dataStart : dataStart+length]
+
+		switch typ {
+		case "IHDR":
+			if len(body) == 13 {
+				o := base + dataStart
+				chunk.Fields = append(chunk.Fields,
+					Field{o, 4, "Width", fmt.Sprint(binary.BigEndian.Uint32(body))},
+					Field{o + 4, 4, "Height", fmt.Sprint(binary.BigEndian.Uint32(body[4:]))},
+					Field{o + 8, 1, "BitDepth", fmt.Sprint(body[8])},
+					Field{o + 9, 1, "ColorType", fmt.Sprintf("%d (%s)", body[9], pngColorTypes[body[9]])},
+					Field{o + 10, 1, "Compression", fmt.Sprint(body[10])},
+					Field{o + 11, 1, "Filter", fmt.Sprint(body[11])},
+					Field{o + 12, 1, "Interlace", fmt.Sprint(body[12])})
+			} else {
+				chunk.Problems = append(chunk.Problems, fmt.Sprintf("IHDR has %d bytes, expected 13", len(body)))
+			}
+			if offset != len(pngSignature) {
+				chunk.Problems = append(chunk.Problems, "IHDR is not the first chunk")
+			}
+		case "PLTE":
+			chunk.Detail += fmt.Sprintf(", %d colors", len(body)/3)
+			for i := 0; i+3 <= len(body); i += 3 {
+				chunk.Fields = append(chunk.Fields, Field{base + dataStart + i, 3, fmt.Sprintf("Color %d", i/3),
+					fmt.Sprintf("#%02X%02X%02X", body[i], body[i+1], body[i+2])})
+			}
+		case "IEND":
+			ended = true
+		}
+
+		stored := binary.BigEndian.Uint32(p[end-4:])
+		chunk.Fields = append(chunk.Fields, Field{base + end - 4, 4, "CRC", fmt.Sprintf("0x%08X", stored)})
+		if computed := crc32.ChecksumIEEE(p[offset+4 : end-4]); computed != stored {
+			chunk.Problems = append(chunk.Problems, fmt.Sprintf("CRC mismatch: computed 0x%08X", computed))
+		}
+		region.Children = append(region.Children, chunk)
+		offset = end
+	}
+
+	if !ended {
+		region.Problems = append(region.Problems, "PNG data has no IEND chunk")
+	}
+	if offset < len(p) {
+		unused := Region{Kind: RegionUnused, Offset: base + offset, Size: len(p) - offset, Entry: entry}
+		if ended {
+			unused.Detail = "after IEND"
+		}
+		region.Children = append(region.Children, unused)
+	}
+}
+
+// fillGaps sorts regions by offset and adds regions for the bytes they do
+// not cover: gaps between them and trailing data after them
+func fillGaps(regions []Region, size int) []Region {
+	sort.SliceStable(regions, func(a, b int) bool { return regions[a].Offset < regions[b].Offset })
+
+	var out []Region
+	covered := 0
+	for _, r := range regions {
+		if r.Offset > covered {
+			out = append(out, Region{Kind: RegionGap, Offset: covered, Size: r.Offset - covered, Entry: -1})
+		}
+		out = append(out, r)
+		covered = max(covered, r.End())
+	}
+	if covered < size {
+		out = append(out, Region{Kind: RegionTrailing, Offset: covered, Size: size - covered, Entry: -1})
+	}
+	return out
+}
+
+// findOverlaps returns every pair of regions sharing bytes
+func findOverlaps(regions []Region) []Overlap {
+	var overlaps []Overlap
+	for a := range regions {
+		for b := a + 1; b < len(regions); b++ {
+			start := max(regions[a].Offset, regions[b].Offset)
+			end := min(regions[a].End(), regions[b].End())
+			if start < end {
+				overlaps = append(overlaps, Overlap{Offset: start, Size: end - start, A: a, B: b})
+			}
+		}
+	}
+	return overlaps
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,182 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// regionKinds returns the kinds of regions
+func regionKinds(regions []Region) []RegionKind {
+	var kinds []RegionKind
+	for _, r := range regions {
+		kinds = append(kinds, r.Kind)
+	}
+	return kinds
+}
+
+// allProblems collects the problems of a layout and all its regions
+func allProblems(layout *Layout) []string {
+	problems := slices.Clone(layout.Problems)
+	var walk func([]Region)
+	walk = func(regions []Region) {
+		for _, r := range regions {
+			problems = append(problems, r.Problems...)
+			walk(r.Children)
+		}
+	}
+	walk(layout.Regions)
+	return problems
+}
+
+func TestDissect(t *testing.T) {
+	data := createMixedICO(t)
+	layout := Dissect(bytes.NewReader(data))
+
+	if problems := allProblems(layout); len(problems) != 0 || len(layout.Overlaps) != 0 {
+		t.Fatalf("Expected a clean layout, got problems %q and overlaps %v", problems, layout.Overlaps)
+	}
+	want := []RegionKind{RegionHeader, RegionDirectoryEntry, RegionDirectoryEntry, RegionPayload, RegionPayload}
+	if got := regionKinds(layout.Regions); !slices.Equal(got, want) {
+		t.Fatalf("Expected regions %v, got %v", want, got)
+	}
+
+	// The regions tile the file, and each payload's parts tile the payload
+	offset := 0
+	for _, r := range layout.Regions {
+		if r.Offset != offset {
+			t.Errorf("Expected %s at %d, got %d", r.Kind, offset, r.Offset)
+		}
+		offset = r.End()
+		if len(r.Children) > 0 {
+			if first, last := r.Children[0], r.Children[len(r.Children)-1]; first.Offset != r.Offset || last.End() != r.End() {
+				t.Errorf("Expected the parts of payload %d to cover it", r.Entry)
+			}
+		}
+	}
+	if offset != len(data) || layout.Size != len(data) {
+		t.Errorf("Expected regions to cover %d bytes, got %d", len(data), offset)
+	}
+
+	if dir := layout.Regions[2]; dir.Fields[0].Name != "Width" || dir.Fields[0].Value != "48" || dir.Fields[0].Offset != 6+16 {
+		t.Errorf("Unexpected first field of directory entry 1: %+v", dir.Fields[0])
+	}
+
+	bmp := layout.Regions[3]
+	wantBMP := []RegionKind{RegionBMPHeader, RegionPalette, RegionXORMask, RegionANDMask}
+	if got := regionKinds(bmp.Children); !slices.Equal(got, wantBMP) {
+		t.Fatalf("Expected BMP parts %v, got %v", wantBMP, got)
+	}
+	if palette := bmp.Children[1]; palette.Size != 1024 || len(palette.Fields) != 256 {
+		t.Errorf("Expected a 256-color palette, got %d bytes with %d colors", palette.Size, len(palette.Fields))
+	}
+	if xor, and := bmp.Children[2], bmp.Children[3]; xor.Size != 16*16 || and.Size != 4*16 {
+		t.Errorf("Expected 256-byte XOR and 64-byte AND masks, got %d and %d", xor.Size, and.Size)
+	}
+
+	png := layout.Regions[4]
+	var chunks []string
+	for _, c := range png.Children[1:] {
+		chunks = append(chunks, c.Detail)
+	}
+	if png.Children[0].Kind != RegionPNGSignature || chunks[0] != "IHDR" || !slices.Contains(chunks, "tEXt") || chunks[len(chunks)-1] != "IEND" {
+		t.Errorf("Unexpected PNG chunks %q", chunks)
+	}
+}
+
+func TestDissectDamaged(t *testing.T) {
+	bmp, err := encodeBMP(createTestImage(16), 32, &EncodeOptions{})
+	if err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+	var buf bytes.Buffer
+	entries := []DirectoryEntry{
+		newDirectoryEntry(image.Rect(0, 0, 32, 32), 32),
+		newDirectoryEntry(image.Rect(0, 0, 16, 16), 32),
+		newDirectoryEntry(image.Rect(0, 0, 16, 16), 32),
+	}
+	if err := writeICO(&buf, Header{Type: TypeICO}, entries, [][]byte{bmp, bmp, bmp}); err != nil {
+		t.Fatalf("Failed to write ICO: %v", err)
+	}
+
+	// Eight bytes of padding follow the directory. Entry 1 overlaps entry 0
+	// by 4 bytes, and entry 2 points past the end of the file, leaving its
+	// payload and some junk as trailing data.
+	const dirEnd = 6 + 16*3
+	data := append(slices.Clone(buf.Bytes()[:dirEnd]), make([]byte, 8)...)
+	data = append(append(data, buf.Bytes()[dirEnd:]...), "junk"...)
+	entry := func(i int) []byte { return data[6+16*i:] }
+	for i := range entries {
+		binary.LittleEndian.PutUint32(entry(i)[12:], binary.LittleEndian.Uint32(entry(i)[12:])+8)
+	}
+	binary.LittleEndian.PutUint32(entry(1)[12:], binary.LittleEndian.Uint32(entry(1)[12:])-4)
+	binary.LittleEndian.PutUint32(entry(2)[12:], uint32(len(data)+10))
+
+	layout := Dissect(bytes.NewReader(data))
+	want := []RegionKind{RegionHeader, RegionDirectoryEntry, RegionDirectoryEntry, RegionDirectoryEntry,
+		RegionGap, RegionPayload, RegionPayload, RegionTrailing}
+	if got := regionKinds(layout.Regions); !slices.Equal(got, want) {
+		t.Fatalf("Expected regions %v, got %v", want, got)
+	}
+	if len(layout.Overlaps) != 1 || layout.Overlaps[0].Size != 4 || layout.Overlaps[0].A != 5 || layout.Overlaps[0].B != 6 {
+		t.Errorf("Expected a 4-byte overlap of the payloads, got %+v", layout.Overlaps)
+	}
+	if gap := layout.Regions[4]; gap.Offset != dirEnd || gap.Size != 8 {
+		t.Errorf("Expected an 8-byte gap after the directory, got %d bytes at %d", gap.Size, gap.Offset)
+	}
+	if trailing := layout.Regions[7]; trailing.Size != 4+len(bmp)+4 {
+		t.Errorf("Expected %d trailing bytes, got %d", 4+len(bmp)+4, trailing.Size)
+	}
+
+	for _, tt := range []struct {
+		region  int
+		problem string
+	}{
+		{3, "beyond the end of the file"},
+		{5, "declares 32x32, payload is 16x16"},
+	} {
+		if problems := strings.Join(layout.Regions[tt.region].Problems, "; "); !strings.Contains(problems, tt.problem) {
+			t.Errorf("Expected region %d to report %q, got %q", tt.region, tt.problem, problems)
+		}
+	}
+}
+
+func TestDissectPNGProblems(t *testing.T) {
+	data := createMixedICO(t)
+	layout := Dissect(bytes.NewReader(data))
+	png := layout.Regions[4]
+
+	// Corrupt the IHDR CRC and cut the file short within IEND
+	ihdr := png.Children[1]
+	damaged := slices.Clone(data[:len(data)-6])
+	damaged[ihdr.End()-1] ^= 0xFF
+
+	layout = Dissect(bytes.NewReader(damaged))
+	problems := strings.Join(allProblems(layout), "; ")
+	for _, want := range []string{"CRC mismatch", "payload truncated", "PNG chunk at", "no IEND"} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected a problem containing %q, got %q", want, problems)
+		}
+	}
+}
+
+func TestDissectShort(t *testing.T) {
+	layout := Dissect(bytes.NewReader([]byte{0, 0, 3}))
+	if len(layout.Problems) != 1 || len(layout.Regions) != 1 || layout.Regions[0].Kind != RegionTrailing {
+		t.Errorf("Expected a problem and one trailing region, got %+v", layout)
+	}
+
+	// The header declares two entries but the directory holds one
+	data := []byte{0, 0, 1, 0, 2, 0}
+	data = append(data, make([]byte, 16)...)
+	layout = Dissect(bytes.NewReader(data))
+	if len(layout.Problems) != 1 || !strings.Contains(layout.Problems[0], "directory truncated") {
+		t.Errorf("Expected a truncated directory, got %q", layout.Problems)
+	}
+	if got := strings.Join(layout.Regions[1].Problems, "; "); !strings.Contains(got, "payload size is 0") {
+		t.Errorf("Expected an empty payload to be reported, got %q", got)
+	}
+}
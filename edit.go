@@ -0,0 +1,194 @@
+package ico
+
+import (
+	"fmt"
+	"image"
+	"slices"
+	"sort"
+)
+
+// AddOptions configures AddImage. A nil *AddOptions adds a 32-bit entry
+// with its hotspot at the origin.
+type AddOptions struct {
+	// BitsPerPixel is the bit depth recorded in the new directory entry,
+	// which SizePolicy uses when encoding it as BMP: 1, 4, 8, 24 or 32.
+	// Zero means 32. Cursor entries are always encoded at 32 bits.
+	BitsPerPixel int
+
+	// Hotspot is the cursor hotspot of the new entry. It is ignored unless
+	// the header type is TypeCUR.
+	Hotspot image.Point
+}
+
+// AddImage appends img as a new entry, with a directory entry describing
+// its size and bit depth, and updates the header count. An ICO with no
+// header type becomes an icon.
+func (ico *ICO) AddImage(img image.Image, opts *AddOptions) error {
+	if err := checkImageSize(img); err != nil {
+		return fmt.Errorf("image is %w", err)
+	}
+
+	var o AddOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.BitsPerPixel == 0 {
+		o.BitsPerPixel = 32
+	}
+	if !validBMPDepth(o.BitsPerPixel) {
+		return fmt.Errorf("unsupported bit depth: %d", o.BitsPerPixel)
+	}
+
+	ico.syncEntries()
+	if ico.Header.Type == 0 {
+		ico.Header.Type = TypeICO
+	}
+
+	entry := newDirectoryEntry(img.Bounds(), o.BitsPerPixel)
+	if ico.Header.Type == TypeCUR {
+		entry.SetHotspot(o.Hotspot)
+	}
+	ico.Entries = append(ico.Entries, entry)
+	ico.Images = append(ico.Images, img)
+	if ico.Payloads != nil {
+		ico.Payloads = append(ico.Payloads, nil)
+	}
+	ico.Header.Count = uint16(len(ico.Images))
+	return nil
+}
+
+// RemoveAt removes entry i along with its directory entry and payload, and
+// updates the header count.
+func (ico *ICO) RemoveAt(i int) error {
+	if i < 0 || i >= len(ico.Images) {
+		return fmt.Errorf("image index %d out of range (have %d images)", i, len(ico.Images))
+	}
+
+	ico.syncEntries()
+	ico.Entries = append(ico.Entries[:i], ico.Entries[i+1:]...)
+	ico.Images = append(ico.Images[:i], ico.Images[i+1:]...)
+	if i < len(ico.Payloads) {
+		ico.Payloads = append(ico.Payloads[:i], ico.Payloads[i+1:]...)
+	}
+	ico.Header.Count = uint16(len(ico.Images))
+	return nil
+}
+
+// Replace replaces the image of entry i with img. The directory entry takes
+// the new size and keeps its bit depth, or its hotspot in a cursor, and the
+// entry's original payload is discarded so that Encode re-encodes it.
+func (ico *ICO) Replace(i int, img image.Image) error {
+	if i < 0 || i >= len(ico.Images) {
+		return fmt.Errorf("image index %d out of range (have %d images)", i, len(ico.Images))
+	}
+	if err := checkImageSize(img); err != nil {
+		return fmt.Errorf("image is %w", err)
+	}
+
+	ico.syncEntries()
+	old := ico.Entries[i]
+	entry := newDirectoryEntry(img.Bounds(), ico.entryDepth(i))
+	if ico.Header.Type == TypeCUR {
+		entry.SetHotspot(old.Hotspot())
+	}
+
+	ico.Entries[i] = entry
+	ico.Images[i] = img
+	if i < len(ico.Payloads) {
+		ico.Payloads[i] = nil
+	}
+	return nil
+}
+
+// SortBySize orders the entries from smallest to largest by pixel area,
+// then by width, with higher bit depths first among entries of the same
+// size. Entries that compare equal keep their relative order.
+func (ico *ICO) SortBySize() {
+	ico.syncEntries()
+
+	order := make([]int, len(ico.Images))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ba, bb := ico.Images[order[a]].Bounds(), ico.Images[order[b]].Bounds()
+		if areaA, areaB := ba.Dx()*ba.Dy(), bb.Dx()*bb.Dy(); areaA != areaB {
+			return areaA < areaB
+		}
+		if ba.Dx() != bb.Dx() {
+			return ba.Dx() < bb.Dx()
+		}
+		return ico.entryDepth(order[a]) > ico.entryDepth(order[b])
+	})
+
+	entries := make([]DirectoryEntry, len(order))
+	images := make([]image.Image, len(order))
+	var payloads [][]byte
+	if ico.Payloads != nil {
+		payloads = make([][]byte, len(order))
+	}
+	for to, from := range order {
+		entries[to] = ico.Entries[from]
+		images[to] = ico.Images[from]
+		if payloads != nil && from < len(ico.Payloads) {
+			payloads[to] = ico.Payloads[from]
+		}
+	}
+	ico.Entries, ico.Images, ico.Payloads = entries, images, payloads
+}
+
+// Dedupe removes entries whose pixels exactly match an earlier entry of the
+// same size, keeping the first, and returns the number removed. As in
+// Optimize, fully transparent pixels match whatever their color channels.
+func (ico *ICO) Dedupe() int {
+	var kept []*image.NRGBA
+	removed := 0
+	for i := 0; i < len(ico.Images); {
+		src := clearTransparent(toNRGBA(ico.Images[i]))
+		if containsImage(kept, src) {
+			ico.RemoveAt(i)
+			removed++
+			continue
+		}
+		kept = append(kept, src)
+		i++
+	}
+	return removed
+}
+
+// syncEntries makes Entries parallel to Images, describing any image that
+// has no directory entry as a 32-bit entry of its size, and drops entries
+// beyond the last image.
+func (ico *ICO) syncEntries() {
+	for i := len(ico.Entries); i < len(ico.Images); i++ {
+		entry := newDirectoryEntry(ico.Images[i].Bounds(), 32)
+		if ico.Header.Type == TypeCUR {
+			entry.SetHotspot(image.Point{})
+		}
+		ico.Entries = append(ico.Entries, entry)
+	}
+	ico.Entries = ico.Entries[:len(ico.Images)]
+}
+
+// synced returns a copy of ico whose Entries are synced as by syncEntries,
+// for reading the directory without modifying ico
+func (ico *ICO) synced() *ICO {
+	c := *ico
+	c.Entries = slices.Clone(ico.Entries)
+	c.syncEntries()
+	return &c
+}
+
+// entryDepth returns the bit depth entry i should be encoded at: the depth
+// in its directory entry, or 32 for cursors, whose entries hold a hotspot
+// instead, and for missing or unsupported depths.
+func (ico *ICO) entryDepth(i int) int {
+	if ico.Header.Type == TypeCUR || i >= len(ico.Entries) {
+		return 32
+	}
+	bpp := int(ico.Entries[i].BitsPerPixel)
+	if !validBMPDepth(bpp) {
+		return 32
+	}
+	return bpp
+}
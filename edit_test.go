@@ -0,0 +1,154 @@
+package ico
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestAddImage(t *testing.T) {
+	icoFile := &ICO{}
+	if err := icoFile.AddImage(createTestImage(16), &AddOptions{BitsPerPixel: 4}); err != nil {
+		t.Fatalf("Failed to add image: %v", err)
+	}
+	if err := icoFile.AddImage(createTestImage(256), nil); err != nil {
+		t.Fatalf("Failed to add image: %v", err)
+	}
+
+	if icoFile.Header.Type != TypeICO || icoFile.Header.Count != 2 {
+		t.Errorf("Unexpected header %+v", icoFile.Header)
+	}
+	if e := icoFile.Entries[0]; e.BitsPerPixel != 4 || e.ColorCount != 16 || e.GetWidth() != 16 {
+		t.Errorf("Unexpected 16px entry %+v", e)
+	}
+	if e := icoFile.Entries[1]; e.Width != 0 || e.GetWidth() != 256 || e.GetHeight() != 256 || e.BitsPerPixel != 32 {
+		t.Errorf("Unexpected 256px entry %+v", e)
+	}
+
+	if err := icoFile.AddImage(createTestImage(300), nil); err == nil {
+		t.Error("Expected error for oversized image")
+	}
+	if err := icoFile.AddImage(createTestImage(16), &AddOptions{BitsPerPixel: 16}); err == nil {
+		t.Error("Expected error for unsupported bit depth")
+	}
+	if len(icoFile.Images) != 2 || len(icoFile.Entries) != 2 {
+		t.Error("Expected failed additions to leave the ICO unchanged")
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, icoFile, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded.Entries[0].BitsPerPixel != 4 {
+		t.Errorf("Expected 4-bit entry after encoding, got %d", decoded.Entries[0].BitsPerPixel)
+	}
+}
+
+func TestAddImageCursor(t *testing.T) {
+	icoFile := &ICO{Header: Header{Type: TypeCUR}}
+	if err := icoFile.AddImage(createTestImage(32), &AddOptions{Hotspot: image.Pt(5, 7)}); err != nil {
+		t.Fatalf("Failed to add image: %v", err)
+	}
+	if hs := icoFile.Entries[0].Hotspot(); hs != image.Pt(5, 7) {
+		t.Errorf("Expected hotspot 5,7, got %v", hs)
+	}
+}
+
+func TestRemoveAt(t *testing.T) {
+	decoded, err := Decode(bytes.NewReader(createMixedICO(t)))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	kept := decoded.Payloads[1]
+
+	if err := decoded.RemoveAt(0); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+	if err := decoded.RemoveAt(1); err == nil {
+		t.Error("Expected error for index out of range")
+	}
+
+	if decoded.Header.Count != 1 || len(decoded.Entries) != 1 || len(decoded.Payloads) != 1 {
+		t.Fatalf("Expected one entry left, got header %+v", decoded.Header)
+	}
+	if decoded.Entries[0].GetWidth() != 48 {
+		t.Errorf("Expected the 48px entry to remain, got %dpx", decoded.Entries[0].GetWidth())
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, decoded, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), kept) {
+		t.Error("Expected remaining entry to be written verbatim")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	icoFile := &ICO{Header: Header{Type: TypeCUR}}
+	icoFile.AddImage(createTestImage(32), &AddOptions{Hotspot: image.Pt(3, 4)})
+	icoFile.Payloads = [][]byte{{1, 2, 3}}
+
+	if err := icoFile.Replace(0, createTestImage(256)); err != nil {
+		t.Fatalf("Failed to replace: %v", err)
+	}
+	e := icoFile.Entries[0]
+	if e.GetWidth() != 256 || e.GetHeight() != 256 || e.Hotspot() != image.Pt(3, 4) {
+		t.Errorf("Unexpected entry after replace %+v", e)
+	}
+	if icoFile.Payloads[0] != nil {
+		t.Error("Expected replaced payload to be discarded")
+	}
+
+	if err := icoFile.Replace(1, createTestImage(16)); err == nil {
+		t.Error("Expected error for index out of range")
+	}
+	if err := icoFile.Replace(0, createTestImage(257)); err == nil {
+		t.Error("Expected error for oversized image")
+	}
+}
+
+func TestSortBySize(t *testing.T) {
+	icoFile := &ICO{}
+	for _, add := range []struct{ size, bpp int }{{48, 32}, {16, 8}, {256, 32}, {16, 32}, {32, 4}} {
+		icoFile.AddImage(createTestImage(add.size), &AddOptions{BitsPerPixel: add.bpp})
+	}
+	icoFile.Payloads = [][]byte{{48}, {16}, nil, {17}, {32}}
+
+	icoFile.SortBySize()
+
+	want := []struct{ size, bpp int }{{16, 32}, {16, 8}, {32, 4}, {48, 32}, {256, 32}}
+	for i, w := range want {
+		e := icoFile.Entries[i]
+		if e.GetWidth() != w.size || int(e.BitsPerPixel) != w.bpp || icoFile.Images[i].Bounds().Dx() != w.size {
+			t.Errorf("Entry %d: got %dpx %dbpp, want %dpx %dbpp", i, e.GetWidth(), e.BitsPerPixel, w.size, w.bpp)
+		}
+	}
+	if !bytes.Equal(icoFile.Payloads[0], []byte{17}) || icoFile.Payloads[4] != nil {
+		t.Errorf("Expected payloads to move with their entries, got %v", icoFile.Payloads)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(16), nil)
+	icoFile.AddImage(createTestImage(32), nil)
+
+	// Same pixels, but with color in the fully transparent corner
+	dup := createTestImage(16)
+	dup.Set(0, 0, image.Transparent)
+	dup.Pix[0] = 255
+	icoFile.AddImage(dup, nil)
+	icoFile.AddImage(createTestImage(32), nil)
+
+	if removed := icoFile.Dedupe(); removed != 2 {
+		t.Errorf("Expected 2 duplicates removed, got %d", removed)
+	}
+	if icoFile.Header.Count != 2 || len(icoFile.Entries) != 2 {
+		t.Errorf("Expected 2 entries left, got header %+v", icoFile.Header)
+	}
+}
@@ -0,0 +1,484 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"github.com/thatoddmailbox/go-ico/quantize"
+)
+
+// Format identifies how an entry's image data is stored inside an ICO file.
+type Format uint8
+
+const (
+	FormatBMP Format = iota // BMP without file header, followed by an AND mask
+	FormatPNG               // Complete PNG file
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatBMP:
+		return "bmp"
+	case FormatPNG:
+		return "png"
+	default:
+		return fmt.Sprintf("Format(%d)", uint8(f))
+	}
+}
+
+// EntryEncoding describes how a single entry is stored.
+type EntryEncoding struct {
+	Format Format
+
+	// BitsPerPixel is the target bit depth. BMP entries support 1, 4, 8, 24
+	// and 32. PNG entries are written with alpha at 32, or as paletted PNGs
+	// at 1, 4 or 8. Any other value means 32.
+	BitsPerPixel int
+}
+
+// EncodePolicy chooses the encoding of entry i of an ICO being encoded.
+// Entries beyond len(ico.Entries) have no directory entry yet.
+type EncodePolicy func(ico *ICO, i int) EntryEncoding
+
+// SizePolicy returns a policy that stores entries at least minPNG pixels
+// wide or tall as 32-bit PNG, and smaller entries as BMP at the bit depth
+// already in their directory entry's BitsPerPixel (32 for cursors). Large
+// entries then stay compact while small ones remain readable by loaders
+// without PNG support, such as Windows XP.
+func SizePolicy(minPNG int) EncodePolicy {
+	return func(ico *ICO, i int) EntryEncoding {
+		b := ico.Images[i].Bounds()
+		if b.Dx() >= minPNG || b.Dy() >= minPNG {
+			return EntryEncoding{Format: FormatPNG, BitsPerPixel: 32}
+		}
+
+		// Cursor entries store their hotspot in place of the bit depth
+		bpp := 32
+		if i < len(ico.Entries) && ico.Header.Type != TypeCUR {
+			bpp = int(ico.Entries[i].BitsPerPixel)
+		}
+		return EntryEncoding{Format: FormatBMP, BitsPerPixel: bpp}
+	}
+}
+
+// EncodeOptions configures Encode. A nil *EncodeOptions uses the defaults
+// described on each field.
+type EncodeOptions struct {
+	// Policy chooses the format and bit depth of each entry. Defaults to
+	// SizePolicy(256): PNG for 256-pixel entries, BMP for the rest.
+	Policy EncodePolicy
+
+	// PNGCompression is the compression level for PNG entries.
+	PNGCompression png.CompressionLevel
+
+	// OmitANDMask leaves out the AND mask of 32-bit BMP entries, whose alpha
+	// channel makes it redundant for modern loaders. Legacy loaders that
+	// ignore alpha will show such entries without transparency.
+	OmitANDMask bool
+
+	// Quantizer builds the palette for 1, 4 and 8-bit entries. It is asked
+	// for at most 2, 16 or 256 colors respectively. Defaults to
+	// quantize.MedianCut; use quantize.Windows for the standard palettes.
+	Quantizer draw.Quantizer
+
+	// Dither enables Floyd–Steinberg error diffusion when mapping pixels
+	// onto a palette.
+	Dither bool
+
+	// AlphaThreshold is the alpha value below which a pixel is transparent
+	// in entries that cannot store partial transparency: the AND mask of 1,
+	// 4, 8 and 24-bit BMPs, and paletted PNGs. Zero means 128.
+	AlphaThreshold uint8
+
+	// Reencode encodes every image according to Policy, even entries whose
+	// original payload could be copied verbatim.
+	Reencode bool
+}
+
+// setDefaults fills in the zero-valued fields of o with their defaults.
+func (o *EncodeOptions) setDefaults() {
+	if o.Policy == nil {
+		o.Policy = SizePolicy(256)
+	}
+	if o.Quantizer == nil {
+		o.Quantizer = quantize.MedianCut{}
+	}
+	if o.AlphaThreshold == 0 {
+		o.AlphaThreshold = 128
+	}
+}
+
+// Encode writes ico to w in ICO format. The options' Policy decides whether
+// each image is stored as PNG or BMP, and at what bit depth; by default
+// 256-pixel images are PNG and the rest BMP at the bit depth given by their
+// directory entry's BitsPerPixel. Images stored at 8 bits per pixel or fewer
+// are quantized to a palette, and BMP entries get an AND mask derived from
+// their alpha channel.
+//
+// Entries of a decoded ICO whose image has been neither replaced nor
+// modified in place are copied verbatim from ico.Payloads together with their directory fields, unless
+// opts.Reencode is set.
+//
+// The header count and each directory entry's dimensions, color count, bit
+// depth, size and offset are computed from the images and their encoding,
+// so ico.Entries only needs to carry what the policy reads; it may also be
+// shorter than ico.Images. When ico.Header.Type is TypeCUR, each entry's
+// hotspot is copied from ico.Entries.
+func Encode(w io.Writer, ico *ICO, opts *EncodeOptions) error {
+	var o EncodeOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.setDefaults()
+
+	if len(ico.Images) == 0 {
+		return fmt.Errorf("ICO contains no images")
+	}
+
+	entries := make([]DirectoryEntry, len(ico.Images))
+	payloads := make([][]byte, len(ico.Images))
+	for i, img := range ico.Images {
+		if data := ico.unchangedPayload(i); data != nil && !o.Reencode {
+			entries[i] = ico.Entries[i]
+			payloads[i] = data
+			continue
+		}
+
+		if err := checkImageSize(img); err != nil {
+			return fmt.Errorf("image %d is %w", i, err)
+		}
+
+		entry, data, err := encodeEntry(img, o.Policy(ico, i), &o)
+		if err != nil {
+			return fmt.Errorf("failed to encode image %d: %w", i, err)
+		}
+		entries[i] = entry
+		payloads[i] = data
+	}
+
+	header := ico.Header
+	if header.Type == 0 {
+		header.Type = TypeICO
+	}
+	copyHotspots(header, entries, ico.Entries)
+	return writeICO(w, header, entries, payloads)
+}
+
+// unchangedPayload returns the payload of entry i if it can be written back
+// verbatim: it is the payload Decode read, the image is still the one
+// decoded from it with the same pixels, and the entry has a directory entry
+// to go with it. Otherwise it returns nil.
+func (ico *ICO) unchangedPayload(i int) []byte {
+	if i >= len(ico.Payloads) || i >= len(ico.Entries) || len(ico.Payloads[i]) == 0 {
+		return nil
+	}
+
+	data := ico.Payloads[i]
+	for _, origin := range ico.origins {
+		// Decoded images are always pointers, so this comparison is safe
+		// whatever the caller stored in Images
+		if len(origin.data) == len(data) && &origin.data[0] == &data[0] && origin.img == ico.Images[i] {
+			if pixelChecksum(origin.img) != origin.checksum {
+				return nil
+			}
+			return data
+		}
+	}
+	return nil
+}
+
+// pixelChecksum returns a checksum of the pixels of img
+func pixelChecksum(img image.Image) uint32 {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return crc32.ChecksumIEEE(img.Pix)
+	case *image.NRGBA:
+		return crc32.ChecksumIEEE(img.Pix)
+	case *image.Gray:
+		return crc32.ChecksumIEEE(img.Pix)
+	case *image.RGBA64:
+		return crc32.ChecksumIEEE(img.Pix)
+	case *image.NRGBA64:
+		return crc32.ChecksumIEEE(img.Pix)
+	}
+	// Paletted and other images, whose colors depend on more than Pix
+	return crc32.ChecksumIEEE(toNRGBA(img).Pix)
+}
+
+// checkImageSize reports an error if img cannot be described by an ICO
+// directory entry.
+func checkImageSize(img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > 256 || b.Dy() > 256 {
+		return fmt.Errorf("%dx%d: ICO images must be 1 to 256 pixels on each side", b.Dx(), b.Dy())
+	}
+	return nil
+}
+
+// encodeEntry encodes img as requested, returning its payload and a
+// directory entry with everything but the size and offset filled in.
+func encodeEntry(img image.Image, enc EntryEncoding, o *EncodeOptions) (DirectoryEntry, []byte, error) {
+	var data []byte
+	var err error
+	switch enc.Format {
+	case FormatPNG:
+		if enc.BitsPerPixel != 1 && enc.BitsPerPixel != 4 && enc.BitsPerPixel != 8 {
+			enc.BitsPerPixel = 32
+		}
+		data, err = encodePNG(img, enc.BitsPerPixel, o)
+	case FormatBMP:
+		if !validBMPDepth(enc.BitsPerPixel) {
+			enc.BitsPerPixel = 32
+		}
+		data, err = encodeBMP(img, enc.BitsPerPixel, o)
+	default:
+		err = fmt.Errorf("unknown format %v", enc.Format)
+	}
+	if err != nil {
+		return DirectoryEntry{}, nil, err
+	}
+
+	return newDirectoryEntry(img.Bounds(), enc.BitsPerPixel), data, nil
+}
+
+// newDirectoryEntry returns a directory entry for an image of the given
+// bounds and bit depth, without size or offset.
+func newDirectoryEntry(bounds image.Rectangle, bpp int) DirectoryEntry {
+	return DirectoryEntry{
+		Width:        uint8(bounds.Dx()), // 256 wraps to 0, as the format expects
+		Height:       uint8(bounds.Dy()),
+		ColorCount:   paletteColorCount(bpp),
+		ColorPlanes:  1,
+		BitsPerPixel: uint16(bpp),
+	}
+}
+
+// copyHotspots replaces the planes and bit depth of newly encoded cursor
+// entries with the hotspots of the corresponding source entries. Entries of
+// ICO files are left alone.
+func copyHotspots(header Header, entries, source []DirectoryEntry) {
+	if header.Type != TypeCUR {
+		return
+	}
+	for i := range entries {
+		var hotspot image.Point
+		if i < len(source) {
+			hotspot = source[i].Hotspot()
+		}
+		entries[i].SetHotspot(hotspot)
+	}
+}
+
+// writeICO writes the header, directory and payloads of an ICO file,
+// filling in the count and each entry's size and offset.
+func writeICO(w io.Writer, header Header, entries []DirectoryEntry, payloads [][]byte) error {
+	if len(entries) > 0xFFFF {
+		return fmt.Errorf("too many images: %d", len(entries))
+	}
+
+	header.Reserved = 0
+	header.Count = uint16(len(entries))
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+
+	offset := 6 + 16*len(entries)
+	for i := range entries {
+		entries[i].Size = uint32(len(payloads[i]))
+		entries[i].Offset = uint32(offset)
+		offset += len(payloads[i])
+		binary.Write(&buf, binary.LittleEndian, entries[i])
+	}
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write ICO directory: %w", err)
+	}
+	for i, data := range payloads {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write image %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validBMPDepth(bpp int) bool {
+	switch bpp {
+	case 1, 4, 8, 24, 32:
+		return true
+	}
+	return false
+}
+
+// paletteColorCount returns the directory ColorCount for a bit depth, where
+// 0 means no palette or a full 256-color one.
+func paletteColorCount(bpp int) uint8 {
+	if bpp < 8 {
+		return uint8(1 << bpp)
+	}
+	return 0
+}
+
+// encodeBMP encodes img as an ICO-style BMP: a BITMAPINFOHEADER with doubled
+// height, a palette for depths of 8 bits or fewer, the bottom-up XOR bitmap
+// and the 1-bit AND mask.
+func encodeBMP(img image.Image, bpp int, o *EncodeOptions) ([]byte, error) {
+	src := toNRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+
+	// 32-bit entries carry real alpha, so only fully transparent pixels are
+	// masked; this keeps decoding lossless for loaders that honor the mask.
+	masked := func(a uint8) bool { return a < o.AlphaThreshold }
+	if bpp == 32 {
+		masked = func(a uint8) bool { return a == 0 }
+	}
+
+	var palette color.Palette
+	var indices *image.Paletted
+	if bpp <= 8 {
+		var err error
+		if indices, err = palettize(src, 1<<bpp, o); err != nil {
+			return nil, err
+		}
+		palette = indices.Palette
+	}
+
+	xorStride := (width*bpp + 31) / 32 * 4
+	andStride := (width + 31) / 32 * 4
+	if bpp == 32 && o.OmitANDMask {
+		andStride = 0
+	}
+	paletteSize := 0
+	if bpp <= 8 {
+		paletteSize = 4 << bpp
+	}
+
+	out := make([]byte, 40+paletteSize+height*(xorStride+andStride))
+	binary.LittleEndian.PutUint32(out[0:], 40)
+	binary.LittleEndian.PutUint32(out[4:], uint32(width))
+	binary.LittleEndian.PutUint32(out[8:], uint32(height*2))
+	binary.LittleEndian.PutUint16(out[12:], 1)
+	binary.LittleEndian.PutUint16(out[14:], uint16(bpp))
+	binary.LittleEndian.PutUint32(out[20:], uint32(height*(xorStride+andStride)))
+
+	for i, c := range palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		out[40+i*4] = n.B
+		out[40+i*4+1] = n.G
+		out[40+i*4+2] = n.R
+	}
+
+	xor := out[40+paletteSize:]
+	and := xor[height*xorStride:]
+	for y := 0; y < height; y++ {
+		// Rows are stored bottom-to-top
+		xorRow := xor[(height-1-y)*xorStride:]
+		andRow := and[(height-1-y)*andStride:]
+		for x := 0; x < width; x++ {
+			p := src.Pix[y*src.Stride+x*4:]
+			if andStride > 0 && masked(p[3]) {
+				andRow[x/8] |= 0x80 >> (x % 8)
+			}
+
+			switch bpp {
+			case 32:
+				xorRow[x*4] = p[2]
+				xorRow[x*4+1] = p[1]
+				xorRow[x*4+2] = p[0]
+				xorRow[x*4+3] = p[3]
+			case 24:
+				if !masked(p[3]) {
+					xorRow[x*3] = p[2]
+					xorRow[x*3+1] = p[1]
+					xorRow[x*3+2] = p[0]
+				}
+			case 8:
+				xorRow[x] = indices.Pix[y*indices.Stride+x]
+			case 4:
+				xorRow[x/2] |= indices.Pix[y*indices.Stride+x] << (4 * (1 - x%2))
+			case 1:
+				xorRow[x/8] |= indices.Pix[y*indices.Stride+x] << (7 - x%8)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// palettize quantizes src to at most n colors. Pixels with alpha below the
+// threshold are excluded from the palette and left at index 0; the rest are
+// treated as opaque.
+func palettize(src *image.NRGBA, n int, o *EncodeOptions) (*image.Paletted, error) {
+	flat := image.NewNRGBA(src.Rect)
+	for i := 0; i < len(src.Pix); i += 4 {
+		if src.Pix[i+3] >= o.AlphaThreshold {
+			copy(flat.Pix[i:i+3], src.Pix[i:i+3])
+			flat.Pix[i+3] = 255
+		}
+	}
+
+	palette := o.Quantizer.Quantize(make(color.Palette, 0, n), flat)
+	if len(palette) == 0 {
+		palette = color.Palette{color.Black}
+	}
+	if len(palette) > n {
+		return nil, fmt.Errorf("quantizer returned %d colors, at most %d allowed", len(palette), n)
+	}
+	return quantize.Map(flat, palette, o.Dither), nil
+}
+
+// encodePNG encodes img as a PNG entry: truecolor with alpha at 32 bits per
+// pixel, or paletted with a transparent entry at 8 bits or fewer.
+func encodePNG(img image.Image, bpp int, o *EncodeOptions) ([]byte, error) {
+	src := toNRGBA(img)
+
+	var out image.Image = src
+	if bpp <= 8 {
+		// Reserve one palette slot for transparency when it is needed
+		transparent := false
+		for i := 3; i < len(src.Pix); i += 4 {
+			if src.Pix[i] < o.AlphaThreshold {
+				transparent = true
+				break
+			}
+		}
+
+		n := 1 << bpp
+		if transparent {
+			n--
+		}
+		indices, err := palettize(src, n, o)
+		if err != nil {
+			return nil, err
+		}
+
+		if transparent {
+			// Shift the palette up by one so index 0 is transparent
+			indices.Palette = append(color.Palette{color.NRGBA{}}, indices.Palette...)
+			for y := 0; y < src.Rect.Dy(); y++ {
+				for x := 0; x < src.Rect.Dx(); x++ {
+					i := y*indices.Stride + x
+					if src.Pix[y*src.Stride+x*4+3] < o.AlphaThreshold {
+						indices.Pix[i] = 0
+					} else {
+						indices.Pix[i]++
+					}
+				}
+			}
+		}
+		out = indices
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: o.PNGCompression}
+	if err := encoder.Encode(&buf, out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,440 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+
+	"github.com/thatoddmailbox/go-ico/quantize"
+)
+
+// createTestImage returns a size x size image with four solid quadrants
+// (red, green, blue, white) and a transparent top-left pixel.
+func createTestImage(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	quadrants := []color.NRGBA{
+		{R: 255, A: 255}, {G: 255, A: 255},
+		{B: 255, A: 255}, {R: 255, G: 255, B: 255, A: 255},
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			q := 0
+			if x >= size/2 {
+				q++
+			}
+			if y >= size/2 {
+				q += 2
+			}
+			img.SetNRGBA(x, y, quadrants[q])
+		}
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{})
+	return img
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, bpp := range []uint16{1, 4, 8, 24, 32} {
+		src := createTestImage(16)
+		ico := &ICO{
+			Entries: []DirectoryEntry{{BitsPerPixel: bpp}},
+			Images:  []image.Image{src},
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, ico, nil); err != nil {
+			t.Fatalf("%d bpp: failed to encode: %v", bpp, err)
+		}
+
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("%d bpp: failed to decode: %v", bpp, err)
+		}
+
+		entry := decoded.Entries[0]
+		if entry.GetWidth() != 16 || entry.GetHeight() != 16 || entry.BitsPerPixel != bpp {
+			t.Errorf("%d bpp: unexpected entry %+v", bpp, entry)
+		}
+		if entry.ColorCount != paletteColorCount(int(bpp)) {
+			t.Errorf("%d bpp: expected color count %d, got %d", bpp, paletteColorCount(int(bpp)), entry.ColorCount)
+		}
+
+		img := decoded.Images[0]
+		if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+			t.Errorf("%d bpp: expected transparent pixel from AND mask, got alpha %d", bpp, a)
+		}
+
+		// 1-bit images can only keep two of the four colors
+		if bpp == 1 {
+			continue
+		}
+		for _, p := range []image.Point{{12, 4}, {4, 12}, {12, 12}} {
+			want := src.NRGBAAt(p.X, p.Y)
+			if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)); got != want {
+				t.Errorf("%d bpp: pixel %v expected %v, got %v", bpp, p, want, got)
+			}
+		}
+	}
+}
+
+func TestEncodeDirectory(t *testing.T) {
+	ico := FromMaster(createTestImage(64), []int{16, 32, 256})
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, ico, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if decoded.Header.Type != 1 || decoded.Header.Count != 3 {
+		t.Errorf("Unexpected header %+v", decoded.Header)
+	}
+	if decoded.Entries[2].Width != 0 || decoded.Entries[2].Height != 0 {
+		t.Errorf("Expected 256 to be stored as 0, got %dx%d", decoded.Entries[2].Width, decoded.Entries[2].Height)
+	}
+	offset := uint32(6 + 16*3)
+	for i, entry := range decoded.Entries {
+		if entry.Offset != offset {
+			t.Errorf("Entry %d: expected offset %d, got %d", i, offset, entry.Offset)
+		}
+		offset += entry.Size
+	}
+	if int(offset) != buf.Len() {
+		t.Errorf("Expected payloads to end at %d, file is %d bytes", offset, buf.Len())
+	}
+}
+
+func TestEncodeAlphaThreshold(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 100})
+	src.SetNRGBA(1, 0, color.NRGBA{R: 255, A: 200})
+
+	for _, tc := range []struct {
+		threshold uint8
+		alpha     [2]uint32
+	}{
+		{0, [2]uint32{0, 0xFFFF}},
+		{50, [2]uint32{0xFFFF, 0xFFFF}},
+		{255, [2]uint32{0, 0}},
+	} {
+		ico := &ICO{Entries: []DirectoryEntry{{BitsPerPixel: 8}}, Images: []image.Image{src}}
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, ico, &EncodeOptions{AlphaThreshold: tc.threshold}); err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+
+		for x := 0; x < 2; x++ {
+			if _, _, _, a := decoded.Images[0].At(x, 0).RGBA(); a != tc.alpha[x] {
+				t.Errorf("Threshold %d, pixel %d: expected alpha %d, got %d", tc.threshold, x, tc.alpha[x], a)
+			}
+		}
+	}
+}
+
+func TestEncodeWindowsPalette(t *testing.T) {
+	ico := &ICO{
+		Entries: []DirectoryEntry{{BitsPerPixel: 4}},
+		Images:  []image.Image{createTestImage(8)},
+	}
+
+	var buf bytes.Buffer
+	opts := &EncodeOptions{Quantizer: quantize.Windows{}, Dither: true}
+	if err := Encode(&buf, ico, opts); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// The palette follows the 40-byte BMP header at offset 22
+	data := buf.Bytes()
+	for i, c := range quantize.Windows16 {
+		n := c.(color.NRGBA)
+		p := data[22+40+i*4:]
+		if p[0] != n.B || p[1] != n.G || p[2] != n.R {
+			t.Errorf("Palette entry %d: expected %v, got BGR %v", i, n, p[:3])
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, &ICO{}, nil); err == nil {
+		t.Error("Expected error for empty ICO")
+	}
+
+	big := &ICO{Images: []image.Image{image.NewNRGBA(image.Rect(0, 0, 512, 512))}}
+	if err := Encode(&bytes.Buffer{}, big, nil); err == nil {
+		t.Error("Expected error for image larger than 256x256")
+	}
+}
+
+func TestEncodeDefaultPolicy(t *testing.T) {
+	ico := FromMaster(createTestImage(256), []int{32, 256})
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, ico, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	data := buf.Bytes()
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	for i, wantPNG := range []bool{false, true} {
+		entry := decoded.Entries[i]
+		isPNG := bytes.HasPrefix(data[entry.Offset:], []byte("\x89PNG"))
+		if isPNG != wantPNG {
+			t.Errorf("Entry %d: expected PNG %v, got %v", i, wantPNG, isPNG)
+		}
+		if entry.BitsPerPixel != 32 {
+			t.Errorf("Entry %d: expected 32 bpp, got %d", i, entry.BitsPerPixel)
+		}
+	}
+}
+
+func TestEncodeCustomPolicy(t *testing.T) {
+	ico := &ICO{Images: []image.Image{createTestImage(16), createTestImage(32), createTestImage(48)}}
+	policy := func(ico *ICO, i int) EntryEncoding {
+		switch i {
+		case 0:
+			return EntryEncoding{Format: FormatBMP, BitsPerPixel: 4}
+		case 1:
+			return EntryEncoding{Format: FormatPNG, BitsPerPixel: 8}
+		default:
+			return EntryEncoding{Format: FormatPNG, BitsPerPixel: 32}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, ico, &EncodeOptions{Policy: policy}); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	data := buf.Bytes()
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	for i, want := range []struct {
+		png        bool
+		bpp        uint16
+		colorCount uint8
+	}{
+		{false, 4, 16},
+		{true, 8, 0},
+		{true, 32, 0},
+	} {
+		entry := decoded.Entries[i]
+		isPNG := bytes.HasPrefix(data[entry.Offset:], []byte("\x89PNG"))
+		if isPNG != want.png || entry.BitsPerPixel != want.bpp || entry.ColorCount != want.colorCount {
+			t.Errorf("Entry %d: expected png=%v bpp=%d colors=%d, got png=%v bpp=%d colors=%d",
+				i, want.png, want.bpp, want.colorCount, isPNG, entry.BitsPerPixel, entry.ColorCount)
+		}
+
+		img := decoded.Images[i]
+		size := img.Bounds().Dx()
+		if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+			t.Errorf("Entry %d: expected transparent corner, got alpha %d", i, a)
+		}
+		want := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		if got := color.NRGBAModel.Convert(img.At(size-2, size-2)); got != want {
+			t.Errorf("Entry %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestEncodeOmitANDMask(t *testing.T) {
+	ico := &ICO{Images: []image.Image{createTestImage(32)}}
+
+	var with, without bytes.Buffer
+	if err := Encode(&with, ico, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if err := Encode(&without, ico, &EncodeOptions{OmitANDMask: true}); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// A 32-pixel-wide AND mask takes 4 bytes per row
+	if diff := with.Len() - without.Len(); diff != 32*4 {
+		t.Errorf("Expected AND mask of %d bytes to be omitted, size differs by %d", 32*4, diff)
+	}
+
+	decoded, err := Decode(&without)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if _, _, _, a := decoded.Images[0].At(0, 0).RGBA(); a != 0 {
+		t.Errorf("Expected alpha channel to keep transparency, got alpha %d", a)
+	}
+}
+
+func TestEncodeCursor(t *testing.T) {
+	cur := &ICO{
+		Header:  Header{Type: TypeCUR},
+		Entries: []DirectoryEntry{{}, {}},
+		Images:  []image.Image{createTestImage(16), createTestImage(32)},
+	}
+	cur.Entries[0].SetHotspot(image.Pt(3, 4))
+	cur.Entries[1].SetHotspot(image.Pt(6, 8))
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, cur, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded.Header.Type != TypeCUR {
+		t.Errorf("Expected CUR type, got %d", decoded.Header.Type)
+	}
+	for i, want := range []image.Point{{3, 4}, {6, 8}} {
+		if got := decoded.Entries[i].Hotspot(); got != want {
+			t.Errorf("Entry %d: expected hotspot %v, got %v", i, want, got)
+		}
+	}
+}
+
+// addPNGTextChunk inserts a tEXt chunk after the IHDR chunk of a PNG file.
+func addPNGTextChunk(data []byte, text string) []byte {
+	chunk := make([]byte, 12+len(text))
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)))
+	copy(chunk[4:], "tEXt")
+	copy(chunk[8:], text)
+	binary.BigEndian.PutUint32(chunk[8+len(text):], crc32.ChecksumIEEE(chunk[4:8+len(text)]))
+
+	// Signature (8) + IHDR (12 + 13)
+	ihdrEnd := 8 + 12 + 13
+	out := append([]byte(nil), data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// createMixedICO encodes a file with an 8-bit BMP entry and a PNG entry
+// carrying a text chunk, neither of which re-encoding would reproduce.
+func createMixedICO(t *testing.T) []byte {
+	t.Helper()
+
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, createTestImage(48)); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+
+	bmp, err := encodeBMP(noiseImage(16, 4), 8, &EncodeOptions{Quantizer: quantize.Windows{}, Dither: true, AlphaThreshold: 128})
+	if err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+
+	var buf bytes.Buffer
+	entries := []DirectoryEntry{newDirectoryEntry(image.Rect(0, 0, 16, 16), 8), newDirectoryEntry(image.Rect(0, 0, 48, 48), 32)}
+	payloads := [][]byte{bmp, addPNGTextChunk(pngData.Bytes(), "Comment\x00hello")}
+	if err := writeICO(&buf, Header{Type: TypeICO}, entries, payloads); err != nil {
+		t.Fatalf("Failed to write ICO: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodePreservesPayloads(t *testing.T) {
+	original := createMixedICO(t)
+	decoded, err := Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, decoded, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), original) {
+		t.Error("Expected unmodified ICO to round-trip byte for byte")
+	}
+}
+
+func TestEncodeReplacedEntry(t *testing.T) {
+	original := createMixedICO(t)
+	decoded, err := Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	untouched := decoded.Payloads[1]
+
+	decoded.Images[0] = createTestImage(16)
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, decoded, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	reencoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if !bytes.Equal(reencoded.Payloads[1], untouched) {
+		t.Error("Expected untouched PNG entry to be copied verbatim")
+	}
+	if bytes.Equal(reencoded.Payloads[0], decoded.Payloads[0]) {
+		t.Error("Expected replaced entry to be re-encoded")
+	}
+	assertSamePixels(t, "replaced entry", clearTransparent(createTestImage(16)), reencoded.Images[0])
+}
+
+func TestEncodeModifiedEntry(t *testing.T) {
+	decoded, err := Decode(bytes.NewReader(createMixedICO(t)))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	// Change a pixel of each entry without replacing its image
+	for _, img := range decoded.Images {
+		img.(draw.Image).Set(1, 1, color.NRGBA{R: 1, G: 2, B: 3, A: 255})
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, decoded, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	reencoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	for i, img := range reencoded.Images {
+		if got := color.NRGBAModel.Convert(img.At(1, 1)); got != (color.NRGBA{R: 1, G: 2, B: 3, A: 255}) {
+			t.Errorf("Expected entry %d to be re-encoded with its changed pixel, got %v", i, got)
+		}
+	}
+}
+
+func TestEncodeReencode(t *testing.T) {
+	original := createMixedICO(t)
+	decoded, err := Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, decoded, &EncodeOptions{Reencode: true}); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	reencoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if bytes.Contains(reencoded.Payloads[1], []byte("tEXt")) {
+		t.Error("Expected PNG entry to be re-encoded without its text chunk")
+	}
+}
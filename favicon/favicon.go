@@ -0,0 +1,268 @@
+// Package favicon generates the set of icons a website needs from a single
+// master image: favicon.ico for browsers, an Apple touch icon, the Android
+// and PWA icons referenced from a web app manifest, maskable variants with
+// safe-zone padding, the manifest itself and the HTML that links them.
+package favicon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thatoddmailbox/go-ico"
+	"github.com/thatoddmailbox/go-ico/resample"
+)
+
+// Sizes of the generated icons
+var (
+	// ICOSizes are the sizes stored in favicon.ico
+	ICOSizes = []int{16, 32, 48}
+
+	// AppleTouchSize is the size of apple-touch-icon.png
+	AppleTouchSize = 180
+
+	// ManifestSizes are the sizes of the icons listed in the manifest, each
+	// generated in a regular and a maskable variant
+	ManifestSizes = []int{192, 512}
+)
+
+// DefaultPadding is the maskable icon padding used when Options.Padding is
+// zero. It keeps the image within the central 80%, the safe zone that
+// launchers never crop.
+const DefaultPadding = 0.1
+
+// Options configures Generate. A nil *Options uses the defaults of each
+// field.
+type Options struct {
+	// Name and ShortName are the site name for the manifest. Both are
+	// omitted if empty.
+	Name      string
+	ShortName string
+
+	// ThemeColor is the browser UI color, written to the manifest and as a
+	// theme-color meta tag. Nil omits it.
+	ThemeColor color.Color
+
+	// Background fills the transparent areas of the Apple touch icon and
+	// maskable icons, which must be opaque, and is the manifest background
+	// color. Nil means white.
+	Background color.Color
+
+	// Padding is the fraction of the width left empty on each side of a
+	// maskable icon, below 0.5. Zero means DefaultPadding, and a negative
+	// value means no padding.
+	Padding float64
+
+	// BasePath is the URL path the files are served from. Empty means "/".
+	BasePath string
+}
+
+// File is a generated file
+type File struct {
+	Name        string // File name, such as "favicon.ico"
+	ContentType string // MIME type to serve the file with
+	Data        []byte
+}
+
+// Bundle is a generated set of favicon files
+type Bundle struct {
+	Files []File
+
+	// HTML is the snippet of <link> and <meta> tags that references the
+	// files, for the <head> of every page
+	HTML string
+}
+
+// manifest is the subset of the web app manifest Generate writes
+type manifest struct {
+	Name            string         `json:"name,omitempty"`
+	ShortName       string         `json:"short_name,omitempty"`
+	Icons           []manifestIcon `json:"icons"`
+	ThemeColor      string         `json:"theme_color,omitempty"`
+	BackgroundColor string         `json:"background_color"`
+	Display         string         `json:"display"`
+}
+
+// manifestIcon is an entry of the manifest's icons list
+type manifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// Generate builds the favicon files from master, which should be square
+// and at least 512 pixels wide. Non-square images are centered on a
+// transparent square.
+func Generate(master image.Image, opts *Options) (*Bundle, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if master.Bounds().Empty() {
+		return nil, fmt.Errorf("master image is empty")
+	}
+	if o.Padding == 0 {
+		o.Padding = DefaultPadding
+	} else if o.Padding < 0 {
+		o.Padding = 0
+	}
+	if o.Padding >= 0.5 {
+		return nil, fmt.Errorf("invalid padding %g: must be less than 0.5", o.Padding)
+	}
+	if o.Background == nil {
+		o.Background = color.White
+	}
+	base := o.BasePath
+	if base == "" {
+		base = "/"
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	bundle := &Bundle{}
+
+	var icoData bytes.Buffer
+	if err := ico.Encode(&icoData, ico.FromMaster(master, ICOSizes), nil); err != nil {
+		return nil, fmt.Errorf("failed to encode favicon.ico: %w", err)
+	}
+	bundle.Files = append(bundle.Files, File{Name: "favicon.ico", ContentType: "image/x-icon", Data: icoData.Bytes()})
+
+	addPNG := func(name string, img image.Image) error {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		bundle.Files = append(bundle.Files, File{Name: name, ContentType: "image/png", Data: buf.Bytes()})
+		return nil
+	}
+
+	// iOS shows transparent areas as black, so the touch icon is opaque
+	if err := addPNG("apple-touch-icon.png", Render(master, AppleTouchSize, 0, o.Background)); err != nil {
+		return nil, err
+	}
+
+	m := manifest{
+		Name:            o.Name,
+		ShortName:       o.ShortName,
+		BackgroundColor: hexColor(o.Background),
+		Display:         "standalone",
+	}
+	if o.ThemeColor != nil {
+		m.ThemeColor = hexColor(o.ThemeColor)
+	}
+	for _, size := range ManifestSizes {
+		name := fmt.Sprintf("icon-%d.png", size)
+		if err := addPNG(name, Render(master, size, 0, nil)); err != nil {
+			return nil, err
+		}
+		maskable := fmt.Sprintf("icon-%d-maskable.png", size)
+		if err := addPNG(maskable, Render(master, size, o.Padding, o.Background)); err != nil {
+			return nil, err
+		}
+		sizes := fmt.Sprintf("%dx%d", size, size)
+		m.Icons = append(m.Icons,
+			manifestIcon{Src: base + name, Sizes: sizes, Type: "image/png"},
+			manifestIcon{Src: base + maskable, Sizes: sizes, Type: "image/png", Purpose: "maskable"},
+		)
+	}
+
+	manifestData, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	bundle.Files = append(bundle.Files, File{
+		Name:        "site.webmanifest",
+		ContentType: "application/manifest+json",
+		Data:        append(manifestData, '\n'),
+	})
+
+	bundle.HTML = linkTags(base, o)
+	return bundle, nil
+}
+
+// linkTags returns the HTML referencing the files of a bundle served from base
+func linkTags(base string, o Options) string {
+	var icoSizes []string
+	for _, size := range ICOSizes {
+		icoSizes = append(icoSizes, fmt.Sprintf("%dx%d", size, size))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<link rel=\"icon\" href=\"%s\" sizes=\"%s\">\n", html.EscapeString(base+"favicon.ico"), strings.Join(icoSizes, " "))
+	fmt.Fprintf(&b, "<link rel=\"apple-touch-icon\" href=\"%s\">\n", html.EscapeString(base+"apple-touch-icon.png"))
+	fmt.Fprintf(&b, "<link rel=\"manifest\" href=\"%s\">\n", html.EscapeString(base+"site.webmanifest"))
+	if o.ThemeColor != nil {
+		fmt.Fprintf(&b, "<meta name=\"theme-color\" content=\"%s\">\n", hexColor(o.ThemeColor))
+	}
+	return b.String()
+}
+
+// Render scales img to fit a size x size square, inset by padding times
+// size on each side, and centers it. With a nil background the rest of the
+// square is transparent; otherwise the result is flattened onto background.
+func Render(img image.Image, size int, padding float64, background color.Color) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	if background != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	}
+
+	// Fit the longer side into the padded square
+	inner := max(1, size-2*int(math.Round(padding*float64(size))))
+	b := img.Bounds()
+	w, h := inner, inner
+	if b.Dx() > b.Dy() {
+		h = max(1, int(math.Round(float64(inner)*float64(b.Dy())/float64(b.Dx()))))
+	} else if b.Dy() > b.Dx() {
+		w = max(1, int(math.Round(float64(inner)*float64(b.Dx())/float64(b.Dy()))))
+	}
+
+	scaled := resample.Resize(img, w, h, nil)
+	offset := image.Pt((size-w)/2, (size-h)/2)
+	op := draw.Over
+	if background == nil {
+		op = draw.Src
+	}
+	draw.Draw(dst, scaled.Bounds().Add(offset), scaled, image.Point{}, op)
+	return dst
+}
+
+// hexColor formats c as an opaque #rrggbb color
+func hexColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+}
+
+// WriteDir writes every file of the bundle to dir, which is created if
+// needed
+func (b *Bundle) WriteDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	for _, f := range b.Files {
+		if err := os.WriteFile(filepath.Join(dir, f.Name), f.Data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// File returns the file with the given name, or nil
+func (b *Bundle) File(name string) *File {
+	for i := range b.Files {
+		if b.Files[i].Name == name {
+			return &b.Files[i]
+		}
+	}
+	return nil
+}
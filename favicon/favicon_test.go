@@ -0,0 +1,175 @@
+package favicon
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+// createMaster returns a red square on a transparent background
+func createMaster(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := height / 4; y < height*3/4; y++ {
+		for x := width / 4; x < width*3/4; x++ {
+			img.Set(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+	return img
+}
+
+func decodePNG(t *testing.T, b *Bundle, name string) image.Image {
+	t.Helper()
+	f := b.File(name)
+	if f == nil {
+		t.Fatalf("Missing %s", name)
+	}
+	img, err := png.Decode(bytes.NewReader(f.Data))
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", name, err)
+	}
+	return img
+}
+
+func TestGenerate(t *testing.T) {
+	b, err := Generate(createMaster(512, 512), &Options{
+		Name:       "Example",
+		ShortName:  "Ex",
+		ThemeColor: color.NRGBA{0x12, 0x34, 0x56, 255},
+		Background: color.NRGBA{0, 0, 255, 255},
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	var names []string
+	for _, f := range b.Files {
+		names = append(names, f.Name)
+	}
+	want := "favicon.ico apple-touch-icon.png icon-192.png icon-192-maskable.png icon-512.png icon-512-maskable.png site.webmanifest"
+	if strings.Join(names, " ") != want {
+		t.Errorf("Unexpected files %v", names)
+	}
+
+	icoFile, err := ico.Decode(bytes.NewReader(b.File("favicon.ico").Data))
+	if err != nil {
+		t.Fatalf("Failed to decode favicon.ico: %v", err)
+	}
+	if sizes := icoFile.GetAvailableSizes(); len(sizes) != 3 || sizes[0].X != 16 || sizes[2].X != 48 {
+		t.Errorf("Expected favicon.ico sizes 16, 32 and 48, got %v", sizes)
+	}
+
+	for name, size := range map[string]int{"apple-touch-icon.png": 180, "icon-192.png": 192, "icon-512-maskable.png": 512} {
+		if img := decodePNG(t, b, name); img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+			t.Errorf("Expected %s to be %dx%d, got %v", name, size, size, img.Bounds())
+		}
+	}
+
+	// The regular icon keeps transparency; the touch icon is flattened
+	if _, _, _, a := decodePNG(t, b, "icon-192.png").At(0, 0).RGBA(); a != 0 {
+		t.Error("Expected transparent corner in icon-192.png")
+	}
+	if r, g, bl, a := decodePNG(t, b, "apple-touch-icon.png").At(0, 0).RGBA(); r != 0 || g != 0 || bl != 0xFFFF || a != 0xFFFF {
+		t.Error("Expected background corner in apple-touch-icon.png")
+	}
+
+	var m manifest
+	if err := json.Unmarshal(b.File("site.webmanifest").Data, &m); err != nil {
+		t.Fatalf("Invalid manifest: %v", err)
+	}
+	if m.Name != "Example" || m.ShortName != "Ex" || m.ThemeColor != "#123456" || m.BackgroundColor != "#0000ff" || len(m.Icons) != 4 {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	if m.Icons[1].Src != "/icon-192-maskable.png" || m.Icons[1].Purpose != "maskable" || m.Icons[1].Sizes != "192x192" {
+		t.Errorf("Unexpected maskable manifest icon %+v", m.Icons[1])
+	}
+
+	for _, tag := range []string{
+		`<link rel="icon" href="/favicon.ico" sizes="16x16 32x32 48x48">`,
+		`<link rel="apple-touch-icon" href="/apple-touch-icon.png">`,
+		`<link rel="manifest" href="/site.webmanifest">`,
+		`<meta name="theme-color" content="#123456">`,
+	} {
+		if !strings.Contains(b.HTML, tag) {
+			t.Errorf("Expected %s in HTML:\n%s", tag, b.HTML)
+		}
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	b, err := Generate(createMaster(300, 200), &Options{BasePath: "/static/icons"})
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if !strings.Contains(b.HTML, `href="/static/icons/favicon.ico"`) || strings.Contains(b.HTML, "theme-color") {
+		t.Errorf("Unexpected HTML:\n%s", b.HTML)
+	}
+	if !bytes.Contains(b.File("site.webmanifest").Data, []byte(`"/static/icons/icon-512.png"`)) {
+		t.Error("Expected base path in manifest")
+	}
+	if img := decodePNG(t, b, "icon-512.png"); img.Bounds().Dx() != 512 || img.Bounds().Dy() != 512 {
+		t.Errorf("Expected non-square master to be centered on a square, got %v", img.Bounds())
+	}
+
+	if _, err := Generate(createMaster(64, 64), &Options{Padding: 0.5}); err == nil {
+		t.Error("Expected error for padding of 0.5")
+	}
+
+	// A negative padding leaves maskable icons unpadded
+	red := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(red, red.Bounds(), image.NewUniform(color.NRGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	unpadded, err := Generate(red, &Options{Padding: -1})
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if r, g, _, _ := decodePNG(t, unpadded, "icon-512-maskable.png").At(0, 0).RGBA(); r != 0xFFFF || g != 0 {
+		t.Error("Expected the image to reach the edge of an unpadded maskable icon")
+	}
+	if _, err := Generate(image.NewNRGBA(image.Rect(0, 0, 0, 0)), nil); err == nil {
+		t.Error("Expected error for empty master")
+	}
+}
+
+func TestRenderPadding(t *testing.T) {
+	// A fully opaque master fills only the padded area of a maskable icon
+	master := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for i := 0; i < len(master.Pix); i += 4 {
+		copy(master.Pix[i:], []byte{255, 0, 0, 255})
+	}
+	img := Render(master, 100, 0.2, color.White)
+
+	if c := img.NRGBAAt(10, 50); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("Expected background in padding, got %v", c)
+	}
+	if c := img.NRGBAAt(50, 50); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("Expected image in center, got %v", c)
+	}
+	if c := img.NRGBAAt(21, 21); c.R != 255 || c.G > 8 {
+		t.Errorf("Expected image to start at the padding, got %v", c)
+	}
+}
+
+func TestWriteDir(t *testing.T) {
+	b, err := Generate(createMaster(64, 64), nil)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := b.WriteDir(dir); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	for _, f := range b.Files {
+		data, err := os.ReadFile(filepath.Join(dir, f.Name))
+		if err != nil || !bytes.Equal(data, f.Data) {
+			t.Errorf("Expected %s to be written", f.Name)
+		}
+	}
+}
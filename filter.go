@@ -0,0 +1,102 @@
+package ico
+
+import (
+	"image"
+	"slices"
+)
+
+// Filter keeps the entries for which keep returns true, removes the others
+// along with their directory entries and payloads, and returns the number
+// removed. Entries keep their relative order and the header count is
+// updated.
+//
+// An entry still holding the payload Decode read from it is described by
+// that payload's header, as Inspect would describe it. Any other entry is
+// described by its image and directory entry, with the format the default
+// Encode options would store it in.
+func (ico *ICO) Filter(keep func(EntryInfo) bool) int {
+	ico.syncEntries()
+
+	var entries []DirectoryEntry
+	var images []image.Image
+	var payloads [][]byte
+	for i := range ico.Images {
+		if !keep(ico.entryInfo(i)) {
+			continue
+		}
+		entries = append(entries, ico.Entries[i])
+		images = append(images, ico.Images[i])
+		if i < len(ico.Payloads) {
+			payloads = append(payloads, ico.Payloads[i])
+		} else if ico.Payloads != nil {
+			payloads = append(payloads, nil)
+		}
+	}
+
+	removed := len(ico.Images) - len(images)
+	if ico.Payloads != nil && payloads == nil {
+		payloads = [][]byte{}
+	}
+	ico.Entries, ico.Images, ico.Payloads = entries, images, payloads
+	ico.Header.Count = uint16(len(ico.Images))
+	return removed
+}
+
+// entryInfo describes entry i for Filter
+func (ico *ICO) entryInfo(i int) EntryInfo {
+	e := EntryInfo{Index: i, Entry: ico.Entries[i]}
+	if data := ico.unchangedPayload(i); data != nil {
+		e.Format, e.Width, e.Height, e.BitsPerPixel, e.Err = inspectPayload(data)
+		return e
+	}
+
+	b := ico.Images[i].Bounds()
+	e.Format = SizePolicy(256)(ico, i).Format
+	e.Width, e.Height = b.Dx(), b.Dy()
+	e.BitsPerPixel = ico.entryDepth(i)
+	return e
+}
+
+// EntryFilter selects entries by size, bit depth and format. Its Match
+// method can be passed to Filter, or applied to the entries of an Info.
+// The zero EntryFilter matches every entry.
+type EntryFilter struct {
+	MinSize int // Minimum width and height, or 0 for no minimum
+	MaxSize int // Maximum width and height, or 0 for no maximum
+
+	// BitsPerPixel lists the bit depths to match, or nil for any
+	BitsPerPixel []int
+
+	// Formats lists the payload formats to match, or nil for any
+	Formats []Format
+
+	// SquareOnly matches only entries as wide as they are tall
+	SquareOnly bool
+}
+
+// Match reports whether e passes every condition of the filter. It uses
+// the size and bit depth of the payload, or the size in the directory
+// entry if the payload could not be read.
+func (f EntryFilter) Match(e EntryInfo) bool {
+	width, height := e.Width, e.Height
+	if e.Err != nil && width == 0 && height == 0 {
+		width, height = e.Entry.GetWidth(), e.Entry.GetHeight()
+	}
+
+	if f.MinSize > 0 && (width < f.MinSize || height < f.MinSize) {
+		return false
+	}
+	if f.MaxSize > 0 && (width > f.MaxSize || height > f.MaxSize) {
+		return false
+	}
+	if f.SquareOnly && width != height {
+		return false
+	}
+	if f.BitsPerPixel != nil && !slices.Contains(f.BitsPerPixel, e.BitsPerPixel) {
+		return false
+	}
+	if f.Formats != nil && !slices.Contains(f.Formats, e.Format) {
+		return false
+	}
+	return true
+}
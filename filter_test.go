@@ -0,0 +1,97 @@
+package ico
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"slices"
+	"testing"
+)
+
+func TestFilterDecoded(t *testing.T) {
+	decoded, err := Decode(bytes.NewReader(createMixedICO(t)))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	// The payloads decide: the 16px entry is an 8-bit BMP, the 48px one a
+	// 32-bit PNG
+	var seen []EntryInfo
+	removed := decoded.Filter(func(e EntryInfo) bool {
+		seen = append(seen, e)
+		return e.Format == FormatPNG
+	})
+	if removed != 1 || len(seen) != 2 {
+		t.Fatalf("Expected 1 of 2 entries removed, got %d of %d", removed, len(seen))
+	}
+	if e := seen[0]; e.Index != 0 || e.Format != FormatBMP || e.Width != 16 || e.BitsPerPixel != 8 {
+		t.Errorf("Unexpected description of BMP entry: %+v", e)
+	}
+	if decoded.Header.Count != 1 || len(decoded.Entries) != 1 || len(decoded.Payloads) != 1 ||
+		decoded.Images[0].Bounds().Dx() != 48 {
+		t.Fatalf("Expected only the 48px entry to remain, got %+v", decoded.Entries)
+	}
+
+	// The remaining payload is still written back verbatim
+	original := decoded.Payloads[0]
+	var buf bytes.Buffer
+	if err := Encode(&buf, decoded, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), original) {
+		t.Error("Expected the PNG payload to be preserved")
+	}
+}
+
+func TestFilterBuilt(t *testing.T) {
+	icoFile := &ICO{}
+	for _, size := range []int{16, 32, 256} {
+		if err := icoFile.AddImage(createTestImage(size), &AddOptions{BitsPerPixel: 8}); err != nil {
+			t.Fatalf("Failed to add image: %v", err)
+		}
+	}
+	if err := icoFile.AddImage(image.NewNRGBA(image.Rect(0, 0, 32, 16)), nil); err != nil {
+		t.Fatalf("Failed to add image: %v", err)
+	}
+
+	// Images without payloads are described as Encode would store them
+	var formats []Format
+	icoFile.Filter(func(e EntryInfo) bool {
+		formats = append(formats, e.Format)
+		return true
+	})
+	if want := []Format{FormatBMP, FormatBMP, FormatPNG, FormatBMP}; !slices.Equal(formats, want) {
+		t.Errorf("Expected formats %v, got %v", want, formats)
+	}
+
+	removed := icoFile.Filter(EntryFilter{MinSize: 24, MaxSize: 64, BitsPerPixel: []int{8}}.Match)
+	if removed != 3 || len(icoFile.Images) != 1 || icoFile.Images[0].Bounds().Dx() != 32 || icoFile.Header.Count != 1 {
+		t.Errorf("Expected only the 8-bit 32px entry to remain, removed %d of %d", removed, removed+len(icoFile.Images))
+	}
+}
+
+func TestEntryFilterMatch(t *testing.T) {
+	square := EntryInfo{Format: FormatPNG, Width: 32, Height: 32, BitsPerPixel: 32}
+	wide := EntryInfo{Format: FormatBMP, Width: 48, Height: 32, BitsPerPixel: 8}
+	corrupt := EntryInfo{Entry: newDirectoryEntry(image.Rect(0, 0, 64, 64), 32), Err: errors.New("truncated")}
+
+	for _, tt := range []struct {
+		name   string
+		filter EntryFilter
+		want   [3]bool // square, wide, corrupt
+	}{
+		{"zero", EntryFilter{}, [3]bool{true, true, true}},
+		{"min", EntryFilter{MinSize: 33}, [3]bool{false, false, true}},
+		{"max", EntryFilter{MaxSize: 40}, [3]bool{true, false, false}},
+		{"square", EntryFilter{SquareOnly: true}, [3]bool{true, false, true}},
+		{"bpp", EntryFilter{BitsPerPixel: []int{4, 8}}, [3]bool{false, true, false}},
+		{"format", EntryFilter{Formats: []Format{FormatBMP}}, [3]bool{false, true, true}},
+		{"combined", EntryFilter{MinSize: 32, MaxSize: 32, Formats: []Format{FormatPNG}}, [3]bool{true, false, false}},
+	} {
+		for i, e := range []EntryInfo{square, wide, corrupt} {
+			if got := tt.filter.Match(e); got != tt.want[i] {
+				t.Errorf("%s: entry %d: expected %v, got %v", tt.name, i, tt.want[i], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package icns
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/thatoddmailbox/go-ico"
+	"github.com/thatoddmailbox/go-ico/resample"
+)
+
+// FromICO converts an ICO to an ICNS with one entry per square ICO entry
+// whose size an ICNS file can hold (16, 32, 48, 64, 128 or 256 pixels).
+// When several entries share a size, the one with the highest bit depth is
+// used. Entries of other sizes are skipped; use ico.FromMaster to resample
+// them first if needed. The images are shared, not copied.
+func FromICO(src *ico.ICO) (*ICNS, error) {
+	icns := &ICNS{}
+	for _, size := range Sizes() {
+		best := -1
+		for i, img := range src.Images {
+			if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+				continue
+			}
+			if best < 0 || depth(src, i) > depth(src, best) {
+				best = i
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		icns.Entries = append(icns.Entries, Entry{Type: defaultTypes[size], Width: size, Height: size})
+		icns.Images = append(icns.Images, src.Images[best])
+	}
+
+	if len(icns.Images) == 0 {
+		return nil, fmt.Errorf("ICO has no entries of an ICNS size")
+	}
+	return icns, nil
+}
+
+// depth returns the bit depth of ICO entry i, or 0 if unknown
+func depth(src *ico.ICO, i int) int {
+	if src.Header.Type == ico.TypeCUR || i >= len(src.Entries) {
+		return 0
+	}
+	return int(src.Entries[i].BitsPerPixel)
+}
+
+// ToICO converts the ICNS to an ICO with one 32-bit entry per image size
+// of at most 256 pixels, sorted from smallest to largest. Where several
+// entries share a size, standard-resolution ones are preferred over Retina
+// variants. If the ICNS has no 256-pixel image but a larger one, a 256-pixel
+// entry is resampled from the smallest larger image. The images are shared,
+// not copied.
+func (icns *ICNS) ToICO() (*ico.ICO, error) {
+	out := &ico.ICO{Header: ico.Header{Type: ico.TypeICO}}
+
+	used := make(map[image.Point]bool)
+	var larger image.Image
+	for _, scale := range []int{1, 2} {
+		for i, img := range icns.Images {
+			if i < len(icns.Entries) && icns.Entries[i].Scale() != scale {
+				continue
+			}
+
+			b := img.Bounds()
+			size := b.Size()
+			if b.Dx() > 256 || b.Dy() > 256 {
+				if larger == nil || b.Dx()*b.Dy() < larger.Bounds().Dx()*larger.Bounds().Dy() {
+					larger = img
+				}
+				continue
+			}
+			if used[size] {
+				continue
+			}
+			used[size] = true
+
+			if err := out.AddImage(img, nil); err != nil {
+				return nil, fmt.Errorf("failed to add image %d: %w", i, err)
+			}
+		}
+	}
+
+	if larger != nil && !used[image.Pt(256, 256)] {
+		b := larger.Bounds()
+		w, h := 256, 256
+		if b.Dx() > b.Dy() {
+			h = max(1, b.Dy()*256/b.Dx())
+		} else {
+			w = max(1, b.Dx()*256/b.Dy())
+		}
+		img := resample.Resize(larger, w, h, &resample.Options{Filter: resample.Lanczos3})
+		if err := out.AddImage(img, nil); err != nil {
+			return nil, fmt.Errorf("failed to add resampled image: %w", err)
+		}
+	}
+
+	if len(out.Images) == 0 {
+		return nil, fmt.Errorf("ICNS has no images")
+	}
+	out.SortBySize()
+	return out, nil
+}
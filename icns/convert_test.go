@@ -0,0 +1,80 @@
+package icns
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+func TestFromICO(t *testing.T) {
+	src := &ico.ICO{}
+	src.AddImage(createTestImage(16), &ico.AddOptions{BitsPerPixel: 4})
+	best16 := createTestImage(16)
+	src.AddImage(best16, nil)
+	src.AddImage(createTestImage(24), nil)
+	src.AddImage(createTestImage(256), nil)
+
+	icns, err := FromICO(src)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	if len(icns.Images) != 2 {
+		t.Fatalf("Expected 16 and 256px images, got %v", icns.GetAvailableSizes())
+	}
+	if icns.Images[0] != image.Image(best16) {
+		t.Error("Expected the 32-bit 16px entry to be used")
+	}
+	if icns.Entries[0].Type != "is32" || icns.Entries[1].Type != "ic08" {
+		t.Errorf("Unexpected types %q, %q", icns.Entries[0].Type, icns.Entries[1].Type)
+	}
+
+	if err := Encode(&bytes.Buffer{}, icns); err != nil {
+		t.Errorf("Failed to encode converted ICNS: %v", err)
+	}
+
+	odd := &ico.ICO{}
+	odd.AddImage(createTestImage(24), nil)
+	if _, err := FromICO(odd); err == nil {
+		t.Error("Expected error for ICO without ICNS sizes")
+	}
+}
+
+func TestToICO(t *testing.T) {
+	std32 := createTestImage(32)
+	icns := &ICNS{
+		Entries: []Entry{{Type: "ic09"}, {Type: "ic11"}, {Type: "il32"}, {Type: "is32"}, {Type: "ic10"}},
+		Images: []image.Image{
+			createTestImage(512), createTestImage(32), std32, createTestImage(16), createTestImage(1024),
+		},
+	}
+
+	out, err := icns.ToICO()
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	sizes := out.GetAvailableSizes()
+	want := []image.Point{{16, 16}, {32, 32}, {256, 256}}
+	if len(sizes) != len(want) {
+		t.Fatalf("Got sizes %v, want %v", sizes, want)
+	}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Errorf("Size %d: %v, want %v", i, sizes[i], want[i])
+		}
+	}
+	if out.Images[1] != image.Image(std32) {
+		t.Error("Expected the standard-resolution 32px image over the Retina one")
+	}
+	if out.Header.Count != 3 || out.Entries[2].Width != 0 {
+		t.Errorf("Unexpected header %+v or 256px entry %+v", out.Header, out.Entries[2])
+	}
+
+	var buf bytes.Buffer
+	if err := ico.Encode(&buf, out, nil); err != nil {
+		t.Errorf("Failed to encode converted ICO: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package icns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// defaultTypes maps image sizes to the element type Encode uses when an
+// entry does not name one. Sizes up to 48 use the RLE types every version
+// of macOS reads.
+var defaultTypes = map[int]string{
+	16:   "is32",
+	32:   "il32",
+	48:   "ih32",
+	64:   "ic12",
+	128:  "ic07",
+	256:  "ic08",
+	512:  "ic09",
+	1024: "ic10",
+}
+
+// Sizes returns the square image sizes an ICNS file can hold, in
+// increasing order.
+func Sizes() []int {
+	sizes := make([]int, 0, len(defaultTypes))
+	for size := range defaultTypes {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// Encode writes icns as an ICNS file, with a table of contents followed by
+// one element per image (two for RLE types, whose alpha is stored in a
+// separate mask element). Each image is stored as the type in its entry,
+// or, if the entry has no type, as the default type for its size: is32,
+// il32 or ih32 for 16, 32 and 48 pixels, and PNG types for 64 pixels and
+// up. Every image must be exactly the size of its type, and no type may
+// appear twice.
+func Encode(w io.Writer, icns *ICNS) error {
+	if len(icns.Images) == 0 {
+		return fmt.Errorf("ICNS contains no images")
+	}
+
+	var elements []element
+	seen := make(map[string]bool)
+	for i, img := range icns.Images {
+		b := img.Bounds()
+
+		var typ string
+		if i < len(icns.Entries) {
+			typ = icns.Entries[i].Type
+		}
+		if typ == "" {
+			if b.Dx() != b.Dy() || defaultTypes[b.Dx()] == "" {
+				return fmt.Errorf("image %d is %dx%d, which no ICNS element type holds", i, b.Dx(), b.Dy())
+			}
+			typ = defaultTypes[b.Dx()]
+		}
+
+		t, ok := elementTypes[typ]
+		if !ok {
+			return fmt.Errorf("image %d has unsupported element type %q", i, typ)
+		}
+		if b.Dx() != t.size || b.Dy() != t.size {
+			return fmt.Errorf("image %d is %dx%d, but %q holds %dx%d images", i, b.Dx(), b.Dy(), typ, t.size, t.size)
+		}
+		if seen[typ] {
+			return fmt.Errorf("image %d duplicates element type %q", i, typ)
+		}
+		seen[typ] = true
+
+		if t.mask == "" {
+			var buf bytes.Buffer
+			encoder := png.Encoder{CompressionLevel: png.BestCompression}
+			if err := encoder.Encode(&buf, img); err != nil {
+				return fmt.Errorf("failed to encode image %d: %w", i, err)
+			}
+			elements = append(elements, element{typ: typ, data: buf.Bytes()})
+		} else {
+			rgb, mask := encodeRLE(typ, img)
+			elements = append(elements, element{typ: typ, data: rgb}, element{typ: t.mask, data: mask})
+		}
+	}
+
+	return writeElements(w, elements)
+}
+
+// encodeRLE returns the RLE color data and the alpha mask of img
+func encodeRLE(typ string, img image.Image) ([]byte, []byte) {
+	src := toNRGBA(img)
+	n := len(src.Pix) / 4
+
+	channel := make([]byte, n)
+	var rgb []byte
+	if typ == "it32" {
+		rgb = make([]byte, 4)
+	}
+	for c := 0; c < 3; c++ {
+		for i := range channel {
+			channel[i] = src.Pix[4*i+c]
+		}
+		rgb = packBits(rgb, channel)
+	}
+
+	mask := make([]byte, n)
+	for i := range mask {
+		mask[i] = src.Pix[4*i+3]
+	}
+	return rgb, mask
+}
+
+// writeElements writes the file header, a table of contents and elements
+func writeElements(w io.Writer, elements []element) error {
+	tocSize := 8 + 8*len(elements)
+	total := 8 + tocSize
+	for _, el := range elements {
+		total += 8 + len(el.data)
+	}
+	if int64(total) > math.MaxUint32 {
+		return fmt.Errorf("ICNS file too large: %d bytes", total)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	binary.Write(&buf, binary.BigEndian, uint32(total))
+
+	buf.WriteString(typeTOC)
+	binary.Write(&buf, binary.BigEndian, uint32(tocSize))
+	for _, el := range elements {
+		buf.WriteString(el.typ)
+		binary.Write(&buf, binary.BigEndian, uint32(8+len(el.data)))
+	}
+
+	for _, el := range elements {
+		buf.WriteString(el.typ)
+		binary.Write(&buf, binary.BigEndian, uint32(8+len(el.data)))
+		buf.Write(el.data)
+	}
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write ICNS data: %w", err)
+	}
+	return nil
+}
+
+// toNRGBA returns a copy of img as an NRGBA image with its origin at (0, 0)
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,104 @@
+package icns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	var icns ICNS
+	for typ, info := range elementTypes {
+		icns.Entries = append(icns.Entries, Entry{Type: typ})
+		icns.Images = append(icns.Images, createTestImage(info.size))
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, &icns); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if len(decoded.Images) != len(icns.Images) {
+		t.Fatalf("Got %d images, want %d", len(decoded.Images), len(icns.Images))
+	}
+	for i, entry := range decoded.Entries {
+		if entry.Type != icns.Entries[i].Type {
+			t.Errorf("Entry %d: type %q, want %q", i, entry.Type, icns.Entries[i].Type)
+		}
+		assertSamePixels(t, entry.Type, icns.Images[i], decoded.Images[i])
+	}
+}
+
+func TestEncodeDefaultTypes(t *testing.T) {
+	icns := &ICNS{}
+	for _, size := range Sizes() {
+		icns.Images = append(icns.Images, createTestImage(size))
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, icns); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	want := []string{"is32", "il32", "ih32", "ic12", "ic07", "ic08", "ic09", "ic10"}
+	for i, entry := range decoded.Entries {
+		if entry.Type != want[i] {
+			t.Errorf("Entry %d: type %q, want %q", i, entry.Type, want[i])
+		}
+	}
+}
+
+func TestEncodeTOC(t *testing.T) {
+	icns := &ICNS{Images: []image.Image{createTestImage(16), createTestImage(128)}}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, icns); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	data := buf.Bytes()
+
+	if got := binary.BigEndian.Uint32(data[4:]); int(got) != len(data) {
+		t.Errorf("Header length %d, file is %d bytes", got, len(data))
+	}
+	if string(data[8:12]) != "TOC " {
+		t.Fatalf("Expected TOC first, got %q", data[8:12])
+	}
+
+	// The TOC lists is32, s8mk and ic07 with the lengths of the elements that follow
+	toc := data[16:40]
+	off := 8 + 8 + len(toc)
+	for i, typ := range []string{"is32", "s8mk", "ic07"} {
+		entry := toc[8*i:]
+		if string(entry[:4]) != typ || string(data[off:off+4]) != typ {
+			t.Errorf("TOC entry %d: %q, element %q, want %q", i, entry[:4], data[off:off+4], typ)
+		}
+		if !bytes.Equal(entry[4:8], data[off+4:off+8]) {
+			t.Errorf("TOC entry %d length differs from element", i)
+		}
+		off += int(binary.BigEndian.Uint32(entry[4:8]))
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	cases := map[string]*ICNS{
+		"empty":          {},
+		"odd size":       {Images: []image.Image{createTestImage(20)}},
+		"size mismatch":  {Entries: []Entry{{Type: "ic08"}}, Images: []image.Image{createTestImage(128)}},
+		"unknown type":   {Entries: []Entry{{Type: "abcd"}}, Images: []image.Image{createTestImage(16)}},
+		"duplicate type": {Images: []image.Image{createTestImage(32), createTestImage(32)}},
+	}
+	for name, icns := range cases {
+		if err := Encode(&bytes.Buffer{}, icns); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// Package icns decodes and encodes Apple ICNS icon families, the macOS
+// counterpart of ICO files. An ICNS file is a sequence of typed elements,
+// each holding one image: PNG data for the modern ic07-ic14 types, or
+// run-length encoded RGB with a separate 8-bit alpha mask for the legacy
+// is32, il32, ih32 and it32 types.
+package icns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// magic identifies an ICNS file; it is followed by the file length
+const magic = "icns"
+
+// typeTOC is the optional table of contents listing the other elements
+const typeTOC = "TOC "
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// JPEG 2000 data, which some older icons store in the PNG element types,
+// starts with either a JP2 signature box or a codestream marker
+var (
+	jp2Signature       = []byte{0x00, 0x00, 0x00, 0x0C, 'j', 'P', ' ', ' '}
+	jpeg2000Codestream = []byte{0xFF, 0x4F, 0xFF, 0x51}
+)
+
+// elementType describes an image element type
+type elementType struct {
+	size  int    // Width and height in pixels
+	scale int    // 2 for Retina variants of a smaller point size
+	mask  string // Type of the separate alpha mask for RLE types; empty for PNG types
+}
+
+// elementTypes lists the image element types this package reads and writes
+var elementTypes = map[string]elementType{
+	"is32": {size: 16, scale: 1, mask: "s8mk"},
+	"il32": {size: 32, scale: 1, mask: "l8mk"},
+	"ih32": {size: 48, scale: 1, mask: "h8mk"},
+	"it32": {size: 128, scale: 1, mask: "t8mk"},
+	"ic07": {size: 128, scale: 1},
+	"ic08": {size: 256, scale: 1},
+	"ic09": {size: 512, scale: 1},
+	"ic10": {size: 1024, scale: 2},
+	"ic11": {size: 32, scale: 2},
+	"ic12": {size: 64, scale: 2},
+	"ic13": {size: 256, scale: 2},
+	"ic14": {size: 512, scale: 2},
+}
+
+// Entry describes one image of an ICNS file
+type Entry struct {
+	Type   string // Element type, such as "ic08" or "il32"
+	Width  int    // Width in pixels
+	Height int    // Height in pixels
+}
+
+// Scale returns 2 if the entry is a Retina (@2x) variant, and 1 otherwise
+func (e Entry) Scale() int {
+	if t, ok := elementTypes[e.Type]; ok {
+		return t.scale
+	}
+	return 1
+}
+
+// IsPNG reports whether the entry's type stores PNG data, as opposed to
+// run-length encoded RGB with a separate mask
+func (e Entry) IsPNG() bool {
+	t, ok := elementTypes[e.Type]
+	return ok && t.mask == ""
+}
+
+// ICNS represents a decoded ICNS file
+type ICNS struct {
+	Entries []Entry
+	Images  []image.Image
+}
+
+// Decode decodes an ICNS file from the given reader. Elements of types this
+// package does not know, and PNG-type elements holding JPEG 2000 data, which
+// Go cannot decode, are skipped.
+func Decode(r io.Reader) (*ICNS, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICNS data: %w", err)
+	}
+
+	elements, err := readElements(data)
+	if err != nil {
+		return nil, err
+	}
+
+	masks := make(map[string][]byte)
+	for _, el := range elements {
+		masks[el.typ] = el.data
+	}
+
+	icns := &ICNS{}
+	for _, el := range elements {
+		t, ok := elementTypes[el.typ]
+		if !ok {
+			continue
+		}
+
+		var img image.Image
+		if t.mask == "" {
+			if bytes.HasPrefix(el.data, jp2Signature) || bytes.HasPrefix(el.data, jpeg2000Codestream) {
+				continue
+			}
+			if !bytes.HasPrefix(el.data, pngSignature) {
+				return nil, fmt.Errorf("element %q holds neither PNG nor JPEG 2000 data", el.typ)
+			}
+			img, err = png.Decode(bytes.NewReader(el.data))
+		} else {
+			img, err = decodeRLE(el.typ, el.data, masks[t.mask], t.size)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode element %q: %w", el.typ, err)
+		}
+
+		b := img.Bounds()
+		icns.Entries = append(icns.Entries, Entry{Type: el.typ, Width: b.Dx(), Height: b.Dy()})
+		icns.Images = append(icns.Images, img)
+	}
+
+	if len(icns.Images) == 0 {
+		return nil, fmt.Errorf("ICNS file contains no supported images")
+	}
+	return icns, nil
+}
+
+// element is a raw ICNS element
+type element struct {
+	typ  string
+	data []byte
+}
+
+// readElements splits an ICNS file into its elements, excluding the table
+// of contents
+func readElements(data []byte) ([]element, error) {
+	if len(data) < 8 || string(data[:4]) != magic {
+		return nil, fmt.Errorf("invalid ICNS file: missing %q signature", magic)
+	}
+
+	length := binary.BigEndian.Uint32(data[4:8])
+	if length < 8 || uint64(length) > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid ICNS file: declared length %d, have %d bytes", length, len(data))
+	}
+	data = data[:length]
+
+	var elements []element
+	for off := 8; off < len(data); {
+		if len(data)-off < 8 {
+			return nil, fmt.Errorf("truncated element header at offset %d", off)
+		}
+		typ := string(data[off : off+4])
+		size := binary.BigEndian.Uint32(data[off+4 : off+8])
+		if size < 8 || uint64(size) > uint64(len(data)-off) {
+			return nil, fmt.Errorf("element %q at offset %d has invalid length %d", typ, off, size)
+		}
+
+		if typ != typeTOC {
+			elements = append(elements, element{typ: typ, data: data[off+8 : off+int(size)]})
+		}
+		off += int(size)
+	}
+	return elements, nil
+}
+
+// decodeRLE decodes an RLE-type element and applies its mask, if present
+func decodeRLE(typ string, data, mask []byte, size int) (image.Image, error) {
+	n := size * size
+
+	// it32 data starts with four zero bytes of unknown purpose
+	if typ == "it32" {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("it32 data too short")
+		}
+		data = data[4:]
+	}
+
+	var planes []byte
+	if len(data) == 4*n {
+		// Some old files store uncompressed ARGB with an unused alpha byte
+		planes = make([]byte, 3*n)
+		for i := 0; i < n; i++ {
+			planes[i], planes[n+i], planes[2*n+i] = data[4*i+1], data[4*i+2], data[4*i+3]
+		}
+	} else {
+		var err error
+		if planes, err = unpackBits(data, 3*n); err != nil {
+			return nil, err
+		}
+	}
+
+	if mask != nil && len(mask) != n {
+		return nil, fmt.Errorf("mask has %d bytes, want %d", len(mask), n)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for i := 0; i < n; i++ {
+		alpha := uint8(255)
+		if mask != nil {
+			alpha = mask[i]
+		}
+		img.Pix[4*i] = planes[i]
+		img.Pix[4*i+1] = planes[n+i]
+		img.Pix[4*i+2] = planes[2*n+i]
+		img.Pix[4*i+3] = alpha
+	}
+	return img, nil
+}
+
+// GetBestImage returns the image with the highest resolution from the ICNS
+// file. If multiple images have the same resolution, it returns the first
+// one found.
+func (icns *ICNS) GetBestImage() image.Image {
+	if len(icns.Images) == 0 {
+		return nil
+	}
+
+	bestIndex := 0
+	for i, entry := range icns.Entries {
+		best := icns.Entries[bestIndex]
+		if entry.Width*entry.Height > best.Width*best.Height {
+			bestIndex = i
+		}
+	}
+	return icns.Images[bestIndex]
+}
+
+// GetImageBySize returns the image that best matches the requested size.
+// It finds the image with dimensions closest to the requested width and height.
+func (icns *ICNS) GetImageBySize(width, height int) image.Image {
+	if len(icns.Images) == 0 {
+		return nil
+	}
+
+	bestIndex := 0
+	bestScore := scoreSizeMatch(icns.Entries[0], width, height)
+	for i, entry := range icns.Entries {
+		if score := scoreSizeMatch(entry, width, height); score < bestScore {
+			bestScore = score
+			bestIndex = i
+		}
+	}
+	return icns.Images[bestIndex]
+}
+
+// GetAvailableSizes returns a slice of available image sizes in the ICNS file.
+// Each element contains the width and height of an available image.
+func (icns *ICNS) GetAvailableSizes() []image.Point {
+	sizes := make([]image.Point, len(icns.Entries))
+	for i, entry := range icns.Entries {
+		sizes[i] = image.Pt(entry.Width, entry.Height)
+	}
+	return sizes
+}
+
+// scoreSizeMatch calculates how well an image size matches the requested size.
+// Lower scores indicate better matches.
+func scoreSizeMatch(entry Entry, targetWidth, targetHeight int) int {
+	widthDiff := entry.Width - targetWidth
+	heightDiff := entry.Height - targetHeight
+	return widthDiff*widthDiff + heightDiff*heightDiff
+}
+
+// Config represents the metadata of an ICNS file without decoding the image data.
+type Config struct {
+	Width  int
+	Height int
+	Count  int
+}
+
+// DecodeConfig decodes just the configuration (metadata) of an ICNS file
+// without decoding the image data. It returns the nominal dimensions of the
+// largest image element and the number of image elements.
+func DecodeConfig(r io.Reader) (Config, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Config{}, fmt.Errorf("failed to read ICNS header: %w", err)
+	}
+	if string(header[:4]) != magic {
+		return Config{}, fmt.Errorf("invalid ICNS file")
+	}
+
+	remaining := int64(binary.BigEndian.Uint32(header[4:])) - 8
+	var config Config
+	for remaining >= 8 {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return Config{}, fmt.Errorf("failed to read element header: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if size < 8 || size > remaining {
+			return Config{}, fmt.Errorf("element %q has invalid length %d", header[:4], size)
+		}
+
+		if t, ok := elementTypes[string(header[:4])]; ok {
+			config.Count++
+			if t.size > config.Width {
+				config.Width, config.Height = t.size, t.size
+			}
+		}
+
+		if _, err := io.CopyN(io.Discard, r, size-8); err != nil {
+			return Config{}, fmt.Errorf("failed to read element %q: %w", header[:4], err)
+		}
+		remaining -= size
+	}
+
+	if config.Count == 0 {
+		return Config{}, fmt.Errorf("ICNS file contains no supported images")
+	}
+	return config, nil
+}
+
+// decode returns the best (highest resolution) image for image package compatibility
+func decode(r io.Reader) (image.Image, error) {
+	icns, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return icns.GetBestImage(), nil
+}
+
+// decodeConfig returns config for the best image
+func decodeConfig(r io.Reader) (image.Config, error) {
+	config, err := DecodeConfig(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      config.Width,
+		Height:     config.Height,
+	}, nil
+}
+
+func init() {
+	image.RegisterFormat("icns", magic, decode, decodeConfig)
+}
@@ -0,0 +1,188 @@
+package icns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// createTestImage returns a size x size image with four colored quadrants
+// and a translucent top-left pixel.
+func createTestImage(size int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	quadrants := []color.NRGBA{
+		{R: 255, A: 255}, {G: 255, A: 255},
+		{B: 255, A: 255}, {R: 255, G: 255, B: 255, A: 255},
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			q := 0
+			if x >= size/2 {
+				q++
+			}
+			if y >= size/2 {
+				q += 2
+			}
+			img.SetNRGBA(x, y, quadrants[q])
+		}
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 128})
+	return img
+}
+
+// buildICNS assembles an ICNS file from type and data pairs.
+func buildICNS(elements ...element) []byte {
+	var body bytes.Buffer
+	for _, el := range elements {
+		body.WriteString(el.typ)
+		binary.Write(&body, binary.BigEndian, uint32(8+len(el.data)))
+		body.Write(el.data)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("icns")
+	binary.Write(&buf, binary.BigEndian, uint32(8+body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func pngData(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func assertSamePixels(t *testing.T, name string, want, got image.Image) {
+	t.Helper()
+	if want.Bounds().Size() != got.Bounds().Size() {
+		t.Fatalf("%s: size %v, want %v", name, got.Bounds().Size(), want.Bounds().Size())
+	}
+	wb, gb := want.Bounds(), got.Bounds()
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			w := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y))
+			g := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y))
+			if w != g {
+				t.Fatalf("%s: pixel (%d,%d) is %v, want %v", name, x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	small := createTestImage(16)
+	rgb, mask := encodeRLE("is32", small)
+	large := createTestImage(128)
+
+	data := buildICNS(
+		element{typ: "TOC ", data: make([]byte, 8)},
+		element{typ: "icnV", data: []byte{0, 0, 0, 0}},
+		element{typ: "is32", data: rgb},
+		element{typ: "s8mk", data: mask},
+		element{typ: "ic07", data: pngData(t, large)},
+		element{typ: "ic08", data: append(append([]byte(nil), jp2Signature...), 0, 0)},
+	)
+
+	icns, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if len(icns.Images) != 2 {
+		t.Fatalf("Expected 2 images (JPEG 2000 skipped), got %d", len(icns.Images))
+	}
+	if e := icns.Entries[0]; e.Type != "is32" || e.Width != 16 || e.IsPNG() {
+		t.Errorf("Unexpected first entry %+v", e)
+	}
+	if e := icns.Entries[1]; e.Type != "ic07" || e.Width != 128 || !e.IsPNG() {
+		t.Errorf("Unexpected second entry %+v", e)
+	}
+	assertSamePixels(t, "is32", small, icns.Images[0])
+	assertSamePixels(t, "ic07", large, icns.Images[1])
+}
+
+func TestDecodeUnmasked(t *testing.T) {
+	rgb, _ := encodeRLE("il32", createTestImage(32))
+	icns, err := Decode(bytes.NewReader(buildICNS(element{typ: "il32", data: rgb})))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if _, _, _, a := icns.Images[0].At(0, 0).RGBA(); a != 0xFFFF {
+		t.Errorf("Expected an RLE image without mask to be opaque, got alpha %d", a)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := buildICNS(element{typ: "ic07", data: pngData(t, createTestImage(128))})
+
+	badLength := append([]byte(nil), valid...)
+	binary.BigEndian.PutUint32(badLength[12:], 1<<20)
+
+	rgb, _ := encodeRLE("is32", createTestImage(16))
+
+	cases := map[string][]byte{
+		"empty":           {},
+		"bad magic":       append([]byte("icnz"), valid[4:]...),
+		"truncated":       valid[:len(valid)-10],
+		"element length":  badLength,
+		"no images":       buildICNS(element{typ: "icnV", data: []byte{0, 0, 0, 0}}),
+		"not PNG":         buildICNS(element{typ: "ic07", data: []byte("garbage")}),
+		"truncated RLE":   buildICNS(element{typ: "is32", data: rgb[:len(rgb)/2]}),
+		"wrong mask size": buildICNS(element{typ: "is32", data: rgb}, element{typ: "s8mk", data: make([]byte, 10)}),
+	}
+	for name, data := range cases {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	rgb, mask := encodeRLE("il32", createTestImage(32))
+	data := buildICNS(
+		element{typ: "il32", data: rgb},
+		element{typ: "l8mk", data: mask},
+		element{typ: "ic08", data: pngData(t, createTestImage(256))},
+	)
+
+	config, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	if config.Width != 256 || config.Height != 256 || config.Count != 2 {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode through image package: %v", err)
+	}
+	if format != "icns" || img.Bounds().Dx() != 256 {
+		t.Errorf("Expected 256px icns image, got %s %v", format, img.Bounds())
+	}
+}
+
+func TestSelection(t *testing.T) {
+	icns := &ICNS{}
+	for _, size := range []int{16, 128, 32} {
+		icns.Entries = append(icns.Entries, Entry{Width: size, Height: size})
+		icns.Images = append(icns.Images, createTestImage(size))
+	}
+
+	if b := icns.GetBestImage().Bounds(); b.Dx() != 128 {
+		t.Errorf("Expected best image of 128px, got %v", b)
+	}
+	if b := icns.GetImageBySize(30, 30).Bounds(); b.Dx() != 32 {
+		t.Errorf("Expected 32px image for 30x30, got %v", b)
+	}
+	sizes := icns.GetAvailableSizes()
+	if len(sizes) != 3 || sizes[1] != image.Pt(128, 128) {
+		t.Errorf("Unexpected sizes %v", sizes)
+	}
+}
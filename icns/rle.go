@@ -0,0 +1,74 @@
+package icns
+
+import "fmt"
+
+// The RLE element types compress each color channel in turn with a PackBits
+// variant: a control byte below 0x80 is followed by control+1 literal bytes,
+// and a control byte of 0x80 or above repeats the following byte control-125
+// times, so runs are 3 to 130 bytes long.
+const (
+	maxLiteral = 128
+	minRun     = 3
+	maxRun     = 130
+)
+
+// unpackBits decodes n bytes of RLE data. Runs may cross channel
+// boundaries, which some encoders produce, since all channels are decoded
+// as one stream.
+func unpackBits(data []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	pos := 0
+	for len(out) < n {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("RLE data truncated after %d of %d bytes", len(out), n)
+		}
+		control := int(data[pos])
+		pos++
+
+		if control < 0x80 {
+			count := control + 1
+			if pos+count > len(data) || len(out)+count > n {
+				return nil, fmt.Errorf("RLE literal of %d bytes overruns data", count)
+			}
+			out = append(out, data[pos:pos+count]...)
+			pos += count
+		} else {
+			count := control - 125
+			if pos >= len(data) || len(out)+count > n {
+				return nil, fmt.Errorf("RLE run of %d bytes overruns data", count)
+			}
+			for i := 0; i < count; i++ {
+				out = append(out, data[pos])
+			}
+			pos++
+		}
+	}
+	return out, nil
+}
+
+// packBits appends the RLE encoding of src to dst
+func packBits(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		run := 1
+		for i+run < len(src) && run < maxRun && src[i+run] == src[i] {
+			run++
+		}
+		if run >= minRun {
+			dst = append(dst, byte(run+125), src[i])
+			i += run
+			continue
+		}
+
+		// Collect literals until the next run worth encoding
+		start := i
+		for i < len(src) && i-start < maxLiteral {
+			if i+2 < len(src) && src[i] == src[i+1] && src[i] == src[i+2] {
+				break
+			}
+			i++
+		}
+		dst = append(dst, byte(i-start-1))
+		dst = append(dst, src[start:i]...)
+	}
+	return dst
+}
@@ -0,0 +1,60 @@
+package icns
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestPackBitsRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	noise := make([]byte, 1000)
+	rng.Read(noise)
+
+	inputs := map[string][]byte{
+		"empty":    {},
+		"single":   {7},
+		"pair":     {7, 7},
+		"long run": bytes.Repeat([]byte{9}, 300),
+		"noise":    noise,
+		"mixed":    append(append([]byte{1, 2, 3}, bytes.Repeat([]byte{4}, 5)...), 5, 6, 6),
+	}
+	for name, src := range inputs {
+		packed := packBits(nil, src)
+		unpacked, err := unpackBits(packed, len(src))
+		if err != nil {
+			t.Errorf("%s: failed to unpack: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(unpacked, src) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+}
+
+func TestPackBitsEncoding(t *testing.T) {
+	// Literal 1, 2; run of five 4s; literal 5
+	got := packBits(nil, []byte{1, 2, 4, 4, 4, 4, 4, 5})
+	want := []byte{0x01, 1, 2, 0x82, 4, 0x00, 5}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Got % x, want % x", got, want)
+	}
+
+	if got := packBits(nil, bytes.Repeat([]byte{0}, 130)); !bytes.Equal(got, []byte{0xFF, 0}) {
+		t.Errorf("Expected a single maximal run, got % x", got)
+	}
+}
+
+func TestUnpackBitsErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated":       {0x02, 1, 2, 3},
+		"short literal":   {0x05, 1, 2},
+		"missing run":     {0x80},
+		"run overflows n": {0xFF, 1},
+	}
+	for name, data := range cases {
+		if _, err := unpackBits(data, 8); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 // Package ico provides functionality to decode ICO (Icon) files.
 // ICO files can contain multiple images at different sizes and can store
-// images in either BMP or PNG format.
+// images in either BMP or PNG format. CUR (cursor) files share the same
+// layout and are supported as well.
 package ico
 
 import (
@@ -20,14 +21,20 @@ type Header struct {
 	Count    uint16 // Number of images
 }
 
+// File types stored in Header.Type
+const (
+	TypeICO = 1
+	TypeCUR = 2
+)
+
 // DirectoryEntry represents an entry in the ICO directory
 type DirectoryEntry struct {
 	Width        uint8  // Width in pixels (0 means 256)
 	Height       uint8  // Height in pixels (0 means 256)
 	ColorCount   uint8  // Number of colors in palette (0 means no palette)
 	Reserved     uint8  // Always 0
-	ColorPlanes  uint16 // Color planes (should be 0 or 1)
-	BitsPerPixel uint16 // Bits per pixel
+	ColorPlanes  uint16 // Color planes (should be 0 or 1); hotspot X in CUR files
+	BitsPerPixel uint16 // Bits per pixel; hotspot Y in CUR files
 	Size         uint32 // Size of image data in bytes
 	Offset       uint32 // Offset to image data from beginning of file
 }
@@ -37,6 +44,23 @@ type ICO struct {
 	Header  Header
 	Entries []DirectoryEntry
 	Images  []image.Image
+
+	// Payloads holds the raw image data of each entry as stored in the
+	// decoded file. Encode writes a payload back verbatim as long as its
+	// entry's image is still the one Decode produced from it, with the same
+	// pixels, so editing one entry leaves the bytes of the others untouched.
+	Payloads [][]byte
+
+	// origins remembers which image Decode produced from which payload
+	origins []payloadOrigin
+}
+
+// payloadOrigin pairs a decoded payload with the image decoded from it and
+// a checksum of that image's pixels, to detect changes made in place
+type payloadOrigin struct {
+	data     []byte
+	img      image.Image
+	checksum uint32
 }
 
 // GetWidth returns the actual width, handling the special case where 0 means 256
@@ -55,7 +79,19 @@ func (e DirectoryEntry) GetHeight() int {
 	return int(e.Height)
 }
 
-// Decode decodes an ICO file from the given reader
+// Hotspot returns the cursor hotspot of an entry in a CUR file, which is
+// stored in place of the ColorPlanes and BitsPerPixel fields
+func (e DirectoryEntry) Hotspot() image.Point {
+	return image.Point{X: int(e.ColorPlanes), Y: int(e.BitsPerPixel)}
+}
+
+// SetHotspot stores a cursor hotspot in an entry of a CUR file
+func (e *DirectoryEntry) SetHotspot(p image.Point) {
+	e.ColorPlanes = uint16(p.X)
+	e.BitsPerPixel = uint16(p.Y)
+}
+
+// Decode decodes an ICO or CUR file from the given reader
 func Decode(r io.Reader) (*ICO, error) {
 	// Read all data into memory for easier parsing
 	data, err := io.ReadAll(r)
@@ -78,8 +114,8 @@ func Decode(r io.Reader) (*ICO, error) {
 		return nil, fmt.Errorf("invalid ICO file: reserved field must be 0")
 	}
 
-	if header.Type != 1 {
-		return nil, fmt.Errorf("unsupported file type: %d (only ICO type 1 is supported)", header.Type)
+	if header.Type != TypeICO && header.Type != TypeCUR {
+		return nil, fmt.Errorf("unsupported file type: %d (only ICO type 1 and CUR type 2 are supported)", header.Type)
 	}
 
 	if header.Count == 0 {
@@ -96,27 +132,35 @@ func Decode(r io.Reader) (*ICO, error) {
 
 	// Decode images
 	images := make([]image.Image, header.Count)
+	payloads := make([][]byte, header.Count)
+	origins := make([]payloadOrigin, header.Count)
 	for i, entry := range entries {
 		if entry.Offset >= uint32(len(data)) {
 			return nil, fmt.Errorf("invalid offset for image %d: %d", i, entry.Offset)
 		}
 
-		if entry.Offset+entry.Size > uint32(len(data)) {
+		// Add in 64 bits, as a large size would wrap the end around in 32
+		end := uint64(entry.Offset) + uint64(entry.Size)
+		if end > uint64(len(data)) {
 			return nil, fmt.Errorf("image %d extends beyond file boundary", i)
 		}
 
-		imageData := data[entry.Offset : entry.Offset+entry.Size]
+		imageData := data[entry.Offset:end:end]
 		img, err := decodeImage(imageData, entry)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode image %d: %w", i, err)
 		}
 		images[i] = img
+		payloads[i] = imageData
+		origins[i] = payloadOrigin{data: imageData, img: img, checksum: pixelChecksum(img)}
 	}
 
 	return &ICO{
-		Header:  header,
-		Entries: entries,
-		Images:  images,
+		Header:   header,
+		Entries:  entries,
+		Images:   images,
+		Payloads: payloads,
+		origins:  origins,
 	}, nil
 }
 
@@ -457,7 +501,11 @@ func (ico *ICO) GetBestImage() image.Image {
 	if len(ico.Images) == 0 {
 		return nil
 	}
+	return ico.Images[ico.bestIndex()]
+}
 
+// bestIndex returns the index of the image GetBestImage returns
+func (ico *ICO) bestIndex() int {
 	bestIndex := 0
 	bestSize := ico.Entries[0].GetWidth() * ico.Entries[0].GetHeight()
 
@@ -468,8 +516,7 @@ func (ico *ICO) GetBestImage() image.Image {
 			bestIndex = i
 		}
 	}
-
-	return ico.Images[bestIndex]
+	return bestIndex
 }
 
 // GetImageBySize returns the image that best matches the requested size.
@@ -478,7 +525,11 @@ func (ico *ICO) GetImageBySize(width, height int) image.Image {
 	if len(ico.Images) == 0 {
 		return nil
 	}
+	return ico.Images[ico.indexBySize(width, height)]
+}
 
+// indexBySize returns the index of the image GetImageBySize returns
+func (ico *ICO) indexBySize(width, height int) int {
 	bestIndex := 0
 	bestScore := scoreSizeMatch(ico.Entries[0], width, height)
 
@@ -489,8 +540,7 @@ func (ico *ICO) GetImageBySize(width, height int) image.Image {
 			bestIndex = i
 		}
 	}
-
-	return ico.Images[bestIndex]
+	return bestIndex
 }
 
 // GetAvailableSizes returns a slice of available image sizes in the ICO file.
@@ -536,7 +586,7 @@ func DecodeConfig(r io.Reader) (Config, error) {
 		return Config{}, fmt.Errorf("failed to parse ICO header: %w", err)
 	}
 
-	if header.Reserved != 0 || header.Type != 1 || header.Count == 0 {
+	if header.Reserved != 0 || (header.Type != TypeICO && header.Type != TypeCUR) || header.Count == 0 {
 		return Config{}, fmt.Errorf("invalid ICO file")
 	}
 
@@ -607,4 +657,7 @@ func init() {
 		decode,             // decode function
 		decodeConfig,       // decodeConfig function
 	)
+
+	// CUR files share the ICO layout
+	image.RegisterFormat("cur", "\x00\x00\x02\x00", decode, decodeConfig)
 }
@@ -2,6 +2,7 @@ package ico
 
 import (
 	"bytes"
+	"encoding/binary"
 	"testing"
 )
 
@@ -195,6 +196,15 @@ func TestErrorCases(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for non-zero reserved field")
 	}
+
+	// Test a size that wraps the end of the image around in 32 bits
+	overflow := createMinimalICO()
+	binary.LittleEndian.PutUint32(overflow[6+8:], 0xFFFFFFF0)
+	binary.LittleEndian.PutUint32(overflow[6+12:], 30)
+	_, err = Decode(bytes.NewReader(overflow))
+	if err == nil {
+		t.Error("Expected error for an image extending beyond the file")
+	}
 }
 
 func TestScoreSizeMatch(t *testing.T) {
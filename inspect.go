@@ -0,0 +1,105 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// EntryInfo describes a directory entry and the image header of its
+// payload. Width, Height and BitsPerPixel come from the payload, so they
+// may differ from what the directory entry declares.
+type EntryInfo struct {
+	Index        int            // Position in the directory, from 0
+	Entry        DirectoryEntry // The entry as stored in the directory
+	Format       Format         // FormatPNG if the payload has a PNG signature
+	Width        int            // Width of the payload image
+	Height       int            // Height of the payload image, without the BMP AND mask
+	BitsPerPixel int            // Bits per pixel of the payload image
+
+	// Err is set if the payload lies outside the file or its header could
+	// not be read. Width, Height and BitsPerPixel are then zero.
+	Err error
+}
+
+// Info is the directory of an ICO or CUR file with the header of each
+// payload, as read by Inspect
+type Info struct {
+	Header  Header
+	Entries []EntryInfo
+}
+
+// Inspect reads the directory of an ICO or CUR file and the image header of
+// every payload, without decoding any pixels. Unlike Decode, it only fails
+// if the file header or directory is invalid: a payload that is out of
+// bounds or unreadable is reported in its entry's Err.
+func Inspect(r io.Reader) (*Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICO data: %w", err)
+	}
+	if len(data) < 6 {
+		return nil, fmt.Errorf("ICO file too short: need at least 6 bytes for header")
+	}
+
+	info := &Info{}
+	buf := bytes.NewReader(data)
+	binary.Read(buf, binary.LittleEndian, &info.Header)
+	if info.Header.Reserved != 0 {
+		return nil, fmt.Errorf("invalid ICO file: reserved field must be 0")
+	}
+	if info.Header.Type != TypeICO && info.Header.Type != TypeCUR {
+		return nil, fmt.Errorf("unsupported file type: %d (only ICO type 1 and CUR type 2 are supported)", info.Header.Type)
+	}
+	if info.Header.Count == 0 {
+		return nil, fmt.Errorf("ICO file contains no images")
+	}
+
+	for i := 0; i < int(info.Header.Count); i++ {
+		e := EntryInfo{Index: i}
+		if err := binary.Read(buf, binary.LittleEndian, &e.Entry); err != nil {
+			return nil, fmt.Errorf("failed to read directory entry %d: %w", i, err)
+		}
+
+		if uint64(e.Entry.Offset)+uint64(e.Entry.Size) > uint64(len(data)) {
+			e.Err = fmt.Errorf("image %d extends beyond file boundary", i)
+		} else {
+			e.Format, e.Width, e.Height, e.BitsPerPixel, e.Err = inspectPayload(data[e.Entry.Offset : e.Entry.Offset+e.Entry.Size])
+		}
+		info.Entries = append(info.Entries, e)
+	}
+	return info, nil
+}
+
+// inspectPayload reads the format, dimensions and bit depth of a payload
+// from its PNG IHDR chunk or BMP info header
+func inspectPayload(data []byte) (format Format, width, height, bpp int, err error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		// The IHDR chunk must come first: length, type, width, height, bit
+		// depth and color type
+		if len(data) < 8+8+10 || string(data[12:16]) != "IHDR" {
+			return FormatPNG, 0, 0, 0, fmt.Errorf("PNG data missing IHDR chunk")
+		}
+		ihdr := data[16:]
+		width = int(binary.BigEndian.Uint32(ihdr))
+		height = int(binary.BigEndian.Uint32(ihdr[4:]))
+		channels := map[byte]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[ihdr[9]]
+		if channels == 0 {
+			return FormatPNG, 0, 0, 0, fmt.Errorf("unsupported PNG color type %d", ihdr[9])
+		}
+		return FormatPNG, width, height, int(ihdr[8]) * channels, nil
+	}
+
+	if len(data) < 16 {
+		return FormatBMP, 0, 0, 0, fmt.Errorf("BMP data too short: need at least 16 bytes for header")
+	}
+	if headerSize := binary.LittleEndian.Uint32(data); headerSize < 40 {
+		return FormatBMP, 0, 0, 0, fmt.Errorf("unsupported BMP header size %d", headerSize)
+	}
+	width = int(int32(binary.LittleEndian.Uint32(data[4:])))
+	// The height covers both the XOR image and the AND mask
+	height = int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2
+	bpp = int(binary.LittleEndian.Uint16(data[14:]))
+	return FormatBMP, width, height, bpp, nil
+}
@@ -0,0 +1,90 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	info, err := Inspect(bytes.NewReader(createMixedICO(t)))
+	if err != nil {
+		t.Fatalf("Failed to inspect: %v", err)
+	}
+	if info.Header.Type != TypeICO || len(info.Entries) != 2 {
+		t.Fatalf("Unexpected header %+v with %d entries", info.Header, len(info.Entries))
+	}
+
+	want := []EntryInfo{
+		{Index: 0, Format: FormatBMP, Width: 16, Height: 16, BitsPerPixel: 8},
+		{Index: 1, Format: FormatPNG, Width: 48, Height: 48, BitsPerPixel: 32},
+	}
+	for i, e := range info.Entries {
+		w := want[i]
+		if e.Index != w.Index || e.Format != w.Format || e.Width != w.Width || e.Height != w.Height ||
+			e.BitsPerPixel != w.BitsPerPixel || e.Err != nil {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, w, e)
+		}
+	}
+	if info.Entries[1].Entry.GetWidth() != 48 || info.Entries[1].Entry.Offset == 0 {
+		t.Errorf("Expected directory entry to be kept, got %+v", info.Entries[1].Entry)
+	}
+}
+
+func TestInspectCorruptPayloads(t *testing.T) {
+	bmp, err := encodeBMP(createTestImage(16), 32, &EncodeOptions{})
+	if err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+
+	// The first entry declares 32x32 for a 16x16 payload, the second has a
+	// truncated PNG and the third points beyond the end of the file
+	var buf bytes.Buffer
+	entries := []DirectoryEntry{
+		newDirectoryEntry(image.Rect(0, 0, 32, 32), 32),
+		newDirectoryEntry(image.Rect(0, 0, 16, 16), 32),
+		newDirectoryEntry(image.Rect(0, 0, 16, 16), 32),
+	}
+	payloads := [][]byte{bmp, pngSignature, bmp}
+	if err := writeICO(&buf, Header{Type: TypeICO}, entries, payloads); err != nil {
+		t.Fatalf("Failed to write ICO: %v", err)
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[6+16*2+12:], uint32(len(data)))
+
+	if _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Fatal("Expected Decode to fail")
+	}
+	info, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to inspect: %v", err)
+	}
+	if len(info.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(info.Entries))
+	}
+
+	first := info.Entries[0]
+	if first.Err != nil || first.Entry.GetWidth() != 32 || first.Width != 16 || first.Height != 16 || first.BitsPerPixel != 32 {
+		t.Errorf("Expected declared 32x32 and actual 16x16, got %+v", first)
+	}
+	if e := info.Entries[1]; e.Err == nil || e.Format != FormatPNG {
+		t.Errorf("Expected error for truncated PNG, got %+v", e)
+	}
+	if e := info.Entries[2]; e.Err == nil {
+		t.Errorf("Expected error for out-of-bounds payload, got %+v", e)
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"short":     {0, 0, 1},
+		"type":      {0, 0, 3, 0, 1, 0},
+		"empty":     {0, 0, 1, 0, 0, 0},
+		"directory": {0, 0, 1, 0, 2, 0, 16, 16},
+	} {
+		if _, err := Inspect(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
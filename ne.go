@@ -0,0 +1,125 @@
+package ico
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DecodeNE reads the icons of a 16-bit Windows (NE) executable or .icl icon
+// library. Each RT_GROUP_ICON resource in the NE resource table is
+// reassembled, with the RT_ICON resources it refers to, into an ICO. Groups
+// are returned in resource table order.
+func DecodeNE(r io.Reader) ([]IconGroup, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NE data: %w", err)
+	}
+
+	if len(data) < 0x40 || string(data[:2]) != "MZ" {
+		return nil, fmt.Errorf("invalid NE file: missing MZ header")
+	}
+	neOffset := int(binary.LittleEndian.Uint32(data[0x3C:]))
+	if neOffset < 0 || neOffset > len(data)-0x40 || string(data[neOffset:neOffset+2]) != "NE" {
+		return nil, fmt.Errorf("invalid NE file: missing NE header")
+	}
+
+	resources, err := readNEResources(data, neOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []IconGroup
+	for _, res := range resources {
+		if res.typ != resourceTypeGroupIcon {
+			continue
+		}
+		icon, err := decodeIconGroup(res.data, func(id uint16) []byte {
+			return findIcon(resources, id, 0)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode icon group %s: %w", res.label(), err)
+		}
+		groups = append(groups, IconGroup{Name: res.name, ID: res.id, Icon: icon})
+	}
+
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("NE file contains no icons")
+	}
+	return groups, nil
+}
+
+// readNEResources parses the resource table of the NE executable whose
+// header starts at neOffset. Integer types and IDs are flagged with the
+// high bit; otherwise they are offsets to length-prefixed names within the
+// table. Resources of named types are skipped.
+func readNEResources(data []byte, neOffset int) ([]resource, error) {
+	tableOffset := neOffset + int(binary.LittleEndian.Uint16(data[neOffset+0x24:]))
+	if tableOffset+2 > len(data) {
+		return nil, fmt.Errorf("invalid NE file: resource table beyond end of file")
+	}
+	table := data[tableOffset:]
+	shift := binary.LittleEndian.Uint16(table)
+	if shift > 16 {
+		return nil, fmt.Errorf("invalid NE resource alignment shift %d", shift)
+	}
+
+	var resources []resource
+	off := 2
+	for {
+		if off+2 > len(table) {
+			return nil, fmt.Errorf("NE resource table truncated")
+		}
+		typeID := binary.LittleEndian.Uint16(table[off:])
+		if typeID == 0 {
+			break
+		}
+		if off+8 > len(table) {
+			return nil, fmt.Errorf("NE resource table truncated")
+		}
+		count := int(binary.LittleEndian.Uint16(table[off+2:]))
+		off += 8
+
+		for i := 0; i < count; i++ {
+			if off+12 > len(table) {
+				return nil, fmt.Errorf("NE resource table truncated")
+			}
+			start := int(binary.LittleEndian.Uint16(table[off:])) << shift
+			length := int(binary.LittleEndian.Uint16(table[off+2:])) << shift
+			id := binary.LittleEndian.Uint16(table[off+6:])
+			off += 12
+
+			if typeID&0x8000 == 0 {
+				continue
+			}
+			if start+length > len(data) {
+				// The last resource's padding may run past the end of the file
+				length = len(data) - start
+				if length < 0 {
+					return nil, fmt.Errorf("resource %d of type %d is beyond end of file", id, typeID&0x7FFF)
+				}
+			}
+
+			res := resource{typ: typeID & 0x7FFF, data: data[start : start+length]}
+			if id&0x8000 != 0 {
+				res.id = id & 0x7FFF
+			} else {
+				name, err := readPascalString(table, int(id))
+				if err != nil {
+					return nil, err
+				}
+				res.name = name
+			}
+			resources = append(resources, res)
+		}
+	}
+	return resources, nil
+}
+
+// readPascalString reads a string prefixed with its length in one byte
+func readPascalString(data []byte, off int) (string, error) {
+	if off >= len(data) || off+1+int(data[off]) > len(data) {
+		return "", fmt.Errorf("resource name at offset %d is beyond end of table", off)
+	}
+	return string(data[off+1 : off+1+int(data[off])]), nil
+}
@@ -0,0 +1,159 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// neResourceSpec is a resource for buildNE. A non-empty name is stored as
+// a named resource.
+type neResourceSpec struct {
+	typ  uint16
+	id   uint16
+	name string
+	data []byte
+}
+
+// buildNE assembles a minimal NE executable holding the given resources,
+// with resource data aligned to 16 bytes.
+func buildNE(resources []neResourceSpec) []byte {
+	const neOffset = 0x40
+	const shift = 4
+
+	// Group resources by type, keeping first-seen order
+	var types []uint16
+	byType := make(map[uint16][]neResourceSpec)
+	for _, res := range resources {
+		if _, ok := byType[res.typ]; !ok {
+			types = append(types, res.typ)
+		}
+		byType[res.typ] = append(byType[res.typ], res)
+	}
+
+	tableSize := 2 + 2
+	for _, typ := range types {
+		tableSize += 8 + 12*len(byType[typ])
+	}
+	var names []byte
+	nameOffsets := make(map[string]int)
+	for _, res := range resources {
+		if res.name != "" {
+			nameOffsets[res.name] = tableSize + len(names)
+			names = append(names, byte(len(res.name)))
+			names = append(names, res.name...)
+		}
+	}
+
+	dataStart := (neOffset + 0x40 + tableSize + len(names) + 15) &^ 15
+	var table, body bytes.Buffer
+	binary.Write(&table, binary.LittleEndian, uint16(shift))
+	for _, typ := range types {
+		binary.Write(&table, binary.LittleEndian, []uint16{typ | 0x8000, uint16(len(byType[typ])), 0, 0})
+		for _, res := range byType[typ] {
+			id := res.id | 0x8000
+			if res.name != "" {
+				id = uint16(nameOffsets[res.name])
+			}
+			offset := dataStart + body.Len()
+			length := (len(res.data) + 15) >> shift
+			binary.Write(&table, binary.LittleEndian, []uint16{uint16(offset >> shift), uint16(length), 0x30, id, 0, 0})
+			body.Write(res.data)
+			body.Write(make([]byte, length<<shift-len(res.data)))
+		}
+	}
+	binary.Write(&table, binary.LittleEndian, uint16(0))
+	table.Write(names)
+
+	file := make([]byte, dataStart)
+	copy(file, "MZ")
+	binary.LittleEndian.PutUint32(file[0x3C:], neOffset)
+	copy(file[neOffset:], "NE")
+	binary.LittleEndian.PutUint16(file[neOffset+0x24:], 0x40)
+	copy(file[neOffset+0x40:], table.Bytes())
+	return append(file, body.Bytes()...)
+}
+
+// groupDirectory returns an RT_GROUP_ICON directory for the given icons.
+func groupDirectory(entries []DirectoryEntry, payloads [][]byte, ids []uint16) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, Header{Type: TypeICO, Count: uint16(len(entries))})
+	for i, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, groupIconEntry{
+			Width: e.Width, Height: e.Height, ColorCount: e.ColorCount,
+			ColorPlanes: e.ColorPlanes, BitsPerPixel: e.BitsPerPixel,
+			Size: uint32(len(payloads[i])), ID: ids[i],
+		})
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeNE(t *testing.T) {
+	opts := &EncodeOptions{}
+	opts.setDefaults()
+	icon4, err := encodeBMP(createTestImage(32), 4, opts)
+	if err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+	icon1, err := encodeBMP(createTestImage(16), 1, opts)
+	if err != nil {
+		t.Fatalf("Failed to encode BMP: %v", err)
+	}
+
+	entries := []DirectoryEntry{newDirectoryEntry(createTestImage(32).Rect, 4), newDirectoryEntry(createTestImage(16).Rect, 1)}
+	data := buildNE([]neResourceSpec{
+		{typ: resourceTypeIcon, id: 1, data: icon4},
+		{typ: resourceTypeIcon, id: 2, data: icon1},
+		{typ: resourceTypeGroupIcon, id: 100, data: groupDirectory(entries, [][]byte{icon4, icon1}, []uint16{1, 2})},
+		{typ: resourceTypeGroupIcon, name: "SMALL", data: groupDirectory(entries[1:], [][]byte{icon1}, []uint16{2})},
+		{typ: 6, id: 1, data: []byte("string table")},
+	})
+
+	groups, err := DecodeNE(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	if groups[0].ID != 100 || groups[0].Name != "" || len(groups[0].Icon.Images) != 2 {
+		t.Errorf("Unexpected first group %+v", groups[0])
+	}
+	if groups[1].Name != "SMALL" || len(groups[1].Icon.Images) != 1 {
+		t.Errorf("Unexpected second group %+v", groups[1])
+	}
+
+	icon := groups[0].Icon
+	if icon.Entries[0].BitsPerPixel != 4 || icon.Entries[1].BitsPerPixel != 1 {
+		t.Errorf("Unexpected bit depths %d, %d", icon.Entries[0].BitsPerPixel, icon.Entries[1].BitsPerPixel)
+	}
+	if !bytes.Equal(icon.Payloads[0], icon4) {
+		t.Error("Expected payload trimmed to the size in the group directory")
+	}
+	assertSamePixels(t, "4-bit icon", clearTransparent(createTestImage(32)), icon.Images[0])
+}
+
+func TestDecodeNEErrors(t *testing.T) {
+	mz := make([]byte, 0x80)
+	copy(mz, "MZ")
+	binary.LittleEndian.PutUint32(mz[0x3C:], 0x40)
+	copy(mz[0x40:], "PE")
+
+	missingIcon := buildNE([]neResourceSpec{
+		{typ: resourceTypeGroupIcon, id: 1, data: groupDirectory([]DirectoryEntry{{Width: 16, Height: 16}}, [][]byte{{}}, []uint16{9})},
+	})
+
+	cases := map[string][]byte{
+		"empty":        {},
+		"not MZ":       []byte("hello world"),
+		"not NE":       mz,
+		"no icons":     buildNE([]neResourceSpec{{typ: 6, id: 1, data: []byte("x")}}),
+		"missing icon": missingIcon,
+	}
+	for name, data := range cases {
+		if _, err := DecodeNE(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package ico
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sort"
+)
+
+// OptimizeOptions configures Optimize. The zero value optimizes every entry
+// with no compatibility constraints.
+type OptimizeOptions struct {
+	// KeepDuplicates keeps entries whose pixels exactly match an earlier
+	// entry instead of dropping them.
+	KeepDuplicates bool
+
+	// MinPNGSize restricts PNG to entries at least this many pixels wide or
+	// tall; smaller entries are always stored as BMP, for loaders without
+	// PNG support. Zero allows PNG at any size.
+	MinPNGSize int
+
+	// OmitANDMask leaves out the AND mask of 32-bit BMP entries. See
+	// EncodeOptions.OmitANDMask.
+	OmitANDMask bool
+}
+
+// Optimize re-encodes every entry of ico in its smallest lossless form and
+// returns the resulting ICO file. For each entry it compares 32-bit BMP and
+// PNG with, when the image allows it, 24-bit BMP, 1, 4 or 8-bit BMP with an
+// exact palette, paletted PNG, and the entry's original payload with PNG
+// ancillary chunks stripped, and keeps whichever is smallest. BMP
+// depths below 32 are only considered when alpha is binary, since their
+// transparency comes from the AND mask alone. Entries whose pixels exactly
+// match an earlier entry are dropped unless opts.KeepDuplicates is set.
+//
+// Fully transparent pixels are treated as interchangeable, so their color
+// channels are not preserved.
+func Optimize(ico *ICO, opts OptimizeOptions) ([]byte, error) {
+	if len(ico.Images) == 0 {
+		return nil, fmt.Errorf("ICO contains no images")
+	}
+
+	var entries, sources []DirectoryEntry
+	var payloads [][]byte
+	var kept []*image.NRGBA
+	for i, img := range ico.Images {
+		if err := checkImageSize(img); err != nil {
+			return nil, fmt.Errorf("image %d is %w", i, err)
+		}
+
+		src := clearTransparent(toNRGBA(img))
+		if !opts.KeepDuplicates && containsImage(kept, src) {
+			continue
+		}
+		kept = append(kept, src)
+
+		payload := ico.unchangedPayload(i)
+		var original DirectoryEntry
+		if payload != nil {
+			original = ico.Entries[i]
+		}
+
+		entry, data, err := smallestEncoding(src, payload, original, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode image %d: %w", i, err)
+		}
+		entries = append(entries, entry)
+		payloads = append(payloads, data)
+		if i < len(ico.Entries) {
+			sources = append(sources, ico.Entries[i])
+		} else {
+			sources = append(sources, DirectoryEntry{})
+		}
+	}
+
+	header := ico.Header
+	if header.Type == 0 {
+		header.Type = TypeICO
+	}
+	copyHotspots(header, entries, sources)
+
+	var buf bytes.Buffer
+	if err := writeICO(&buf, header, entries, payloads); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// smallestEncoding tries every lossless encoding of src and returns the
+// smallest, preferring BMP on ties. If the entry's original payload and
+// directory entry are given, the payload is a candidate too, with any PNG
+// ancillary chunks stripped.
+func smallestEncoding(src *image.NRGBA, payload []byte, original DirectoryEntry, opts OptimizeOptions) (DirectoryEntry, []byte, error) {
+	colors, binaryAlpha := colorStats(src, 256)
+
+	// The default median cut quantizer keeps every color when the palette
+	// is large enough, and without dithering the mapping is exact
+	eo := &EncodeOptions{OmitANDMask: opts.OmitANDMask}
+	eo.setDefaults()
+
+	var candidates []EntryEncoding
+	candidates = append(candidates, EntryEncoding{Format: FormatBMP, BitsPerPixel: 32})
+	if binaryAlpha {
+		candidates = append(candidates, EntryEncoding{Format: FormatBMP, BitsPerPixel: 24})
+
+		opaque := len(colors)
+		if _, ok := colors[color.NRGBA{}]; ok {
+			opaque--
+		}
+		for _, bpp := range []int{1, 4, 8} {
+			if colors != nil && opaque <= 1<<bpp {
+				candidates = append(candidates, EntryEncoding{Format: FormatBMP, BitsPerPixel: bpp})
+			}
+		}
+	}
+
+	b := src.Bounds()
+	allowPNG := b.Dx() >= opts.MinPNGSize || b.Dy() >= opts.MinPNGSize
+	if allowPNG {
+		candidates = append(candidates, EntryEncoding{Format: FormatPNG, BitsPerPixel: 32})
+	}
+
+	var bestEntry DirectoryEntry
+	var best []byte
+	for _, enc := range candidates {
+		var data []byte
+		var err error
+		if enc.Format == FormatPNG {
+			data, err = encodePNGLossless(src, nil)
+		} else {
+			data, err = encodeBMP(src, enc.BitsPerPixel, eo)
+		}
+		if err != nil {
+			return DirectoryEntry{}, nil, err
+		}
+		if best == nil || len(data) < len(best) {
+			bestEntry, best = newDirectoryEntry(b, enc.BitsPerPixel), data
+		}
+	}
+
+	if payload != nil {
+		isPNG := bytes.HasPrefix(payload, pngSignature)
+		if isPNG {
+			payload = stripPNGAncillary(payload)
+		}
+		if (allowPNG || !isPNG) && len(payload) < len(best) {
+			bestEntry, best = original, payload
+		}
+	}
+
+	if allowPNG && colors != nil {
+		data, err := encodePNGLossless(src, colors)
+		if err != nil {
+			return DirectoryEntry{}, nil, err
+		}
+		if len(data) < len(best) {
+			bpp := 8
+			if len(colors) <= 2 {
+				bpp = 1
+			} else if len(colors) <= 16 {
+				bpp = 4
+			}
+			bestEntry, best = newDirectoryEntry(b, bpp), data
+		}
+	}
+
+	return bestEntry, best, nil
+}
+
+// encodePNGLossless encodes src as a maximally compressed PNG. If palette
+// holds every color of src, a paletted PNG is written instead of truecolor.
+func encodePNGLossless(src *image.NRGBA, palette map[color.NRGBA]struct{}) ([]byte, error) {
+	var out image.Image = src
+	if palette != nil {
+		out = exactPaletted(src, palette)
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exactPaletted converts src to a paletted image over exactly the given
+// colors, which must include every color of src.
+func exactPaletted(src *image.NRGBA, colors map[color.NRGBA]struct{}) *image.Paletted {
+	sorted := make([]color.NRGBA, 0, len(colors))
+	for c := range colors {
+		sorted = append(sorted, c)
+	}
+	// Transparent colors first keeps the PNG tRNS chunk short
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.A != b.A {
+			return a.A < b.A
+		}
+		return uint32(a.R)<<16|uint32(a.G)<<8|uint32(a.B) < uint32(b.R)<<16|uint32(b.G)<<8|uint32(b.B)
+	})
+
+	palette := make(color.Palette, len(sorted))
+	index := make(map[color.NRGBA]uint8, len(sorted))
+	for i, c := range sorted {
+		palette[i] = c
+		index[c] = uint8(i)
+	}
+
+	dst := image.NewPaletted(src.Rect, palette)
+	for y := 0; y < src.Rect.Dy(); y++ {
+		for x := 0; x < src.Rect.Dx(); x++ {
+			p := src.Pix[y*src.Stride+x*4:]
+			dst.Pix[y*dst.Stride+x] = index[color.NRGBA{p[0], p[1], p[2], p[3]}]
+		}
+	}
+	return dst
+}
+
+// colorStats returns the distinct colors of src, or nil if there are more
+// than limit, and whether every pixel is either fully opaque or fully
+// transparent.
+func colorStats(src *image.NRGBA, limit int) (map[color.NRGBA]struct{}, bool) {
+	colors := make(map[color.NRGBA]struct{})
+	binaryAlpha := true
+	for i := 0; i < len(src.Pix); i += 4 {
+		p := src.Pix[i : i+4]
+		if p[3] != 0 && p[3] != 255 {
+			binaryAlpha = false
+		}
+		if colors != nil {
+			colors[color.NRGBA{p[0], p[1], p[2], p[3]}] = struct{}{}
+			if len(colors) > limit {
+				colors = nil
+			}
+		}
+	}
+	return colors, binaryAlpha
+}
+
+// clearTransparent sets the color channels of fully transparent pixels to
+// zero, so they compare and compress as a single color.
+func clearTransparent(img *image.NRGBA) *image.NRGBA {
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i+3] == 0 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = 0, 0, 0
+		}
+	}
+	return img
+}
+
+// containsImage reports whether images holds an image with the same size
+// and pixels as img.
+func containsImage(images []*image.NRGBA, img *image.NRGBA) bool {
+	for _, other := range images {
+		if other.Rect.Eq(img.Rect) && bytes.Equal(other.Pix, img.Pix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package ico
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// noiseImage returns a size x size image of random colors with varying alpha.
+func noiseImage(size int, seed int64) *image.NRGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	rng.Read(img.Pix)
+	return clearTransparent(img)
+}
+
+// assertSamePixels fails the test if img differs from want at any pixel.
+func assertSamePixels(t *testing.T, label string, want *image.NRGBA, img image.Image) {
+	t.Helper()
+	got := clearTransparent(toNRGBA(img))
+	if !got.Rect.Eq(want.Rect) || !bytes.Equal(got.Pix, want.Pix) {
+		t.Errorf("%s: pixels changed", label)
+	}
+}
+
+func TestOptimizeLossless(t *testing.T) {
+	sources := []*image.NRGBA{
+		createTestImage(16),  // 4 colors, binary alpha
+		createTestImage(256), // 4 colors, binary alpha, large
+		noiseImage(32, 1),    // Many colors, partial alpha
+	}
+	ico := &ICO{}
+	for _, src := range sources {
+		ico.Images = append(ico.Images, src)
+	}
+
+	data, err := Optimize(ico, OptimizeOptions{})
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode optimized ICO: %v", err)
+	}
+	if len(decoded.Images) != len(sources) {
+		t.Fatalf("Expected %d images, got %d", len(sources), len(decoded.Images))
+	}
+	for i, src := range sources {
+		assertSamePixels(t, fmt.Sprintf("image %d", i), src, decoded.Images[i])
+	}
+
+}
+
+func TestOptimizeReducesBMPDepth(t *testing.T) {
+	src := createTestImage(16) // 4 colors, binary alpha
+	ico := &ICO{Images: []image.Image{src}}
+
+	data, err := Optimize(ico, OptimizeOptions{MinPNGSize: 256})
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if bpp := decoded.Entries[0].BitsPerPixel; bpp != 4 {
+		t.Errorf("Expected 4-color image as 4-bit BMP, got %d bpp", bpp)
+	}
+	assertSamePixels(t, "4-bit BMP", src, decoded.Images[0])
+}
+
+func TestOptimizeSmallerThanEncode(t *testing.T) {
+	ico := &ICO{
+		Entries: []DirectoryEntry{{BitsPerPixel: 32}, {BitsPerPixel: 32}},
+		Images:  []image.Image{createTestImage(48), createTestImage(128)},
+	}
+
+	var plain bytes.Buffer
+	if err := Encode(&plain, ico, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	optimized, err := Optimize(ico, OptimizeOptions{})
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+
+	if len(optimized) >= plain.Len() {
+		t.Errorf("Expected optimized file to be smaller than %d bytes, got %d", plain.Len(), len(optimized))
+	}
+}
+
+func TestOptimizeDuplicates(t *testing.T) {
+	ico := &ICO{Images: []image.Image{createTestImage(16), createTestImage(16), createTestImage(32)}}
+
+	for _, keep := range []bool{false, true} {
+		data, err := Optimize(ico, OptimizeOptions{KeepDuplicates: keep})
+		if err != nil {
+			t.Fatalf("Failed to optimize: %v", err)
+		}
+		decoded, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to decode: %v", err)
+		}
+
+		want := 2
+		if keep {
+			want = 3
+		}
+		if len(decoded.Images) != want || int(decoded.Header.Count) != want {
+			t.Errorf("KeepDuplicates=%v: expected %d images, got %d", keep, want, len(decoded.Images))
+		}
+	}
+}
+
+func TestOptimizeMinPNGSize(t *testing.T) {
+	ico := &ICO{Images: []image.Image{noiseImage(32, 2), noiseImage(64, 3)}}
+
+	data, err := Optimize(ico, OptimizeOptions{MinPNGSize: 64})
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if bytes.HasPrefix(data[decoded.Entries[0].Offset:], []byte("\x89PNG")) {
+		t.Error("Expected 32x32 entry to be BMP")
+	}
+	if !bytes.HasPrefix(data[decoded.Entries[1].Offset:], []byte("\x89PNG")) {
+		t.Error("Expected noisy 64x64 entry to be PNG")
+	}
+}
+
+func TestColorStats(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+
+	colors, binary := colorStats(img, 256)
+	if len(colors) != 2 || !binary {
+		t.Errorf("Expected 2 colors with binary alpha, got %d, %v", len(colors), binary)
+	}
+
+	img.SetNRGBA(1, 0, color.NRGBA{R: 255, A: 128})
+	if colors, binary = colorStats(img, 1); colors != nil || binary {
+		t.Errorf("Expected color limit exceeded and partial alpha, got %v, %v", colors, binary)
+	}
+}
@@ -0,0 +1,460 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// PE header constants
+const (
+	peMagic32     = 0x10B // PE32 optional header
+	peMagic64     = 0x20B // PE32+ optional header
+	sectionSize   = 40    // IMAGE_SECTION_HEADER
+	dirResource   = 2     // Index of the resource table in the data directories
+	dirSecurity   = 4     // Index of the certificate table, which holds a file offset
+	scnResource   = 0x40000040
+	maxPESections = 96
+)
+
+// peFile holds the parsed headers of a PE file, with the file offsets of
+// the fields ReplacePEIcon updates
+type peFile struct {
+	data          []byte
+	coffOffset    int // COFF file header, after the "PE\0\0" signature
+	optOffset     int // Optional header
+	dirOffset     int // First data directory
+	dirCount      int
+	sectionOffset int // First section header
+	sections      []peSection
+}
+
+// peSection is a section header
+type peSection struct {
+	virtualSize    uint32
+	virtualAddress uint32
+	rawSize        uint32
+	rawOffset      uint32
+}
+
+// parsePE parses the headers of a PE file
+func parsePE(data []byte) (*peFile, error) {
+	if len(data) < 0x40 || string(data[:2]) != "MZ" {
+		return nil, fmt.Errorf("invalid PE file: missing MZ header")
+	}
+	peOffset := int(binary.LittleEndian.Uint32(data[0x3C:]))
+	if peOffset < 0 || peOffset > len(data)-24 || string(data[peOffset:peOffset+4]) != "PE\x00\x00" {
+		return nil, fmt.Errorf("invalid PE file: missing PE header")
+	}
+
+	pe := &peFile{data: data, coffOffset: peOffset + 4, optOffset: peOffset + 24}
+	sectionCount := int(binary.LittleEndian.Uint16(data[pe.coffOffset+2:]))
+	optSize := int(binary.LittleEndian.Uint16(data[pe.coffOffset+16:]))
+	pe.sectionOffset = pe.optOffset + optSize
+	if sectionCount > maxPESections || pe.sectionOffset+sectionSize*sectionCount > len(data) {
+		return nil, fmt.Errorf("invalid PE file: section table beyond end of file")
+	}
+
+	if optSize < 2 {
+		return nil, fmt.Errorf("invalid PE file: missing optional header")
+	}
+	switch binary.LittleEndian.Uint16(data[pe.optOffset:]) {
+	case peMagic32:
+		pe.dirOffset = pe.optOffset + 96
+	case peMagic64:
+		pe.dirOffset = pe.optOffset + 112
+	default:
+		return nil, fmt.Errorf("unsupported PE optional header magic %#x", binary.LittleEndian.Uint16(data[pe.optOffset:]))
+	}
+	if pe.dirOffset > pe.sectionOffset {
+		return nil, fmt.Errorf("invalid PE file: optional header too short")
+	}
+	pe.dirCount = int(binary.LittleEndian.Uint32(data[pe.dirOffset-4:]))
+	pe.dirCount = min(pe.dirCount, (pe.sectionOffset-pe.dirOffset)/8)
+
+	for i := 0; i < sectionCount; i++ {
+		h := data[pe.sectionOffset+sectionSize*i:]
+		pe.sections = append(pe.sections, peSection{
+			virtualSize:    binary.LittleEndian.Uint32(h[8:]),
+			virtualAddress: binary.LittleEndian.Uint32(h[12:]),
+			rawSize:        binary.LittleEndian.Uint32(h[16:]),
+			rawOffset:      binary.LittleEndian.Uint32(h[20:]),
+		})
+	}
+	return pe, nil
+}
+
+// directory returns the RVA and size of data directory i, or zeros if the
+// file has no such directory
+func (pe *peFile) directory(i int) (uint32, uint32) {
+	if i >= pe.dirCount {
+		return 0, 0
+	}
+	off := pe.dirOffset + 8*i
+	return binary.LittleEndian.Uint32(pe.data[off:]), binary.LittleEndian.Uint32(pe.data[off+4:])
+}
+
+// sectionAt returns the index of the section containing rva, or -1
+func (pe *peFile) sectionAt(rva uint32) int {
+	for i, s := range pe.sections {
+		if rva >= s.virtualAddress && rva-s.virtualAddress < max(s.virtualSize, s.rawSize) {
+			return i
+		}
+	}
+	return -1
+}
+
+// read returns the size bytes of file data at rva, or nil if they are not
+// all backed by the file
+func (pe *peFile) read(rva, size uint32) []byte {
+	i := pe.sectionAt(rva)
+	if i < 0 {
+		return nil
+	}
+	s := pe.sections[i]
+	off := uint64(rva-s.virtualAddress) + uint64(size)
+	if off > uint64(s.rawSize) || uint64(s.rawOffset)+off > uint64(len(pe.data)) {
+		return nil
+	}
+	start := s.rawOffset + rva - s.virtualAddress
+	return pe.data[start : start+size]
+}
+
+// resources reads every resource of the file, and returns the index of the
+// section holding the resource tree, or -1 if there is none
+func (pe *peFile) resources() ([]resource, int, error) {
+	rva, size := pe.directory(dirResource)
+	if rva == 0 || size == 0 {
+		return nil, -1, nil
+	}
+	i := pe.sectionAt(rva)
+	if i < 0 {
+		return nil, -1, fmt.Errorf("resource table at RVA %#x is outside every section", rva)
+	}
+
+	// Read up to the end of the section's file data, which may be padded
+	// beyond its virtual size
+	s := pe.sections[i]
+	end := s.rawSize
+	if s.virtualSize != 0 {
+		end = min(end, s.virtualSize)
+	}
+	var section []byte
+	if rva-s.virtualAddress < end {
+		section = pe.read(rva, end-(rva-s.virtualAddress))
+	}
+	if section == nil {
+		return nil, -1, fmt.Errorf("resource section extends beyond end of file")
+	}
+	resources, err := readResourceTree(section, pe.read)
+	if err != nil {
+		return nil, -1, err
+	}
+	return resources, i, nil
+}
+
+// DecodePE reads the icons of a 32 or 64-bit Windows (PE) executable or DLL.
+// Each RT_GROUP_ICON resource is reassembled, with the RT_ICON resources it
+// lists, into an ICO.
+func DecodePE(r io.Reader) ([]IconGroup, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PE data: %w", err)
+	}
+
+	pe, err := parsePE(data)
+	if err != nil {
+		return nil, err
+	}
+	resources, _, err := pe.resources()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []IconGroup
+	for _, res := range resources {
+		if res.typ != resourceTypeGroupIcon {
+			continue
+		}
+		icon, err := decodeIconGroup(res.data, func(id uint16) []byte {
+			return findIcon(resources, id, res.language)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode icon group %s: %w", res.label(), err)
+		}
+		groups = append(groups, IconGroup{Name: res.name, ID: res.id, Language: res.language, Icon: icon})
+	}
+
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("PE file contains no icons")
+	}
+	return groups, nil
+}
+
+// ReplacePEIcon returns a copy of the PE file data with an icon group
+// replaced by ico. The group is chosen by opts.Name or opts.ID; if opts
+// names no group, the first group, which Explorer shows as the file's
+// icon, is replaced. If the file has no such group, it is added. The
+// RT_ICON resources of the old group are removed unless another group uses
+// them, and the new images get unused IDs, from opts.FirstIconID if set.
+// With a zero opts.Language, every language of the group is replaced and
+// the new group takes the language of the first; otherwise only that
+// language is replaced.
+//
+// The resource section is rebuilt in place if it fits or is the last
+// section of the file, and otherwise written as a new section at the end
+// of the image. The image size, section table and checksum are updated,
+// data appended after the sections is kept, and any Authenticode
+// signature, which the change invalidates, is removed.
+func ReplacePEIcon(data []byte, ico *ICO, opts *ResourceOptions) ([]byte, error) {
+	pe, err := parsePE(data)
+	if err != nil {
+		return nil, err
+	}
+	resources, rsrcIndex, err := pe.resources()
+	if err != nil {
+		return nil, err
+	}
+
+	var o ResourceOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Name == "" && o.ID == 0 {
+		o.ID = 1
+		for _, res := range resources {
+			if res.typ == resourceTypeGroupIcon {
+				o.ID, o.Name = res.id, res.name
+				break
+			}
+		}
+	}
+
+	resources, language := removeIconGroup(resources, o)
+	if o.Language == 0 {
+		o.Language = language
+	}
+
+	firstID := o.FirstIconID
+	if firstID == 0 {
+		firstID = 1
+		for _, res := range resources {
+			if res.typ == resourceTypeIcon && res.name == "" && res.id >= firstID {
+				firstID = res.id + 1
+			}
+		}
+	}
+	dir, icons, err := encodeIconGroup(ico, o.Encode, firstID)
+	if err != nil {
+		return nil, err
+	}
+	for i, icon := range icons {
+		id := firstID + uint16(i)
+		if findIcon(resources, id, o.Language) != nil {
+			return nil, fmt.Errorf("icon ID %d is already in use", id)
+		}
+		resources = append(resources, resource{typ: resourceTypeIcon, id: id, language: o.Language, data: icon})
+	}
+	resources = append(resources, resource{typ: resourceTypeGroupIcon, id: o.ID, name: o.Name, language: o.Language, data: dir})
+
+	return pe.writeResources(resources, rsrcIndex)
+}
+
+// removeIconGroup removes the icon group chosen by o, and the icons only it
+// uses, from resources. It returns the remaining resources and the
+// language of the first group removed.
+func removeIconGroup(resources []resource, o ResourceOptions) ([]resource, uint16) {
+	isTarget := func(res resource) bool {
+		return res.typ == resourceTypeGroupIcon && res.id == o.ID && res.name == o.Name &&
+			(o.Language == 0 || res.language == o.Language)
+	}
+
+	type iconKey struct{ id, language uint16 }
+	removed := make(map[iconKey]bool)
+	kept := make(map[iconKey]bool)
+	language := o.Language
+	found := false
+	for _, res := range resources {
+		if res.typ != resourceTypeGroupIcon {
+			continue
+		}
+		target := isTarget(res)
+		if target && !found {
+			language, found = res.language, true
+		}
+		for _, id := range groupIconIDs(res.data) {
+			if target {
+				removed[iconKey{id, res.language}] = true
+			} else {
+				kept[iconKey{id, res.language}] = true
+			}
+		}
+	}
+
+	var out []resource
+	for _, res := range resources {
+		key := iconKey{res.id, res.language}
+		if isTarget(res) || (res.typ == resourceTypeIcon && res.name == "" && removed[key] && !kept[key]) {
+			continue
+		}
+		out = append(out, res)
+	}
+	return out, language
+}
+
+// groupIconIDs returns the RT_ICON IDs an RT_GROUP_ICON directory lists
+func groupIconIDs(dir []byte) []uint16 {
+	if len(dir) < 6 {
+		return nil
+	}
+	count := int(binary.LittleEndian.Uint16(dir[4:]))
+	var ids []uint16
+	for i := 0; i < count && 6+14*i+14 <= len(dir); i++ {
+		ids = append(ids, binary.LittleEndian.Uint16(dir[6+14*i+12:]))
+	}
+	return ids
+}
+
+// writeResources returns a copy of the file with its resource section
+// replaced by one holding resources
+func (pe *peFile) writeResources(resources []resource, rsrcIndex int) ([]byte, error) {
+	opt := pe.data[pe.optOffset:]
+	sectionAlign := binary.LittleEndian.Uint32(opt[32:])
+	fileAlign := binary.LittleEndian.Uint32(opt[36:])
+	if sectionAlign == 0 || sectionAlign&(sectionAlign-1) != 0 || fileAlign == 0 || fileAlign&(fileAlign-1) != 0 {
+		return nil, fmt.Errorf("invalid PE section alignment %#x or file alignment %#x", sectionAlign, fileAlign)
+	}
+
+	// Everything after the last section's data is overlay, which is kept
+	// except for the signature
+	var rawEnd, imageEnd uint32
+	for _, s := range pe.sections {
+		rawEnd = max(rawEnd, s.rawOffset+s.rawSize)
+		imageEnd = max(imageEnd, s.virtualAddress+align(max(s.virtualSize, s.rawSize), sectionAlign))
+	}
+	if int(rawEnd) > len(pe.data) {
+		return nil, fmt.Errorf("invalid PE file: section data beyond end of file")
+	}
+	overlay := pe.data[rawEnd:]
+	certOffset, certSize := pe.directory(dirSecurity)
+	if certSize > 0 && certOffset >= rawEnd && uint64(certOffset)+uint64(certSize) <= uint64(len(pe.data)) {
+		overlay = append(append([]byte(nil), pe.data[rawEnd:certOffset]...), pe.data[certOffset+certSize:]...)
+	}
+
+	out := append([]byte(nil), pe.data[:rawEnd]...)
+	size := uint32(len(buildResourceSection(resources, 0)))
+	index := rsrcIndex
+	if index < 0 || !pe.fitsInPlace(index, size, sectionAlign, rawEnd, imageEnd) {
+		// Add a section at the end of the image; the old one is left unused
+		index = len(pe.sections)
+		end := pe.sectionOffset + sectionSize*(index+1)
+		if end > int(binary.LittleEndian.Uint32(opt[60:])) || !pe.headerSpaceFree(end) {
+			return nil, fmt.Errorf("no room in the PE headers for a new resource section")
+		}
+		copy(out[pe.sectionOffset+sectionSize*index:], ".rsrc")
+		binary.LittleEndian.PutUint32(out[pe.sectionOffset+sectionSize*index+36:], scnResource)
+		binary.LittleEndian.PutUint16(out[pe.coffOffset+2:], uint16(index+1))
+		pe.sections = append(pe.sections, peSection{virtualAddress: imageEnd, rawOffset: align(rawEnd, fileAlign)})
+	}
+
+	s := &pe.sections[index]
+	oldRawSize := s.rawSize
+	if s.rawOffset+s.rawSize >= rawEnd {
+		// The last section's file data can grow or shrink freely
+		s.rawSize = align(size, fileAlign)
+		if end := int(s.rawOffset + s.rawSize); end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		} else {
+			out = out[:end]
+		}
+	} else {
+		clear(out[s.rawOffset : s.rawOffset+s.rawSize])
+	}
+	copy(out[s.rawOffset:], buildResourceSection(resources, s.virtualAddress))
+	s.virtualSize = size
+	out = append(out, overlay...)
+
+	h := out[pe.sectionOffset+sectionSize*index:]
+	binary.LittleEndian.PutUint32(h[8:], s.virtualSize)
+	binary.LittleEndian.PutUint32(h[12:], s.virtualAddress)
+	binary.LittleEndian.PutUint32(h[16:], s.rawSize)
+	binary.LittleEndian.PutUint32(h[20:], s.rawOffset)
+
+	var sizeOfImage uint32
+	for _, s := range pe.sections {
+		sizeOfImage = max(sizeOfImage, s.virtualAddress+align(max(s.virtualSize, s.rawSize), sectionAlign))
+	}
+	opt = out[pe.optOffset:]
+	binary.LittleEndian.PutUint32(opt[56:], sizeOfImage)
+	binary.LittleEndian.PutUint32(opt[8:], binary.LittleEndian.Uint32(opt[8:])+s.rawSize-oldRawSize)
+
+	pe.data = out
+	pe.setDirectory(dirResource, s.virtualAddress, size)
+	pe.setDirectory(dirSecurity, 0, 0)
+	binary.LittleEndian.PutUint32(out[pe.optOffset+64:], peChecksum(out, pe.optOffset+64))
+	return out, nil
+}
+
+// fitsInPlace reports whether a resource section of size bytes can replace
+// section i: it fits in the section's raw data and address range, or the
+// section is last both in the file and in memory, so it can grow
+func (pe *peFile) fitsInPlace(i int, size, sectionAlign, rawEnd, imageEnd uint32) bool {
+	s := pe.sections[i]
+	rva, _ := pe.directory(dirResource)
+	if rva != s.virtualAddress {
+		return false
+	}
+	if s.rawOffset+s.rawSize >= rawEnd && s.virtualAddress+align(max(s.virtualSize, s.rawSize), sectionAlign) >= imageEnd {
+		return true
+	}
+
+	room := align(max(s.virtualSize, s.rawSize), sectionAlign)
+	for _, other := range pe.sections {
+		if other.virtualAddress > s.virtualAddress {
+			room = min(room, other.virtualAddress-s.virtualAddress)
+		}
+	}
+	return size <= s.rawSize && size <= room
+}
+
+// headerSpaceFree reports whether the headers are zero from the end of the
+// section table up to end, so a section header can be added there
+func (pe *peFile) headerSpaceFree(end int) bool {
+	start := pe.sectionOffset + sectionSize*len(pe.sections)
+	for _, s := range pe.sections {
+		if s.rawSize > 0 && int(s.rawOffset) < end {
+			return false
+		}
+	}
+	return bytes.Count(pe.data[start:end], []byte{0}) == end-start
+}
+
+// setDirectory sets data directory i, if the file has it
+func (pe *peFile) setDirectory(i int, rva, size uint32) {
+	if i >= pe.dirCount {
+		return
+	}
+	off := pe.dirOffset + 8*i
+	binary.LittleEndian.PutUint32(pe.data[off:], rva)
+	binary.LittleEndian.PutUint32(pe.data[off+4:], size)
+}
+
+// peChecksum computes the PE image checksum of data, skipping the checksum
+// field itself: a 16-bit ones' complement sum plus the file length
+func peChecksum(data []byte, checksumOffset int) uint32 {
+	var sum uint64
+	for i := 0; i < len(data); i += 2 {
+		if i == checksumOffset || i == checksumOffset+2 {
+			continue
+		}
+		word := uint64(data[i])
+		if i+1 < len(data) {
+			word |= uint64(data[i+1]) << 8
+		}
+		sum += word
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	return uint32(sum) + uint32(len(data))
+}
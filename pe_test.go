@@ -0,0 +1,364 @@
+package ico
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// peSectionSpec describes a section of a PE file built by buildPE
+type peSectionSpec struct {
+	name string
+	data []byte
+}
+
+// buildPE builds a minimal PE32 or PE32+ image with the given sections. If
+// rsrcIndex is valid, that section holds a resource tree of resources and
+// the resource data directory points to it. overlay is appended after the
+// last section.
+func buildPE(pe64 bool, sections []peSectionSpec, rsrcIndex int, resources []resource, overlay []byte) []byte {
+	const sectionAlign, fileAlign, headerSize = 0x1000, 0x200, 0x400
+	optSize := 224
+	if pe64 {
+		optSize = 240
+	}
+
+	out := make([]byte, headerSize)
+	copy(out, "MZ")
+	binary.LittleEndian.PutUint32(out[0x3C:], 0x40)
+	copy(out[0x40:], "PE\x00\x00")
+	coff := out[0x44:]
+	binary.LittleEndian.PutUint16(coff, 0x14C)
+	if pe64 {
+		binary.LittleEndian.PutUint16(coff, 0x8664)
+	}
+	binary.LittleEndian.PutUint16(coff[2:], uint16(len(sections)))
+	binary.LittleEndian.PutUint16(coff[16:], uint16(optSize))
+	binary.LittleEndian.PutUint16(coff[18:], 0x0102)
+
+	opt := out[0x58:]
+	dirOffset := 96
+	binary.LittleEndian.PutUint16(opt, peMagic32)
+	if pe64 {
+		binary.LittleEndian.PutUint16(opt, peMagic64)
+		dirOffset = 112
+	}
+	binary.LittleEndian.PutUint32(opt[32:], sectionAlign)
+	binary.LittleEndian.PutUint32(opt[36:], fileAlign)
+	binary.LittleEndian.PutUint32(opt[60:], headerSize)
+	binary.LittleEndian.PutUint16(opt[68:], 2) // Windows GUI
+	binary.LittleEndian.PutUint32(opt[dirOffset-4:], 16)
+
+	var body []byte
+	va := uint32(sectionAlign)
+	for i, s := range sections {
+		data := s.data
+		if i == rsrcIndex {
+			data = buildResourceSection(resources, va)
+			binary.LittleEndian.PutUint32(opt[dirOffset+8*dirResource:], va)
+			binary.LittleEndian.PutUint32(opt[dirOffset+8*dirResource+4:], uint32(len(data)))
+		}
+
+		h := out[0x58+optSize+sectionSize*i:]
+		copy(h, s.name)
+		binary.LittleEndian.PutUint32(h[8:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(h[12:], va)
+		binary.LittleEndian.PutUint32(h[16:], align(uint32(len(data)), fileAlign))
+		binary.LittleEndian.PutUint32(h[20:], uint32(headerSize+len(body)))
+		binary.LittleEndian.PutUint32(h[36:], scnResource)
+		body = append(body, data...)
+		body = append(body, make([]byte, int(align(uint32(len(data)), fileAlign))-len(data))...)
+		va += align(uint32(len(data)), sectionAlign)
+	}
+	binary.LittleEndian.PutUint32(opt[56:], va)
+
+	out = append(append(out, body...), overlay...)
+	binary.LittleEndian.PutUint32(out[0x58+64:], peChecksum(out, 0x58+64))
+	return out
+}
+
+// iconGroupResources returns the RT_ICON and RT_GROUP_ICON resources of an
+// icon with images of the given sizes
+func iconGroupResources(t *testing.T, sizes []int, name string, id, firstIconID, language uint16) []resource {
+	t.Helper()
+	icoFile := &ICO{}
+	for _, size := range sizes {
+		icoFile.AddImage(createTestImage(size), nil)
+	}
+	dir, icons, err := encodeIconGroup(icoFile, nil, firstIconID)
+	if err != nil {
+		t.Fatalf("Failed to encode icon group: %v", err)
+	}
+
+	var resources []resource
+	for i, icon := range icons {
+		resources = append(resources, resource{typ: resourceTypeIcon, id: firstIconID + uint16(i), language: language, data: icon})
+	}
+	return append(resources, resource{typ: resourceTypeGroupIcon, name: name, id: id, language: language, data: dir})
+}
+
+// checkPE validates a PE file with debug/pe and checks its checksum and
+// image size, returning its icon groups
+func checkPE(t *testing.T, data []byte) (*pe.File, []IconGroup) {
+	t.Helper()
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid PE file: %v", err)
+	}
+
+	var checksum, sizeOfImage uint32
+	var dirs [16]pe.DataDirectory
+	switch h := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		checksum, sizeOfImage = h.CheckSum, h.SizeOfImage
+		copy(dirs[:], h.DataDirectory[:])
+	case *pe.OptionalHeader64:
+		checksum, sizeOfImage = h.CheckSum, h.SizeOfImage
+		copy(dirs[:], h.DataDirectory[:])
+	}
+	if want := peChecksum(data, 0x58+64); checksum != want {
+		t.Errorf("Expected checksum %#x, got %#x", want, checksum)
+	}
+
+	last := f.Sections[len(f.Sections)-1]
+	if want := align(last.VirtualAddress+max(last.VirtualSize, last.Size), 0x1000); sizeOfImage != want {
+		t.Errorf("Expected image size %#x, got %#x", want, sizeOfImage)
+	}
+
+	rsrc := dirs[dirResource]
+	var found bool
+	for _, s := range f.Sections {
+		if rsrc.VirtualAddress == s.VirtualAddress && rsrc.Size == s.VirtualSize && s.Size >= s.VirtualSize && s.Size%0x200 == 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Resource directory %+v does not match a section", rsrc)
+	}
+
+	groups, err := DecodePE(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	return f, groups
+}
+
+func TestDecodePE(t *testing.T) {
+	resources := iconGroupResources(t, []int{16, 32}, "MAINICON", 0, 1, 0x0409)
+	resources = append(resources, iconGroupResources(t, []int{48}, "", 7, 3, 0x0409)...)
+	resources = append(resources, resource{typ: 16, id: 1, language: 0x0409, data: []byte("version")})
+	data := buildPE(false, []peSectionSpec{{".text", []byte{0xC3}}, {".rsrc", nil}}, 1, resources, nil)
+
+	_, groups := checkPE(t, data)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	// Named groups sort first
+	if groups[0].Name != "MAINICON" || groups[0].Language != 0x0409 || len(groups[0].Icon.Images) != 2 {
+		t.Errorf("Unexpected first group %q with %d images", groups[0].Name, len(groups[0].Icon.Images))
+	}
+	if groups[1].ID != 7 || len(groups[1].Icon.Images) != 1 || groups[1].Icon.Images[0].Bounds().Dx() != 48 {
+		t.Errorf("Unexpected second group %d", groups[1].ID)
+	}
+}
+
+func TestReplacePEIconLastSection(t *testing.T) {
+	resources := iconGroupResources(t, []int{16}, "", 1, 1, 0x0409)
+	resources = append(resources, resource{typ: 16, id: 1, language: 0x0409, codepage: 1252, data: []byte("version")})
+	overlay := []byte("overlay data")
+	data := buildPE(true, []peSectionSpec{{".text", []byte{0xC3}}, {".rsrc", nil}}, 1, resources, overlay)
+	original := append([]byte(nil), data...)
+
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(32), nil)
+	icoFile.AddImage(createTestImage(256), &AddOptions{BitsPerPixel: 32})
+	out, err := ReplacePEIcon(data, icoFile, nil)
+	if err != nil {
+		t.Fatalf("Failed to replace icon: %v", err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Error("Expected input to be left unchanged")
+	}
+
+	f, groups := checkPE(t, out)
+	if len(f.Sections) != 2 {
+		t.Errorf("Expected the last section to grow in place, got %d sections", len(f.Sections))
+	}
+	if f.Sections[1].Size <= 0x200 {
+		t.Errorf("Expected the resource section to grow, got %d bytes", f.Sections[1].Size)
+	}
+	if len(groups) != 1 || groups[0].ID != 1 || groups[0].Language != 0x0409 {
+		t.Fatalf("Unexpected groups %+v", groups)
+	}
+	if sizes := groups[0].Icon.GetAvailableSizes(); len(sizes) != 2 || sizes[1].X != 256 {
+		t.Errorf("Expected sizes 32 and 256, got %v", sizes)
+	}
+	if !bytes.HasSuffix(out, overlay) {
+		t.Error("Expected overlay to be kept")
+	}
+
+	// The old icon is gone and other resources are kept
+	p, _ := parsePE(out)
+	after, _, err := p.resources()
+	if err != nil {
+		t.Fatalf("Failed to read resources: %v", err)
+	}
+	var icons int
+	var version *resource
+	for i, res := range after {
+		switch res.typ {
+		case resourceTypeIcon:
+			icons++
+		case 16:
+			version = &after[i]
+		}
+	}
+	if icons != 2 {
+		t.Errorf("Expected 2 icons, got %d", icons)
+	}
+	if version == nil || string(version.data) != "version" || version.codepage != 1252 {
+		t.Errorf("Expected version resource to be kept, got %+v", version)
+	}
+}
+
+func TestReplacePEIconNewSection(t *testing.T) {
+	// .rsrc is followed by .reloc, so a larger tree needs a new section
+	resources := iconGroupResources(t, []int{16}, "APP", 0, 1, 0)
+	reloc := bytes.Repeat([]byte{0xAB}, 64)
+	data := buildPE(false, []peSectionSpec{{".text", []byte{0xC3}}, {".rsrc", nil}, {".reloc", reloc}}, 1, resources, nil)
+
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(256), &AddOptions{BitsPerPixel: 32})
+	out, err := ReplacePEIcon(data, icoFile, &ResourceOptions{Name: "APP"})
+	if err != nil {
+		t.Fatalf("Failed to replace icon: %v", err)
+	}
+
+	f, groups := checkPE(t, out)
+	if len(f.Sections) != 4 || f.Sections[3].Name != ".rsrc" {
+		t.Fatalf("Expected a new .rsrc section, got %d sections", len(f.Sections))
+	}
+	relocData, err := f.Sections[2].Data()
+	if err != nil || !bytes.Equal(relocData[:len(reloc)], reloc) {
+		t.Error("Expected .reloc data to be kept")
+	}
+	if len(groups) != 1 || groups[0].Name != "APP" || len(groups[0].Icon.Images) != 1 {
+		t.Fatalf("Unexpected groups %+v", groups)
+	}
+
+	// An icon that fits is written in place
+	small := &ICO{}
+	small.AddImage(createTestImage(16), nil)
+	out, err = ReplacePEIcon(data, small, nil)
+	if err != nil {
+		t.Fatalf("Failed to replace icon: %v", err)
+	}
+	if f, _ := checkPE(t, out); len(f.Sections) != 3 {
+		t.Errorf("Expected the section to be rewritten in place, got %d sections", len(f.Sections))
+	}
+
+	// Headers without room for another section header are an error
+	p, _ := parsePE(data)
+	end := p.sectionOffset + sectionSize*len(p.sections)
+	binary.LittleEndian.PutUint32(data[p.optOffset+60:], uint32(end))
+	if _, err := ReplacePEIcon(data, icoFile, nil); err == nil {
+		t.Error("Expected error without room for a new section")
+	}
+}
+
+func TestReplacePEIconAddsGroup(t *testing.T) {
+	data := buildPE(false, []peSectionSpec{{".text", []byte{0xC3}}}, -1, nil, nil)
+
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(32), nil)
+	out, err := ReplacePEIcon(data, icoFile, nil)
+	if err != nil {
+		t.Fatalf("Failed to add icon: %v", err)
+	}
+
+	f, groups := checkPE(t, out)
+	if len(f.Sections) != 2 {
+		t.Errorf("Expected a resource section to be added, got %d sections", len(f.Sections))
+	}
+	if len(groups) != 1 || groups[0].ID != 1 || groups[0].Name != "" {
+		t.Errorf("Expected group 1, got %+v", groups)
+	}
+}
+
+func TestReplacePEIconSharedIcons(t *testing.T) {
+	// Groups 1 and 2 both use icon 1
+	resources := iconGroupResources(t, []int{16}, "", 1, 1, 0)
+	resources = append(resources, resource{typ: resourceTypeGroupIcon, id: 2, data: resources[1].data})
+	data := buildPE(false, []peSectionSpec{{".rsrc", nil}}, 0, resources, nil)
+
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(32), nil)
+	out, err := ReplacePEIcon(data, icoFile, &ResourceOptions{ID: 1})
+	if err != nil {
+		t.Fatalf("Failed to replace icon: %v", err)
+	}
+
+	_, groups := checkPE(t, out)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	if groups[0].Icon.Images[0].Bounds().Dx() != 32 || groups[1].Icon.Images[0].Bounds().Dx() != 16 {
+		t.Error("Expected group 1 to be replaced and group 2 to keep its icon")
+	}
+
+	// An explicit first icon ID must not collide with the kept icon
+	if _, err := ReplacePEIcon(data, icoFile, &ResourceOptions{ID: 1, FirstIconID: 1}); err == nil {
+		t.Error("Expected error for icon ID in use")
+	}
+}
+
+func TestReplacePEIconStripsSignature(t *testing.T) {
+	resources := iconGroupResources(t, []int{16}, "", 1, 1, 0)
+	certificate := []byte("certificate")
+	data := buildPE(false, []peSectionSpec{{".rsrc", nil}}, 0, resources, certificate)
+	p, _ := parsePE(data)
+	p.setDirectory(dirSecurity, uint32(len(data)-len(certificate)), uint32(len(certificate)))
+
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(32), nil)
+	out, err := ReplacePEIcon(data, icoFile, nil)
+	if err != nil {
+		t.Fatalf("Failed to replace icon: %v", err)
+	}
+	checkPE(t, out)
+
+	p, _ = parsePE(out)
+	if offset, size := p.directory(dirSecurity); offset != 0 || size != 0 {
+		t.Errorf("Expected security directory to be cleared, got %#x+%d", offset, size)
+	}
+	if bytes.Contains(out, certificate) {
+		t.Error("Expected certificate to be removed")
+	}
+}
+
+func TestPEChecksum(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x03, 0x04, 0xAA, 0xBB, 0xCC, 0xDD, 0xFF, 0xFF, 0x7F}
+	// 0x0201 + 0x0403 + 0xFFFF + 0x007F, folded, plus the length
+	if sum := peChecksum(data, 4); sum != 0x0683+11 {
+		t.Errorf("Expected checksum %#x, got %#x", 0x0683+11, sum)
+	}
+}
+
+func TestPEErrors(t *testing.T) {
+	if _, err := DecodePE(bytes.NewReader([]byte("not a PE file"))); err == nil {
+		t.Error("Expected error for non-PE data")
+	}
+
+	data := buildPE(false, []peSectionSpec{{".text", []byte{0xC3}}}, -1, nil, nil)
+	if _, err := DecodePE(bytes.NewReader(data)); err == nil {
+		t.Error("Expected error for PE without icons")
+	}
+
+	cursor := &ICO{Header: Header{Type: TypeCUR}}
+	cursor.AddImage(createTestImage(32), &AddOptions{Hotspot: image.Point{1, 1}})
+	if _, err := ReplacePEIcon(data, cursor, nil); err == nil {
+		t.Error("Expected error for cursor")
+	}
+}
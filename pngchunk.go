@@ -0,0 +1,71 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// pngSignature starts every PNG file
+var pngSignature = []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
+
+// pngChunk is a single chunk of a PNG file
+type pngChunk struct {
+	Type   string
+	Offset int    // Offset of the chunk's length field within the file
+	Data   []byte // Chunk data, excluding length, type and CRC
+}
+
+// readPNGChunks splits a PNG file into its chunks, stopping after IEND.
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("missing PNG signature")
+	}
+
+	var chunks []pngChunk
+	offset := len(pngSignature)
+	for offset < len(data) {
+		if offset+12 > len(data) {
+			return chunks, fmt.Errorf("PNG chunk at offset %d truncated", offset)
+		}
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		end := offset + 12 + length
+		if length < 0 || end > len(data) {
+			return chunks, fmt.Errorf("PNG chunk at offset %d extends beyond data", offset)
+		}
+
+		chunk := pngChunk{
+			Type:   string(data[offset+4 : offset+8]),
+			Offset: offset,
+			Data:   data[offset+8 : offset+8+length],
+		}
+		chunks = append(chunks, chunk)
+		offset = end
+
+		if chunk.Type == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// stripPNGAncillary removes every ancillary chunk from a PNG file except
+// tRNS, which carries transparency. Chunks the standard decoder ignores,
+// such as text, timestamps and color profiles, are dropped. Malformed data
+// is returned unchanged.
+func stripPNGAncillary(data []byte) []byte {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return data
+	}
+
+	out := append([]byte(nil), pngSignature...)
+	for _, c := range chunks {
+		critical := c.Type[0] >= 'A' && c.Type[0] <= 'Z'
+		if !critical && c.Type != "tRNS" {
+			continue
+		}
+		out = append(out, data[c.Offset:c.Offset+12+len(c.Data)]...)
+	}
+	return out
+}
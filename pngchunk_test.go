@@ -0,0 +1,68 @@
+package ico
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestReadPNGChunks(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(8)); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	data := addPNGTextChunk(buf.Bytes(), "Title\x00test")
+
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		t.Fatalf("Failed to read chunks: %v", err)
+	}
+
+	var types []string
+	for _, c := range chunks {
+		types = append(types, c.Type)
+	}
+	if len(types) < 4 || types[0] != "IHDR" || types[1] != "tEXt" || types[len(types)-1] != "IEND" {
+		t.Errorf("Unexpected chunk sequence %v", types)
+	}
+
+	if _, err := readPNGChunks(data[:len(data)-4]); err == nil {
+		t.Error("Expected error for truncated PNG")
+	}
+	if _, err := readPNGChunks([]byte("not a png")); err == nil {
+		t.Error("Expected error for missing signature")
+	}
+}
+
+func TestStripPNGAncillary(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(8)); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	plain := buf.Bytes()
+	withText := addPNGTextChunk(plain, "Title\x00test")
+
+	if stripped := stripPNGAncillary(withText); !bytes.Equal(stripped, plain) {
+		t.Errorf("Expected text chunk to be stripped (%d bytes, want %d)", len(stripped), len(plain))
+	}
+
+	garbage := []byte("not a png")
+	if !bytes.Equal(stripPNGAncillary(garbage), garbage) {
+		t.Error("Expected malformed data to be returned unchanged")
+	}
+}
+
+func TestOptimizeStripsOriginalPNG(t *testing.T) {
+	decoded, err := Decode(bytes.NewReader(createMixedICO(t)))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	data, err := Optimize(decoded, OptimizeOptions{})
+	if err != nil {
+		t.Fatalf("Failed to optimize: %v", err)
+	}
+	if bytes.Contains(data, []byte("tEXt")) {
+		t.Error("Expected optimized file to contain no PNG text chunks")
+	}
+}
@@ -0,0 +1,301 @@
+// Package preview renders images as text for display in a terminal, so icons
+// can be viewed over SSH or in a plain console without copying files around.
+// Each character cell shows two vertically stacked pixels using the upper
+// half block character, with the top pixel as the foreground color and the
+// bottom pixel as the background. Transparent pixels are composited over a
+// checkerboard, as in image editors.
+package preview
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/thatoddmailbox/go-ico/resample"
+)
+
+// Mode selects how colors are written to the terminal.
+type Mode int
+
+const (
+	// TrueColor writes 24-bit colors, supported by most modern terminals.
+	TrueColor Mode = iota
+
+	// Color256 maps colors to the xterm 256-color palette.
+	Color256
+
+	// ASCII draws brightness with plain characters and no escape codes, for
+	// terminals without color or for logs. Transparent pixels are blank.
+	ASCII
+)
+
+func (m Mode) String() string {
+	switch m {
+	case TrueColor:
+		return "truecolor"
+	case Color256:
+		return "256"
+	case ASCII:
+		return "ascii"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// ParseMode parses a mode name as returned by Mode.String. "24bit" is
+// accepted for TrueColor.
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "truecolor", "24bit":
+		return TrueColor, nil
+	case "256":
+		return Color256, nil
+	case "ascii":
+		return ASCII, nil
+	}
+	return 0, fmt.Errorf("invalid preview mode: %q (use truecolor, 256 or ascii)", s)
+}
+
+// DetectMode guesses the best mode for the terminal from the COLORTERM,
+// TERM and NO_COLOR environment variables.
+func DetectMode() Mode {
+	return detectMode(os.Getenv)
+}
+
+func detectMode(getenv func(string) string) Mode {
+	if getenv("NO_COLOR") != "" {
+		return ASCII
+	}
+	switch strings.ToLower(getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return TrueColor
+	}
+
+	term := getenv("TERM")
+	switch {
+	case term == "" || term == "dumb":
+		return ASCII
+	case strings.Contains(term, "truecolor") || strings.Contains(term, "direct"):
+		return TrueColor
+	}
+	return Color256
+}
+
+// Options configures Render. The zero value renders in TrueColor at the
+// image's own size over a gray checkerboard of 4-pixel squares.
+type Options struct {
+	Mode Mode
+
+	// Width is the number of columns to scale the image to, keeping its
+	// aspect ratio, or 0 to draw one column per pixel. Images are reduced
+	// with Lanczos filtering and enlarged without smoothing, so pixel art
+	// stays sharp.
+	Width int
+
+	// CheckerSize is the size of the checkerboard squares in pixels of the
+	// rendered image; zero means 4
+	CheckerSize int
+
+	// CheckerLight and CheckerDark are the checkerboard colors; nil means
+	// light and mid gray
+	CheckerLight, CheckerDark color.Color
+}
+
+// Default checkerboard colors
+var (
+	defaultLight = color.NRGBA{0xCC, 0xCC, 0xCC, 0xFF}
+	defaultDark  = color.NRGBA{0x99, 0x99, 0x99, 0xFF}
+)
+
+// asciiRamp holds characters from dark to bright
+const asciiRamp = " .:-=+*#%@"
+
+// Render writes img to w as lines of text, each ending in a newline and,
+// in the color modes, with the terminal colors reset. A nil opts is
+// equivalent to a zero Options. An empty image writes nothing.
+func Render(w io.Writer, img image.Image, opts *Options) error {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.CheckerSize <= 0 {
+		o.CheckerSize = 4
+	}
+	if o.CheckerLight == nil {
+		o.CheckerLight = defaultLight
+	}
+	if o.CheckerDark == nil {
+		o.CheckerDark = defaultDark
+	}
+
+	src := scale(img, o.Width)
+	if src == nil {
+		return nil
+	}
+
+	bw := bufio.NewWriter(w)
+	if o.Mode == ASCII {
+		renderASCII(bw, src)
+	} else {
+		renderBlocks(bw, src, &o)
+	}
+	return bw.Flush()
+}
+
+// scale converts img to an NRGBA image width pixels wide, or at its own
+// size if width is 0. It returns nil for an empty image.
+func scale(img image.Image, width int) *image.NRGBA {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil
+	}
+	if width <= 0 || width == b.Dx() {
+		dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+		return dst
+	}
+
+	height := max(1, (b.Dy()*width+b.Dx()/2)/b.Dx())
+	if width < b.Dx() {
+		return resample.Resize(img, width, height, nil)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := b.Min.Y + y*b.Dy()/height
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*b.Dx()/width, sy))
+		}
+	}
+	return dst
+}
+
+// renderBlocks draws img with half blocks in one of the color modes,
+// writing color codes only when they change
+func renderBlocks(w *bufio.Writer, img *image.NRGBA, o *Options) {
+	light := color.NRGBAModel.Convert(o.CheckerLight).(color.NRGBA)
+	dark := color.NRGBAModel.Convert(o.CheckerDark).(color.NRGBA)
+	pixel := func(x, y int) color.NRGBA {
+		bg := light
+		if (x/o.CheckerSize+y/o.CheckerSize)%2 == 1 {
+			bg = dark
+		}
+		return over(img.NRGBAAt(x, y), bg)
+	}
+
+	b := img.Bounds()
+	for y := 0; y < b.Dy(); y += 2 {
+		var fg, bg string
+		for x := 0; x < b.Dx(); x++ {
+			top := sgr(o.Mode, 38, pixel(x, y))
+			// An odd last row leaves the lower halves in the terminal's own
+			// background color
+			bottom := "49"
+			if y+1 < b.Dy() {
+				bottom = sgr(o.Mode, 48, pixel(x, y+1))
+			}
+
+			if top != fg {
+				fmt.Fprintf(w, "\x1b[%sm", top)
+				fg = top
+			}
+			if bottom != bg {
+				fmt.Fprintf(w, "\x1b[%sm", bottom)
+				bg = bottom
+			}
+			w.WriteString("▀")
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
+
+// renderASCII draws img with one character per two vertically stacked
+// pixels, denser for brighter and more opaque pixels
+func renderASCII(w *bufio.Writer, img *image.NRGBA) {
+	b := img.Bounds()
+	for y := 0; y < b.Dy(); y += 2 {
+		line := make([]byte, 0, b.Dx())
+		for x := 0; x < b.Dx(); x++ {
+			v := brightness(img.NRGBAAt(x, y))
+			if y+1 < b.Dy() {
+				v = (v + brightness(img.NRGBAAt(x, y+1))) / 2
+			}
+			line = append(line, asciiRamp[(v*(len(asciiRamp)-1)+127)/255])
+		}
+		w.WriteString(strings.TrimRight(string(line), " "))
+		w.WriteByte('\n')
+	}
+}
+
+// brightness returns the luma of c weighted by its alpha, from 0 to 255
+func brightness(c color.NRGBA) int {
+	luma := (2126*int(c.R) + 7152*int(c.G) + 722*int(c.B)) / 10000
+	return luma * int(c.A) / 255
+}
+
+// over composites c over an opaque background
+func over(c, bg color.NRGBA) color.NRGBA {
+	a := int(c.A)
+	blend := func(fg, bg uint8) uint8 {
+		return uint8((int(fg)*a + int(bg)*(255-a) + 127) / 255)
+	}
+	return color.NRGBA{blend(c.R, bg.R), blend(c.G, bg.G), blend(c.B, bg.B), 0xFF}
+}
+
+// sgr returns the parameters of the escape sequence setting the foreground
+// (base 38) or background (base 48) to c
+func sgr(mode Mode, base int, c color.NRGBA) string {
+	if mode == Color256 {
+		return strconv.Itoa(base) + ";5;" + strconv.Itoa(Index256(c))
+	}
+	return fmt.Sprintf("%d;2;%d;%d;%d", base, c.R, c.G, c.B)
+}
+
+// cubeLevels are the channel values of the 6x6x6 color cube of the xterm
+// palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// Index256 returns the entry of the xterm 256-color palette closest to c,
+// ignoring alpha: either a color of the 6x6x6 cube (16 to 231) or a gray
+// (232 to 255). The first 16 colors are never used, since terminals
+// customize them.
+func Index256(c color.Color) int {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	r, g, b := int(n.R), int(n.G), int(n.B)
+
+	level := func(v int) int {
+		if v < 48 {
+			return 0
+		}
+		if v < 115 {
+			return 1
+		}
+		return (v - 35) / 40
+	}
+	cr, cg, cb := level(r), level(g), level(b)
+	cube := 16 + 36*cr + 6*cg + cb
+	cubeDist := dist(r, g, b, cubeLevels[cr], cubeLevels[cg], cubeLevels[cb])
+
+	gray := 0
+	if avg := (r + g + b) / 3; avg > 238 {
+		gray = 23
+	} else if avg > 8 {
+		gray = (avg - 3) / 10
+	}
+	v := 8 + 10*gray
+	if dist(r, g, b, v, v, v) < cubeDist {
+		return 232 + gray
+	}
+	return cube
+}
+
+func dist(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
@@ -0,0 +1,157 @@
+package preview
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// testImage returns a width x height image with opaque red pixels on the
+// left half and transparent pixels on the right
+func testImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{0xFF, 0, 0, 0xFF})
+		}
+	}
+	return img
+}
+
+func TestRenderTrueColor(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, testImage(8, 4), nil); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines for 4 rows, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if n := strings.Count(line, "▀"); n != 8 {
+			t.Errorf("Line %d: expected 8 half blocks, got %d", i, n)
+		}
+		if !strings.HasSuffix(line, "\x1b[0m") {
+			t.Errorf("Line %d: expected colors to be reset", i)
+		}
+	}
+
+	// Red is set once per line, then the transparent half shows the dark
+	// checker square, 4 pixels from the light one at the origin
+	first := lines[0]
+	if strings.Count(first, "\x1b[38;2;255;0;0m") != 1 || strings.Count(first, "\x1b[48;2;255;0;0m") != 1 {
+		t.Errorf("Expected red to be set once per line: %q", first)
+	}
+	if !strings.Contains(first, "\x1b[38;2;153;153;153m") {
+		t.Errorf("Expected checkerboard behind transparent pixels: %q", first)
+	}
+}
+
+func TestRenderOddHeight(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, testImage(2, 3), nil); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "\x1b[49m") || strings.Contains(lines[0], "\x1b[49m") {
+		t.Errorf("Expected only the last line to use the default background: %q", lines)
+	}
+}
+
+func TestRender256(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Render(&buf, testImage(4, 2), &Options{Mode: Color256, CheckerDark: color.Black}); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	if !strings.Contains(buf.String(), "\x1b[38;5;196m") || strings.Contains(buf.String(), ";2;") {
+		t.Errorf("Expected 256-color codes only: %q", buf.String())
+	}
+}
+
+func TestRenderASCII(t *testing.T) {
+	img := testImage(4, 2)
+	img.SetNRGBA(0, 0, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF})
+	img.SetNRGBA(0, 1, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF})
+
+	var buf bytes.Buffer
+	if err := Render(&buf, img, &Options{Mode: ASCII}); err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+	// White is brightest, red has the luma of a dark gray and transparent
+	// pixels are trimmed
+	if got := buf.String(); got != "@:\n" {
+		t.Errorf("Expected %q, got %q", "@:\n", got)
+	}
+}
+
+func TestRenderScale(t *testing.T) {
+	for _, tt := range []struct {
+		width, wantLines, wantBlocks int
+	}{
+		{0, 4, 16},
+		{4, 1, 4},   // Reduced
+		{32, 8, 32}, // Enlarged
+	} {
+		var buf bytes.Buffer
+		if err := Render(&buf, testImage(16, 8), &Options{Width: tt.width}); err != nil {
+			t.Fatalf("Failed to render: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != tt.wantLines || strings.Count(lines[0], "▀") != tt.wantBlocks {
+			t.Errorf("Width %d: expected %d lines of %d blocks, got %d lines of %d", tt.width,
+				tt.wantLines, tt.wantBlocks, len(lines), strings.Count(lines[0], "▀"))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 0)), nil); err != nil || buf.Len() != 0 {
+		t.Errorf("Expected empty image to write nothing, got %q, %v", buf.String(), err)
+	}
+}
+
+func TestIndex256(t *testing.T) {
+	for _, tt := range []struct {
+		c    color.Color
+		want int
+	}{
+		{color.NRGBA{0xFF, 0, 0, 0xFF}, 196},
+		{color.White, 231},
+		{color.Black, 16},
+		{color.NRGBA{128, 128, 128, 0xFF}, 244},
+		{color.NRGBA{95, 135, 175, 0xFF}, 16 + 36*1 + 6*2 + 3},
+	} {
+		if got := Index256(tt.c); got != tt.want {
+			t.Errorf("%v: expected %d, got %d", tt.c, tt.want, got)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	for _, mode := range []Mode{TrueColor, Color256, ASCII} {
+		if parsed, err := ParseMode(mode.String()); err != nil || parsed != mode {
+			t.Errorf("%v: round trip gave %v, %v", mode, parsed, err)
+		}
+	}
+	if _, err := ParseMode("16"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+
+	for _, tt := range []struct {
+		env  map[string]string
+		want Mode
+	}{
+		{map[string]string{"COLORTERM": "truecolor", "TERM": "xterm-256color"}, TrueColor},
+		{map[string]string{"TERM": "xterm-256color"}, Color256},
+		{map[string]string{"TERM": "xterm-direct"}, TrueColor},
+		{map[string]string{"TERM": "dumb"}, ASCII},
+		{map[string]string{}, ASCII},
+		{map[string]string{"COLORTERM": "truecolor", "NO_COLOR": "1"}, ASCII},
+	} {
+		if got := detectMode(func(k string) string { return tt.env[k] }); got != tt.want {
+			t.Errorf("%v: expected %v, got %v", tt.env, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package quantize
+
+import "image/color"
+
+// Mono is the two-color black and white palette used by 1-bit icons.
+var Mono = color.Palette{
+	color.NRGBA{0x00, 0x00, 0x00, 0xFF},
+	color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF},
+}
+
+// Windows16 is the standard 16-color VGA palette, in the order Windows uses
+// for 4-bit icons.
+var Windows16 = color.Palette{
+	color.NRGBA{0x00, 0x00, 0x00, 0xFF}, // Black
+	color.NRGBA{0x80, 0x00, 0x00, 0xFF}, // Maroon
+	color.NRGBA{0x00, 0x80, 0x00, 0xFF}, // Green
+	color.NRGBA{0x80, 0x80, 0x00, 0xFF}, // Olive
+	color.NRGBA{0x00, 0x00, 0x80, 0xFF}, // Navy
+	color.NRGBA{0x80, 0x00, 0x80, 0xFF}, // Purple
+	color.NRGBA{0x00, 0x80, 0x80, 0xFF}, // Teal
+	color.NRGBA{0xC0, 0xC0, 0xC0, 0xFF}, // Silver
+	color.NRGBA{0x80, 0x80, 0x80, 0xFF}, // Gray
+	color.NRGBA{0xFF, 0x00, 0x00, 0xFF}, // Red
+	color.NRGBA{0x00, 0xFF, 0x00, 0xFF}, // Lime
+	color.NRGBA{0xFF, 0xFF, 0x00, 0xFF}, // Yellow
+	color.NRGBA{0x00, 0x00, 0xFF, 0xFF}, // Blue
+	color.NRGBA{0xFF, 0x00, 0xFF, 0xFF}, // Fuchsia
+	color.NRGBA{0x00, 0xFF, 0xFF, 0xFF}, // Aqua
+	color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}, // White
+}
+
+// Halftone256 is a 256-color halftone palette in the Windows layout: the
+// first ten static system colors, a 6x6x6 color cube, a 20-step gray ramp,
+// and the last ten static system colors.
+var Halftone256 = func() color.Palette {
+	p := make(color.Palette, 0, 256)
+
+	// First ten static colors
+	for _, c := range [][3]uint8{
+		{0x00, 0x00, 0x00}, {0x80, 0x00, 0x00}, {0x00, 0x80, 0x00}, {0x80, 0x80, 0x00},
+		{0x00, 0x00, 0x80}, {0x80, 0x00, 0x80}, {0x00, 0x80, 0x80}, {0xC0, 0xC0, 0xC0},
+		{0xC0, 0xDC, 0xC0}, {0xA6, 0xCA, 0xF0},
+	} {
+		p = append(p, color.NRGBA{c[0], c[1], c[2], 0xFF})
+	}
+
+	// 6x6x6 color cube
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				p = append(p, color.NRGBA{uint8(r * 51), uint8(g * 51), uint8(b * 51), 0xFF})
+			}
+		}
+	}
+
+	// Gray ramp between the cube's gray levels
+	for i := 1; i <= 20; i++ {
+		v := uint8(i * 255 / 21)
+		p = append(p, color.NRGBA{v, v, v, 0xFF})
+	}
+
+	// Last ten static colors
+	for _, c := range [][3]uint8{
+		{0xFF, 0xFB, 0xF0}, {0xA0, 0xA0, 0xA4}, {0x80, 0x80, 0x80}, {0xFF, 0x00, 0x00},
+		{0x00, 0xFF, 0x00}, {0xFF, 0xFF, 0x00}, {0x00, 0x00, 0xFF}, {0xFF, 0x00, 0xFF},
+		{0x00, 0xFF, 0xFF}, {0xFF, 0xFF, 0xFF},
+	} {
+		p = append(p, color.NRGBA{c[0], c[1], c[2], 0xFF})
+	}
+
+	return p
+}()
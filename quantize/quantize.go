@@ -0,0 +1,323 @@
+// Package quantize reduces truecolor images to small palettes for paletted
+// (1, 4 and 8-bit) icon entries. It provides an adaptive median cut
+// quantizer, the standard Windows palettes, and palette mapping with optional
+// Floyd–Steinberg dithering.
+//
+// Quantizers implement draw.Quantizer. Fully transparent pixels are ignored
+// when building palettes and are left at index 0 when mapping, since icon
+// encoders hide them behind the AND mask anyway.
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// MedianCut is an adaptive quantizer. It repeatedly splits the box of image
+// colors with the most pixels times range along its widest channel, at the
+// pixel-weighted median, and averages each final box into a palette entry.
+// Images with no more distinct colors than requested are reproduced exactly.
+type MedianCut struct{}
+
+var _ draw.Quantizer = MedianCut{}
+
+// Quantize appends up to cap(p)-len(p) colors to p, chosen from the
+// non-transparent pixels of m. If cap(p) is zero, 256 colors are used.
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if cap(p) == 0 {
+		n = 256
+	}
+	if n <= 0 {
+		return p
+	}
+
+	hist := histogram(m)
+	if len(hist) <= n {
+		for _, c := range hist {
+			p = append(p, color.NRGBA{R: c.r, G: c.g, B: c.b, A: 255})
+		}
+		return p
+	}
+
+	boxes := []colorBox{{colors: hist}}
+	for len(boxes) < n {
+		// Split the box where the split buys the most
+		best, bestScore := -1, 0
+		for i, b := range boxes {
+			if len(b.colors) < 2 {
+				continue
+			}
+			_, width := b.widestChannel()
+			if score := width * b.count(); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		lo, hi := boxes[best].split()
+		boxes[best] = lo
+		boxes = append(boxes, hi)
+	}
+
+	for _, b := range boxes {
+		p = append(p, b.average())
+	}
+	return p
+}
+
+// Windows is a fixed quantizer that ignores the image and returns the
+// standard Windows palette for the requested size: Mono for 2 colors,
+// Windows16 for up to 16, and Halftone256 for anything larger. Palettes
+// larger than the remaining capacity are truncated.
+type Windows struct{}
+
+var _ draw.Quantizer = Windows{}
+
+// Quantize appends the standard palette matching cap(p) to p.
+func (Windows) Quantize(p color.Palette, m image.Image) color.Palette {
+	var std color.Palette
+	switch {
+	case cap(p) == 0 || cap(p) > 16:
+		std = Halftone256
+	case cap(p) > 2:
+		std = Windows16
+	default:
+		std = Mono
+	}
+
+	n := cap(p) - len(p)
+	if cap(p) == 0 {
+		n = len(std)
+	}
+	for _, c := range std {
+		if n <= 0 {
+			break
+		}
+		p = append(p, c)
+		n--
+	}
+	return p
+}
+
+// Map converts m to a paletted image using palette p, optionally with
+// Floyd–Steinberg error diffusion. Fully transparent pixels are assigned
+// index 0 and neither receive nor spread diffusion error. Alpha in the
+// remaining pixels is ignored.
+func Map(m image.Image, p color.Palette, dither bool) *image.Paletted {
+	b := m.Bounds()
+	dst := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), p)
+	if len(p) == 0 {
+		return dst
+	}
+
+	nearest := newMatcher(p)
+	w, h := b.Dx(), b.Dy()
+
+	// Error rows for the current and next scanline, padded by one pixel on
+	// each side so the diffusion kernel never needs bounds checks
+	cur := make([][3]int32, w+2)
+	next := make([][3]int32, w+2)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if c.A == 0 {
+				cur[x+1] = [3]int32{}
+				continue
+			}
+
+			want := [3]int32{int32(c.R), int32(c.G), int32(c.B)}
+			if dither {
+				for i := range want {
+					want[i] = clamp(want[i] + cur[x+1][i]/16)
+				}
+			}
+
+			index := nearest.index(want)
+			dst.Pix[y*dst.Stride+x] = uint8(index)
+
+			if !dither {
+				continue
+			}
+
+			got := nearest.palette[index]
+			for i := range want {
+				diff := want[i] - got[i]
+				// 7/16 right, 3/16 down-left, 5/16 down, 1/16 down-right
+				cur[x+2][i] += diff * 7
+				if y+1 < h {
+					next[x][i] += diff * 3
+					next[x+1][i] += diff * 5
+					next[x+2][i] += diff
+				}
+			}
+		}
+		cur, next = next, cur
+		for i := range next {
+			next[i] = [3]int32{}
+		}
+	}
+	return dst
+}
+
+func clamp(v int32) int32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// matcher finds the nearest palette entry by squared RGB distance, caching
+// results since icons tend to reuse a handful of colors.
+type matcher struct {
+	palette [][3]int32
+	cache   map[[3]int32]int
+}
+
+func newMatcher(p color.Palette) *matcher {
+	m := &matcher{cache: make(map[[3]int32]int)}
+	for _, c := range p {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		m.palette = append(m.palette, [3]int32{int32(n.R), int32(n.G), int32(n.B)})
+	}
+	return m
+}
+
+func (m *matcher) index(c [3]int32) int {
+	if i, ok := m.cache[c]; ok {
+		return i
+	}
+
+	best, bestDist := 0, int32(-1)
+	for i, p := range m.palette {
+		dr, dg, db := c[0]-p[0], c[1]-p[1], c[2]-p[2]
+		dist := dr*dr + dg*dg + db*db
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	m.cache[c] = best
+	return best
+}
+
+// weightedColor is a distinct image color with its pixel count.
+type weightedColor struct {
+	r, g, b uint8
+	n       int
+}
+
+// histogram counts the distinct colors of the non-transparent pixels of m,
+// in a deterministic order.
+func histogram(m image.Image) []weightedColor {
+	counts := make(map[[3]uint8]int)
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			counts[[3]uint8{c.R, c.G, c.B}]++
+		}
+	}
+
+	hist := make([]weightedColor, 0, len(counts))
+	for c, n := range counts {
+		hist = append(hist, weightedColor{r: c[0], g: c[1], b: c[2], n: n})
+	}
+	sort.Slice(hist, func(i, j int) bool {
+		a, b := hist[i], hist[j]
+		if a.r != b.r {
+			return a.r < b.r
+		}
+		if a.g != b.g {
+			return a.g < b.g
+		}
+		return a.b < b.b
+	})
+	return hist
+}
+
+// colorBox is a set of histogram colors considered for a single palette entry.
+type colorBox struct {
+	colors []weightedColor
+}
+
+func channel(c weightedColor, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.r
+	case 1:
+		return c.g
+	default:
+		return c.b
+	}
+}
+
+func (b colorBox) count() int {
+	n := 0
+	for _, c := range b.colors {
+		n += c.n
+	}
+	return n
+}
+
+// widestChannel returns the channel with the largest value range and that
+// range.
+func (b colorBox) widestChannel() (int, int) {
+	bestCh, bestWidth := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, c := range b.colors {
+			v := int(channel(c, ch))
+			lo = min(lo, v)
+			hi = max(hi, v)
+		}
+		if hi-lo > bestWidth {
+			bestCh, bestWidth = ch, hi-lo
+		}
+	}
+	return bestCh, bestWidth
+}
+
+// split divides the box at the pixel-weighted median of its widest channel.
+// Both halves are guaranteed to be non-empty.
+func (b colorBox) split() (colorBox, colorBox) {
+	ch, _ := b.widestChannel()
+	colors := append([]weightedColor(nil), b.colors...)
+	sort.SliceStable(colors, func(i, j int) bool {
+		return channel(colors[i], ch) < channel(colors[j], ch)
+	})
+
+	half := b.count() / 2
+	at, seen := 1, colors[0].n
+	for at < len(colors)-1 && seen < half {
+		seen += colors[at].n
+		at++
+	}
+	return colorBox{colors: colors[:at]}, colorBox{colors: colors[at:]}
+}
+
+// average returns the pixel-weighted mean color of the box.
+func (b colorBox) average() color.NRGBA {
+	var r, g, bl, n int
+	for _, c := range b.colors {
+		r += int(c.r) * c.n
+		g += int(c.g) * c.n
+		bl += int(c.b) * c.n
+		n += c.n
+	}
+	return color.NRGBA{
+		R: uint8((r + n/2) / n),
+		G: uint8((g + n/2) / n),
+		B: uint8((bl + n/2) / n),
+		A: 255,
+	}
+}
@@ -0,0 +1,99 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / (w - 1)), G: uint8(y * 255 / (h - 1)), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestMedianCutExactColors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{G: 255, A: 255})
+	img.SetNRGBA(2, 0, color.NRGBA{B: 255}) // Transparent, ignored
+
+	p := MedianCut{}.Quantize(make(color.Palette, 0, 16), img)
+	if len(p) != 2 {
+		t.Fatalf("Expected 2 colors, got %d", len(p))
+	}
+
+	m := Map(img, p, false)
+	for x := 0; x < 2; x++ {
+		if got := p[m.ColorIndexAt(x, 0)]; got != img.NRGBAAt(x, 0) {
+			t.Errorf("Pixel %d: expected %v, got %v", x, img.NRGBAAt(x, 0), got)
+		}
+	}
+}
+
+func TestMedianCutLimit(t *testing.T) {
+	img := gradient(64, 64)
+	for _, n := range []int{2, 16, 256} {
+		p := MedianCut{}.Quantize(make(color.Palette, 0, n), img)
+		if len(p) != n {
+			t.Errorf("Expected %d colors, got %d", n, len(p))
+		}
+	}
+}
+
+func TestWindowsPalettes(t *testing.T) {
+	if len(Mono) != 2 || len(Windows16) != 16 || len(Halftone256) != 256 {
+		t.Fatalf("Unexpected palette sizes: %d, %d, %d", len(Mono), len(Windows16), len(Halftone256))
+	}
+
+	for _, tc := range []struct {
+		n    int
+		want color.Palette
+	}{
+		{2, Mono}, {16, Windows16}, {256, Halftone256},
+	} {
+		p := Windows{}.Quantize(make(color.Palette, 0, tc.n), nil)
+		if len(p) != len(tc.want) || p[len(p)-1] != tc.want[len(tc.want)-1] {
+			t.Errorf("Expected standard %d-color palette, got %d colors", tc.n, len(p))
+		}
+	}
+}
+
+func TestMapDither(t *testing.T) {
+	// A flat mid-gray cannot be represented in black and white; dithering
+	// should produce a roughly even mix where plain mapping picks one color.
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 128, 128, 128, 255
+	}
+
+	countWhite := func(m *image.Paletted) int {
+		n := 0
+		for _, i := range m.Pix {
+			n += int(i)
+		}
+		return n
+	}
+
+	if n := countWhite(Map(img, Mono, false)); n != 0 && n != 256 {
+		t.Errorf("Expected a single color without dithering, got %d white pixels", n)
+	}
+	if n := countWhite(Map(img, Mono, true)); n < 100 || n > 156 {
+		t.Errorf("Expected about half white pixels with dithering, got %d", n)
+	}
+}
+
+func TestMapSkipsTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 255, B: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+
+	m := Map(img, Mono, true)
+	if m.Pix[0] != 0 || m.Pix[1] != 1 {
+		t.Errorf("Expected indices [0 1], got %v", m.Pix)
+	}
+}
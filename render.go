@@ -0,0 +1,102 @@
+package ico
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/thatoddmailbox/go-ico/resample"
+)
+
+// Render returns the icon drawn at size logical pixels on a display with the
+// given scale factor (1.0 for 100%, 1.5 for 150%, and so on). The result is
+// always exactly round(size*scale) pixels square.
+//
+// The source is the entry whose dimensions match the physical size exactly;
+// failing that, the smallest entry larger than it, and failing that, the
+// largest entry available. Sources of a different size are resampled in
+// linear light with a Catmull-Rom filter, and non-square sources are centered
+// with their aspect ratio preserved. Render returns nil if the ICO has no
+// images or the requested size is not positive.
+func (ico *ICO) Render(size int, scale float64) *image.NRGBA {
+	if len(ico.Images) == 0 || size <= 0 || scale <= 0 {
+		return nil
+	}
+
+	px := int(math.Round(float64(size) * scale))
+	if px < 1 {
+		px = 1
+	}
+
+	return scaleToSquare(ico.Images[ico.RenderSource(px)], px, &resample.Options{Filter: resample.CatmullRom})
+}
+
+// RenderSource returns the index of the image Render resamples for a
+// px-pixel square output, so callers can relate the result to that entry,
+// such as to scale a cursor's hotspot. It returns -1 if the ICO has no
+// images. An exact match is found through GetImageBySize; otherwise the
+// smallest entry covering px in both dimensions is preferred over scaling
+// up the largest.
+func (ico *ICO) RenderSource(px int) int {
+	if len(ico.Images) == 0 {
+		return -1
+	}
+	if i := ico.indexBySize(px, px); ico.Images[i].Bounds().Dx() == px && ico.Images[i].Bounds().Dy() == px {
+		return i
+	}
+
+	bestIndex := -1
+	for i, entry := range ico.Entries {
+		if entry.GetWidth() < px || entry.GetHeight() < px {
+			continue
+		}
+		if bestIndex < 0 || entry.GetWidth()*entry.GetHeight() <
+			ico.Entries[bestIndex].GetWidth()*ico.Entries[bestIndex].GetHeight() {
+			bestIndex = i
+		}
+	}
+	if bestIndex >= 0 {
+		return bestIndex
+	}
+	return ico.bestIndex()
+}
+
+// scaleToSquare resamples src to a px-square image. Non-square sources are
+// fitted by their longer side and centered on a transparent background.
+func scaleToSquare(src image.Image, px int, opts *resample.Options) *image.NRGBA {
+	b := src.Bounds()
+	if b.Dx() == px && b.Dy() == px {
+		return toNRGBA(src)
+	}
+
+	// Fit the longer side to px and center the other
+	w, h := px, px
+	if b.Dx() > b.Dy() {
+		h = max(1, int(math.Round(float64(px)*float64(b.Dy())/float64(b.Dx()))))
+	} else if b.Dy() > b.Dx() {
+		w = max(1, int(math.Round(float64(px)*float64(b.Dx())/float64(b.Dy()))))
+	}
+
+	scaled := resample.Resize(src, w, h, opts)
+	if w == px && h == px {
+		return scaled
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, px, px))
+	offset := image.Pt((px-w)/2, (px-h)/2)
+	draw.Draw(dst, scaled.Bounds().Add(offset), scaled, image.Point{}, draw.Src)
+	return dst
+}
+
+// toNRGBA copies img into a new NRGBA image anchored at the origin.
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dst.SetNRGBA(x, y, color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA))
+		}
+	}
+	return dst
+}
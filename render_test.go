@@ -0,0 +1,116 @@
+package ico
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// createMultiSizeICO builds an in-memory ICO with solid-color square images
+// of the given sizes.
+func createMultiSizeICO(sizes ...int) *ICO {
+	ico := &ICO{Header: Header{Type: 1, Count: uint16(len(sizes))}}
+	for _, size := range sizes {
+		img := image.NewNRGBA(image.Rect(0, 0, size, size))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i] = uint8(size)
+			img.Pix[i+3] = 255
+		}
+		ico.Entries = append(ico.Entries, DirectoryEntry{
+			Width:        uint8(size),
+			Height:       uint8(size),
+			ColorPlanes:  1,
+			BitsPerPixel: 32,
+		})
+		ico.Images = append(ico.Images, img)
+	}
+	return ico
+}
+
+func TestRenderExactMatch(t *testing.T) {
+	ico := createMultiSizeICO(16, 32, 48)
+
+	img := ico.Render(32, 1.0)
+	if img == nil {
+		t.Fatal("Render returned nil")
+	}
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 32 {
+		t.Fatalf("Expected 32x32, got %dx%d", b.Dx(), b.Dy())
+	}
+	if got := img.NRGBAAt(0, 0).R; got != 32 {
+		t.Errorf("Expected pixels from the 32x32 entry, got red %d", got)
+	}
+}
+
+func TestRenderPrefersNextLarger(t *testing.T) {
+	ico := createMultiSizeICO(16, 32, 64)
+
+	// 32 logical pixels at 150% is 48 physical pixels
+	img := ico.Render(32, 1.5)
+	if b := img.Bounds(); b.Dx() != 48 || b.Dy() != 48 {
+		t.Fatalf("Expected 48x48, got %dx%d", b.Dx(), b.Dy())
+	}
+	if got := img.NRGBAAt(24, 24); got != (color.NRGBA{R: 64, A: 255}) {
+		t.Errorf("Expected pixels from the 64x64 entry, got %v", got)
+	}
+}
+
+func TestRenderUpscalesLargest(t *testing.T) {
+	ico := createMultiSizeICO(16)
+
+	img := ico.Render(20, 2)
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 40 {
+		t.Fatalf("Expected 40x40, got %dx%d", b.Dx(), b.Dy())
+	}
+	if got := img.NRGBAAt(20, 20); got != (color.NRGBA{R: 16, A: 255}) {
+		t.Errorf("Expected solid color to survive resampling, got %v", got)
+	}
+}
+
+func TestRenderSource(t *testing.T) {
+	ico := createMultiSizeICO(64, 16, 32)
+	for _, tt := range []struct{ px, want int }{
+		{16, 1}, // Exact match
+		{24, 2}, // Smallest larger entry
+		{40, 0},
+		{100, 0}, // Largest entry
+	} {
+		if got := ico.RenderSource(tt.px); got != tt.want {
+			t.Errorf("RenderSource(%d): expected %d, got %d", tt.px, tt.want, got)
+		}
+	}
+	if got := (&ICO{}).RenderSource(16); got != -1 {
+		t.Errorf("Expected -1 for an empty ICO, got %d", got)
+	}
+}
+
+func TestRenderNonSquare(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	for i := 0; i < len(src.Pix); i += 4 {
+		src.Pix[i+3] = 255
+	}
+	ico := &ICO{
+		Entries: []DirectoryEntry{{Width: 32, Height: 16}},
+		Images:  []image.Image{src},
+	}
+
+	img := ico.Render(16, 1)
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+		t.Fatalf("Expected 16x16, got %dx%d", b.Dx(), b.Dy())
+	}
+	if a := img.NRGBAAt(8, 0).A; a != 0 {
+		t.Errorf("Expected transparent letterbox, got alpha %d", a)
+	}
+	if a := img.NRGBAAt(8, 8).A; a != 255 {
+		t.Errorf("Expected opaque center, got alpha %d", a)
+	}
+}
+
+func TestRenderInvalid(t *testing.T) {
+	if (&ICO{}).Render(16, 1) != nil {
+		t.Error("Expected nil for empty ICO")
+	}
+	if createMultiSizeICO(16).Render(0, 1) != nil {
+		t.Error("Expected nil for zero size")
+	}
+}
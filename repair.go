@@ -0,0 +1,278 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+)
+
+// maxOffsetShift is how far from its declared offset Repair looks for the
+// payload of a directory entry
+const maxOffsetShift = 16
+
+// Fix describes one correction made by Repair.
+type Fix struct {
+	Entry       int    // Index of the entry in the original directory, or -1
+	Description string // What was wrong and how it was corrected
+}
+
+func (f Fix) String() string {
+	if f.Entry < 0 {
+		return f.Description
+	}
+	return fmt.Sprintf("entry %d: %s", f.Entry, f.Description)
+}
+
+// foundPayload is an image found by scanning the file
+type foundPayload struct {
+	offset  int
+	data    []byte
+	img     image.Image
+	format  Format
+	width   int
+	height  int
+	bpp     int
+	claimed bool
+}
+
+// Repair recovers the images of an ICO or CUR file whose directory is
+// inconsistent with its payloads. It scans the file for PNG signatures and
+// BMP info headers, keeps those that decode, and rebuilds the directory
+// from them:
+//
+//   - an entry whose offset is off by up to 16 bytes is moved to the payload
+//   - an entry whose offset is wrong is matched to an unclaimed payload of
+//     the size it declares, or dropped if there is none
+//   - each entry's width, height, size and, for BMP icon entries, bit
+//     depth, color count and planes are taken from its payload
+//   - payloads no entry points to get entries of their own
+//
+// The returned ICO keeps each payload's bytes, so Encode writes them back
+// verbatim under the corrected directory. Repair returns one Fix per
+// change, and no fixes for a consistent file. It fails only if the file is
+// too short for a header or holds no image that decodes.
+func Repair(data []byte) (*ICO, []Fix, error) {
+	if len(data) < 6 {
+		return nil, nil, fmt.Errorf("ICO file too short: need at least 6 bytes for header")
+	}
+
+	var header Header
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, &header)
+	var fixes []Fix
+	fix := func(entry int, format string, args ...any) {
+		fixes = append(fixes, Fix{Entry: entry, Description: fmt.Sprintf(format, args...)})
+	}
+
+	if header.Reserved != 0 {
+		fix(-1, "header Reserved: %d -> 0", header.Reserved)
+		header.Reserved = 0
+	}
+	if header.Type != TypeICO && header.Type != TypeCUR {
+		fix(-1, "header Type: %d -> %d (icon)", header.Type, TypeICO)
+		header.Type = TypeICO
+	}
+	cursor := header.Type == TypeCUR
+
+	found := scanPayloads(data)
+	if len(found) == 0 {
+		return nil, nil, fmt.Errorf("no PNG or BMP images found")
+	}
+
+	// The directory ends where the first payload starts, whatever the
+	// header claims
+	count := min(int(header.Count), (found[0].offset-6)/16)
+	entries := make([]DirectoryEntry, count)
+	binary.Read(bytes.NewReader(data[6:]), binary.LittleEndian, entries)
+
+	// Match entries to payloads at or near their offsets, then by size,
+	// noting how each was found
+	matches := make([]*foundPayload, count)
+	notes := make([]string, count)
+	for i, e := range entries {
+		if p := nearestPayload(found, int(e.Offset)); p != nil {
+			p.claimed = true
+			matches[i] = p
+			if p.offset != int(e.Offset) {
+				notes[i] = fmt.Sprintf("Offset: %d -> %d (payload found %d bytes away)", e.Offset, p.offset, abs(p.offset-int(e.Offset)))
+			}
+		}
+	}
+	for i, e := range entries {
+		if matches[i] != nil {
+			continue
+		}
+		for j := range found {
+			if p := &found[j]; !p.claimed && p.width == e.GetWidth() && p.height == e.GetHeight() {
+				p.claimed = true
+				matches[i] = p
+				notes[i] = fmt.Sprintf("Offset: %d -> %d (no payload there, using the unclaimed %dx%d %s payload)",
+					e.Offset, p.offset, p.width, p.height, p.format)
+				break
+			}
+		}
+		if matches[i] == nil {
+			notes[i] = fmt.Sprintf("dropped: no payload at offset %d", e.Offset)
+		}
+	}
+
+	ico := &ICO{Header: header}
+	add := func(e DirectoryEntry, p *foundPayload) {
+		ico.Entries = append(ico.Entries, e)
+		ico.Images = append(ico.Images, p.img)
+		ico.Payloads = append(ico.Payloads, p.data)
+		ico.origins = append(ico.origins, payloadOrigin{data: p.data, img: p.img, checksum: pixelChecksum(p.img)})
+	}
+	for i, e := range entries {
+		if notes[i] != "" {
+			fix(i, "%s", notes[i])
+		}
+		if p := matches[i]; p != nil {
+			add(repairEntry(e, p, cursor, func(format string, args ...any) { fix(i, format, args...) }), p)
+		}
+	}
+	for j := range found {
+		if p := &found[j]; !p.claimed {
+			e := newDirectoryEntry(image.Rect(0, 0, p.width, p.height), p.bpp)
+			if cursor {
+				e.SetHotspot(image.Point{})
+			}
+			fix(-1, "added an entry for the %dx%d %s payload at offset %d", p.width, p.height, p.format, p.offset)
+			add(e, p)
+		}
+	}
+
+	if n := len(ico.Entries); int(header.Count) != n {
+		fix(-1, "header Count: %d -> %d", header.Count, n)
+	}
+	ico.Header.Count = uint16(len(ico.Entries))
+	return ico, fixes, nil
+}
+
+// repairEntry returns e corrected to describe p, reporting each change
+func repairEntry(e DirectoryEntry, p *foundPayload, cursor bool, fix func(format string, args ...any)) DirectoryEntry {
+	want := newDirectoryEntry(image.Rect(0, 0, p.width, p.height), p.bpp)
+	if e.Width != want.Width {
+		fix("Width: %d -> %d", e.GetWidth(), want.GetWidth())
+		e.Width = want.Width
+	}
+	if e.Height != want.Height {
+		fix("Height: %d -> %d", e.GetHeight(), want.GetHeight())
+		e.Height = want.Height
+	}
+	if e.Reserved != 0 {
+		fix("Reserved: %d -> 0", e.Reserved)
+		e.Reserved = 0
+	}
+	if e.Size != uint32(len(p.data)) {
+		fix("Size: %d -> %d", e.Size, len(p.data))
+		e.Size = uint32(len(p.data))
+	}
+
+	// Cursors store their hotspot in place of the planes and bit depth,
+	// and loaders ignore the depth and palette size of PNG entries
+	if cursor || p.format == FormatPNG {
+		return e
+	}
+	if e.ColorCount != want.ColorCount {
+		fix("ColorCount: %d -> %d", e.ColorCount, want.ColorCount)
+		e.ColorCount = want.ColorCount
+	}
+	if e.ColorPlanes > 1 {
+		fix("ColorPlanes: %d -> 1", e.ColorPlanes)
+		e.ColorPlanes = 1
+	}
+	if int(e.BitsPerPixel) != p.bpp {
+		fix("BitsPerPixel: %d -> %d", e.BitsPerPixel, p.bpp)
+		e.BitsPerPixel = uint16(p.bpp)
+	}
+	return e
+}
+
+// nearestPayload returns the unclaimed payload closest to offset, within
+// maxOffsetShift bytes of it
+func nearestPayload(found []foundPayload, offset int) *foundPayload {
+	var best *foundPayload
+	for i := range found {
+		p := &found[i]
+		if d := abs(p.offset - offset); !p.claimed && d <= maxOffsetShift && (best == nil || d < abs(best.offset-offset)) {
+			best = p
+		}
+	}
+	return best
+}
+
+// scanPayloads finds the images stored in data, in file order. A match
+// that decodes is skipped over, so data within a payload is never mistaken
+// for another.
+func scanPayloads(data []byte) []foundPayload {
+	var found []foundPayload
+	for offset := 6; offset < len(data); {
+		size := payloadLength(data[offset:])
+		if size == 0 {
+			offset++
+			continue
+		}
+		payload := data[offset : offset+size : offset+size]
+		format, width, height, bpp, err := inspectPayload(payload)
+		var img image.Image
+		if err == nil {
+			img, err = decodeImage(payload, DirectoryEntry{})
+		}
+		if err != nil {
+			offset++
+			continue
+		}
+		found = append(found, foundPayload{offset: offset, data: payload, img: img,
+			format: format, width: width, height: height, bpp: bpp})
+		offset += size
+	}
+	return found
+}
+
+// payloadLength returns the length of the PNG image or BMP icon image at
+// the start of data, or 0 if data does not start with one. PNG images end
+// with their IEND chunk. A BMP image's length follows from its info
+// header. It may be cut short at the end of data within the AND mask,
+// which Decode tolerates missing, but not within the info header, palette
+// or XOR bitmap.
+func payloadLength(data []byte) int {
+	if bytes.HasPrefix(data, pngSignature) {
+		offset := len(pngSignature)
+		for offset+12 <= len(data) {
+			length := int(binary.BigEndian.Uint32(data[offset:]))
+			end := offset + 12 + length
+			if length < 0 || end > len(data) || end < offset {
+				return 0
+			}
+			if string(data[offset+4:offset+8]) == "IEND" {
+				return end
+			}
+			offset = end
+		}
+		return 0
+	}
+
+	// A plausible BITMAPINFOHEADER of an uncompressed icon image
+	if len(data) < 40 {
+		return 0
+	}
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(data[offset:]) }
+	headerSize := u32(0)
+	width, height := int32(u32(4)), int32(u32(8))
+	planes, bpp := binary.LittleEndian.Uint16(data[12:]), int(binary.LittleEndian.Uint16(data[14:]))
+	if (headerSize != 40 && headerSize != 108 && headerSize != 124) || width < 1 || width > 256 ||
+		height < 2 || height%2 != 0 || height/2 > 256 || planes != 1 || !validBMPDepth(bpp) || u32(16) != 0 {
+		return 0
+	}
+
+	w, h := int(width), int(height/2)
+	xorEnd := int(headerSize) + (w*bpp+31)/32*4*h
+	if bpp <= 8 {
+		xorEnd += 4 << bpp
+	}
+	if xorEnd > len(data) {
+		return 0
+	}
+	return min(xorEnd+(w+31)/32*4*h, len(data))
+}
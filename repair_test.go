@@ -0,0 +1,152 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fixStrings formats fixes for comparison
+func fixStrings(fixes []Fix) []string {
+	var s []string
+	for _, f := range fixes {
+		s = append(s, f.String())
+	}
+	return s
+}
+
+func TestRepairConsistent(t *testing.T) {
+	data := createMixedICO(t)
+	repaired, fixes, err := Repair(data)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if len(fixes) != 0 {
+		t.Errorf("Expected no fixes, got %q", fixStrings(fixes))
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, repaired, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Expected a consistent file to be written back byte for byte")
+	}
+}
+
+func TestRepair(t *testing.T) {
+	original := createMixedICO(t)
+	decoded, err := Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	// Entry 0 declares the wrong size and depth, and entry 1 points 3
+	// bytes past its payload and claims 100 bytes too many
+	data := slices.Clone(original)
+	entry := func(i int) []byte { return data[6+16*i:] }
+	entry(0)[0], entry(0)[6] = 32, 4
+	binary.LittleEndian.PutUint32(entry(1)[8:], decoded.Entries[1].Size+100)
+	binary.LittleEndian.PutUint32(entry(1)[12:], decoded.Entries[1].Offset+3)
+
+	repaired, fixes, err := Repair(data)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	want := []string{
+		"entry 0: Width: 32 -> 16",
+		"entry 0: BitsPerPixel: 4 -> 8",
+		fmt.Sprintf("entry 1: Offset: %d -> %d (payload found 3 bytes away)", decoded.Entries[1].Offset+3, decoded.Entries[1].Offset),
+		fmt.Sprintf("entry 1: Size: %d -> %d", decoded.Entries[1].Size+100, decoded.Entries[1].Size),
+	}
+	if got := fixStrings(fixes); !slices.Equal(got, want) {
+		t.Errorf("Expected fixes %q, got %q", want, got)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, repaired, nil); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), original) {
+		t.Error("Expected the repaired file to match the original")
+	}
+}
+
+func TestRepairDirectory(t *testing.T) {
+	original := createMixedICO(t)
+
+	// The header counts only one entry, so the PNG payload is not in the
+	// directory. Entry 0 points nowhere near its payload.
+	data := slices.Clone(original)
+	data[4] = 1
+	binary.LittleEndian.PutUint32(data[6+12:], 5000)
+	data[0] = 7
+
+	repaired, fixes, err := Repair(data)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	got := strings.Join(fixStrings(fixes), "\n")
+	for _, want := range []string{
+		"header Reserved: 7 -> 0",
+		"entry 0: Offset: 5000 -> 38 (no payload there, using the unclaimed 16x16 bmp payload)",
+		"added an entry for the 48x48 png payload at offset 1422",
+		"header Count: 1 -> 2",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected fix %q, got:\n%s", want, got)
+		}
+	}
+	if len(repaired.Images) != 2 || repaired.Header.Count != 2 || repaired.Header.Reserved != 0 {
+		t.Fatalf("Expected two images, got %d with header %+v", len(repaired.Images), repaired.Header)
+	}
+	if b := repaired.Images[1].Bounds(); b.Dx() != 48 {
+		t.Errorf("Expected the added entry to be 48 pixels wide, got %d", b.Dx())
+	}
+}
+
+func TestRepairDropsEntries(t *testing.T) {
+	// An entry of a size with no payload is dropped
+	data := slices.Clone(createMixedICO(t))
+	binary.LittleEndian.PutUint32(data[6+16+12:], 9000)
+	data[6+16] = 64
+	repaired, fixes, err := Repair(data)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	got := fixStrings(fixes)
+	if !slices.Contains(got, "entry 1: dropped: no payload at offset 9000") ||
+		!slices.Contains(got, "added an entry for the 48x48 png payload at offset 1422") {
+		t.Errorf("Expected entry 1 to be dropped and its payload re-added, got %q", got)
+	}
+	if len(repaired.Images) != 2 {
+		t.Errorf("Expected 2 images, got %d", len(repaired.Images))
+	}
+
+	if _, _, err := Repair(make([]byte, 64)); err == nil {
+		t.Error("Expected an error for a file without images")
+	}
+	if _, _, err := Repair([]byte{0, 0}); err == nil {
+		t.Error("Expected an error for a short file")
+	}
+}
+
+func TestRepairTruncatedBMP(t *testing.T) {
+	// A 1x2, 32-bit BMP whose 124-byte info header runs past the end of
+	// the 56-byte file
+	data := []byte{0, 0, 1, 0, 0, 0}
+	bmp := make([]byte, 50)
+	binary.LittleEndian.PutUint32(bmp, 124)
+	binary.LittleEndian.PutUint32(bmp[4:], 1)
+	binary.LittleEndian.PutUint32(bmp[8:], 4)
+	binary.LittleEndian.PutUint16(bmp[12:], 1)
+	binary.LittleEndian.PutUint16(bmp[14:], 32)
+	data = append(data, bmp...)
+
+	if _, _, err := Repair(data); err == nil {
+		t.Error("Expected an error for a file whose only image is truncated")
+	}
+}
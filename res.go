@@ -0,0 +1,236 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// Memory flags the resource compiler gives icon resources
+const (
+	iconMemoryFlags      = 0x1010 // MOVEABLE | DISCARDABLE
+	groupIconMemoryFlags = 0x1030 // MOVEABLE | PURE | DISCARDABLE
+)
+
+// ResourceOptions configures EncodeRES. A nil *ResourceOptions writes the
+// icon as group 1 with icons numbered from 1, in the neutral language.
+type ResourceOptions struct {
+	// Name is the name of the RT_GROUP_ICON resource, as referenced from
+	// code or an .rc file. If empty, the group is identified by ID.
+	Name string
+
+	// ID is the numeric ID of the RT_GROUP_ICON resource when Name is
+	// empty. Zero means 1.
+	ID uint16
+
+	// FirstIconID is the ID of the first RT_ICON resource; the others
+	// follow consecutively. Zero means 1.
+	FirstIconID uint16
+
+	// Language is the language ID of every resource, such as 0x0409 for
+	// US English. Zero is LANG_NEUTRAL.
+	Language uint16
+
+	// Encode configures how images are encoded. Nil uses Encode's defaults.
+	Encode *EncodeOptions
+}
+
+// EncodeRES writes ico as a 32-bit Windows resource (.res) file holding one
+// RT_ICON resource per image and an RT_GROUP_ICON directory listing them,
+// ready to be linked into an executable. Cursors are not supported.
+func EncodeRES(w io.Writer, ico *ICO, opts *ResourceOptions) error {
+	var o ResourceOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.ID == 0 {
+		o.ID = 1
+	}
+	if o.FirstIconID == 0 {
+		o.FirstIconID = 1
+	}
+
+	dir, icons, err := encodeIconGroup(ico, o.Encode, o.FirstIconID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	// An empty resource marks the file as 32-bit
+	writeResource(&buf, resource{})
+	for i, data := range icons {
+		writeResource(&buf, resource{
+			typ:      resourceTypeIcon,
+			id:       o.FirstIconID + uint16(i),
+			language: o.Language,
+			flags:    iconMemoryFlags,
+			data:     data,
+		})
+	}
+	writeResource(&buf, resource{
+		typ:      resourceTypeGroupIcon,
+		id:       o.ID,
+		name:     o.Name,
+		language: o.Language,
+		flags:    groupIconMemoryFlags,
+		data:     dir,
+	})
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write resource file: %w", err)
+	}
+	return nil
+}
+
+// writeResource appends a resource header and its data, each padded to a
+// 4-byte boundary
+func writeResource(buf *bytes.Buffer, res resource) {
+	var names bytes.Buffer
+	writeResourceID(&names, res.typ, res.typeName)
+	writeResourceID(&names, res.id, res.name)
+	for names.Len()%4 != 0 {
+		names.WriteByte(0)
+	}
+
+	headerSize := 8 + names.Len() + 16
+	binary.Write(buf, binary.LittleEndian, []uint32{uint32(len(res.data)), uint32(headerSize)})
+	buf.Write(names.Bytes())
+	binary.Write(buf, binary.LittleEndian, uint32(0)) // DataVersion
+	binary.Write(buf, binary.LittleEndian, []uint16{res.flags, res.language})
+	binary.Write(buf, binary.LittleEndian, []uint32{0, 0}) // Version, Characteristics
+	buf.Write(res.data)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// writeResourceID writes a resource type or name: a null-terminated UTF-16
+// string, or 0xFFFF followed by the numeric ID
+func writeResourceID(buf *bytes.Buffer, id uint16, name string) {
+	if name == "" {
+		binary.Write(buf, binary.LittleEndian, []uint16{0xFFFF, id})
+		return
+	}
+	binary.Write(buf, binary.LittleEndian, append(utf16.Encode([]rune(name)), 0))
+}
+
+// DecodeRES reads the icons of a 32-bit Windows resource (.res) file. Each
+// RT_GROUP_ICON resource is reassembled, with the RT_ICON resources it
+// lists, into an ICO. Icons in the group's language are preferred when
+// several languages share an ID.
+func DecodeRES(r io.Reader) ([]IconGroup, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource data: %w", err)
+	}
+
+	resources, err := readResources(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []IconGroup
+	for _, res := range resources {
+		if res.typ != resourceTypeGroupIcon {
+			continue
+		}
+		icon, err := decodeIconGroup(res.data, func(id uint16) []byte {
+			return findIcon(resources, id, res.language)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode icon group %s: %w", res.label(), err)
+		}
+		groups = append(groups, IconGroup{Name: res.name, ID: res.id, Language: res.language, Icon: icon})
+	}
+
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("resource file contains no icons")
+	}
+	return groups, nil
+}
+
+// findIcon returns the data of the RT_ICON resource with the given ID,
+// preferring the given language, or nil
+func findIcon(resources []resource, id, language uint16) []byte {
+	var found []byte
+	for _, res := range resources {
+		if res.typ != resourceTypeIcon || res.name != "" || res.id != id {
+			continue
+		}
+		if res.language == language {
+			return res.data
+		}
+		if found == nil {
+			found = res.data
+		}
+	}
+	return found
+}
+
+// readResources parses every resource of a 32-bit .res file
+func readResources(data []byte) ([]resource, error) {
+	if len(data) < 32 || binary.LittleEndian.Uint32(data) != 0 || binary.LittleEndian.Uint32(data[4:]) != 32 {
+		return nil, fmt.Errorf("invalid resource file: missing 32-bit resource header")
+	}
+
+	var resources []resource
+	for off := 0; off < len(data); {
+		if len(data)-off < 8 {
+			return nil, fmt.Errorf("resource header truncated at offset %d", off)
+		}
+		dataSize := int(binary.LittleEndian.Uint32(data[off:]))
+		headerSize := int(binary.LittleEndian.Uint32(data[off+4:]))
+		if headerSize < 24 || headerSize > len(data)-off || dataSize > len(data)-off-headerSize {
+			return nil, fmt.Errorf("resource at offset %d extends beyond end of file", off)
+		}
+		header := data[off : off+headerSize]
+
+		var res resource
+		pos := 8
+		var err error
+		if res.typ, res.typeName, pos, err = readResourceID(header, pos); err != nil {
+			return nil, err
+		}
+		if res.id, res.name, pos, err = readResourceID(header, pos); err != nil {
+			return nil, err
+		}
+		pos = (pos + 3) &^ 3
+		if pos+16 > headerSize {
+			return nil, fmt.Errorf("resource header at offset %d truncated", off)
+		}
+		res.flags = binary.LittleEndian.Uint16(header[pos+4:])
+		res.language = binary.LittleEndian.Uint16(header[pos+6:])
+		res.data = data[off+headerSize : off+headerSize+dataSize]
+
+		if res.typeName != "" || res.typ != 0 {
+			resources = append(resources, res)
+		}
+		off = (off + headerSize + dataSize + 3) &^ 3
+	}
+	return resources, nil
+}
+
+// readResourceID reads a resource type or name written by writeResourceID,
+// returning the position after it
+func readResourceID(header []byte, pos int) (uint16, string, int, error) {
+	if pos+4 > len(header) {
+		return 0, "", 0, fmt.Errorf("resource header truncated")
+	}
+	if binary.LittleEndian.Uint16(header[pos:]) == 0xFFFF {
+		return binary.LittleEndian.Uint16(header[pos+2:]), "", pos + 4, nil
+	}
+
+	var units []uint16
+	for ; ; pos += 2 {
+		if pos+2 > len(header) {
+			return 0, "", 0, fmt.Errorf("resource name not terminated")
+		}
+		u := binary.LittleEndian.Uint16(header[pos:])
+		if u == 0 {
+			return 0, string(utf16.Decode(units)), pos + 2, nil
+		}
+		units = append(units, u)
+	}
+}
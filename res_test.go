@@ -0,0 +1,132 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestEncodeRES(t *testing.T) {
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(16), &AddOptions{BitsPerPixel: 8})
+	icoFile.AddImage(createTestImage(256), nil)
+
+	var buf bytes.Buffer
+	err := EncodeRES(&buf, icoFile, &ResourceOptions{Name: "APPICON", FirstIconID: 10, Language: 0x0409})
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	data := buf.Bytes()
+
+	// The file starts with the empty 32-bit marker resource
+	marker := []byte{0, 0, 0, 0, 32, 0, 0, 0, 0xFF, 0xFF, 0, 0, 0xFF, 0xFF, 0, 0}
+	if !bytes.Equal(data[:16], marker) || len(data)%4 != 0 {
+		t.Errorf("Unexpected file start % x", data[:16])
+	}
+
+	resources, err := readResources(data)
+	if err != nil {
+		t.Fatalf("Failed to read resources: %v", err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("Expected 2 icons and a group, got %d resources", len(resources))
+	}
+	for i, res := range resources[:2] {
+		if res.typ != resourceTypeIcon || res.id != uint16(10+i) || res.language != 0x0409 || res.flags != iconMemoryFlags {
+			t.Errorf("Unexpected icon resource %+v", res)
+		}
+	}
+	group := resources[2]
+	if group.typ != resourceTypeGroupIcon || group.name != "APPICON" || len(group.data) != 6+2*14 {
+		t.Errorf("Unexpected group resource type %d name %q with %d bytes", group.typ, group.name, len(group.data))
+	}
+
+	// The name is stored as null-terminated UTF-16 after the type
+	name := utf16.Encode([]rune("APPICON\x00"))
+	nameBytes := make([]byte, 2*len(name))
+	for i, u := range name {
+		binary.LittleEndian.PutUint16(nameBytes[2*i:], u)
+	}
+	if !bytes.Contains(data, nameBytes) {
+		t.Error("Expected UTF-16 group name in file")
+	}
+}
+
+func TestRESRoundTrip(t *testing.T) {
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(32), &AddOptions{BitsPerPixel: 4})
+	icoFile.AddImage(createTestImage(48), nil)
+
+	var buf bytes.Buffer
+	if err := EncodeRES(&buf, icoFile, &ResourceOptions{ID: 5}); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	groups, err := DecodeRES(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if len(groups) != 1 || groups[0].ID != 5 || groups[0].Name != "" || groups[0].Language != 0 {
+		t.Fatalf("Unexpected groups %+v", groups)
+	}
+
+	icon := groups[0].Icon
+	if len(icon.Images) != 2 || icon.Entries[0].BitsPerPixel != 4 || icon.Entries[1].GetWidth() != 48 {
+		t.Fatalf("Unexpected icon entries %+v", icon.Entries)
+	}
+	assertSamePixels(t, "32-bit icon", clearTransparent(createTestImage(48)), icon.Images[1])
+}
+
+func TestDecodeRESLanguages(t *testing.T) {
+	english := &ICO{}
+	english.AddImage(createTestImage(16), nil)
+	german := &ICO{}
+	german.AddImage(createTestImage(32), nil)
+
+	var en, de bytes.Buffer
+	EncodeRES(&en, english, &ResourceOptions{Language: 0x0409})
+	EncodeRES(&de, german, &ResourceOptions{Language: 0x0407})
+
+	// Concatenate, skipping the second file's marker resource
+	data := append(en.Bytes(), de.Bytes()[32:]...)
+	groups, err := DecodeRES(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	if groups[0].Icon.Entries[0].GetWidth() != 16 || groups[1].Icon.Entries[0].GetWidth() != 32 {
+		t.Error("Expected each group to use the icon of its own language")
+	}
+}
+
+func TestRESErrors(t *testing.T) {
+	cursor := &ICO{Header: Header{Type: TypeCUR}}
+	cursor.AddImage(createTestImage(16), nil)
+	if err := EncodeRES(&bytes.Buffer{}, cursor, nil); err == nil {
+		t.Error("Expected error for cursor")
+	}
+	if err := EncodeRES(&bytes.Buffer{}, &ICO{}, nil); err == nil {
+		t.Error("Expected error for empty ICO")
+	}
+
+	icoFile := &ICO{}
+	icoFile.AddImage(createTestImage(16), nil)
+	var buf bytes.Buffer
+	EncodeRES(&buf, icoFile, nil)
+	valid := buf.Bytes()
+
+	cases := map[string][]byte{
+		"empty":     {},
+		"16-bit":    valid[32:],
+		"truncated": valid[:len(valid)-8],
+		"no icons":  valid[:32],
+	}
+	for name, data := range cases {
+		if _, err := DecodeRES(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
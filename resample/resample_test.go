@@ -0,0 +1,106 @@
+package resample
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestResizeDimensions(t *testing.T) {
+	src := solid(1024, 1024, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	for _, size := range []int{16, 20, 24, 32, 40, 48, 64, 96, 128, 256} {
+		dst := Resize(src, size, size, nil)
+		if b := dst.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("Expected %dx%d, got %dx%d", size, size, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestResizePreservesSolidColor(t *testing.T) {
+	c := color.NRGBA{R: 200, G: 100, B: 50, A: 255}
+	src := solid(64, 64, c)
+
+	for _, f := range []Filter{Box, CatmullRom, Lanczos3} {
+		for _, srgb := range []bool{false, true} {
+			for _, size := range []int{16, 48, 100} {
+				dst := Resize(src, size, size, &Options{Filter: f, SRGB: srgb})
+				if got := dst.NRGBAAt(size/2, size/2); got != c {
+					t.Errorf("%s (srgb=%v) at %d: expected %v, got %v", f.Name, srgb, size, c, got)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeNoColorBleed(t *testing.T) {
+	// Left half opaque red, right half fully transparent green. Without
+	// premultiplication the green would leak into the edge pixels.
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if x < 4 {
+				src.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			} else {
+				src.SetNRGBA(x, y, color.NRGBA{G: 255})
+			}
+		}
+	}
+
+	dst := Resize(src, 3, 3, &Options{Filter: Box})
+	edge := dst.NRGBAAt(1, 1)
+	if edge.A == 0 || edge.A == 255 {
+		t.Fatalf("Expected partially transparent edge pixel, got %v", edge)
+	}
+	if edge.G != 0 || edge.R != 255 {
+		t.Errorf("Expected pure red edge pixel, got %v", edge)
+	}
+}
+
+func TestResizeGammaCorrect(t *testing.T) {
+	// A black and white checkerboard averages to 50% linear light, which is
+	// about 188 in sRGB, not the naive 128.
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	src.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	src.SetNRGBA(1, 1, color.NRGBA{255, 255, 255, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 255})
+	src.SetNRGBA(0, 1, color.NRGBA{0, 0, 0, 255})
+
+	linear := Resize(src, 1, 1, &Options{Filter: Box}).NRGBAAt(0, 0)
+	if linear.R < 186 || linear.R > 190 {
+		t.Errorf("Expected linear-light average near 188, got %d", linear.R)
+	}
+
+	naive := Resize(src, 1, 1, &Options{Filter: Box, SRGB: true}).NRGBAAt(0, 0)
+	if naive.R < 127 || naive.R > 128 {
+		t.Errorf("Expected sRGB average near 128, got %d", naive.R)
+	}
+}
+
+func TestResizeInvalid(t *testing.T) {
+	src := solid(4, 4, color.NRGBA{A: 255})
+	if Resize(src, 0, 4, nil) != nil {
+		t.Error("Expected nil for zero width")
+	}
+	if Resize(image.NewNRGBA(image.Rect(0, 0, 0, 0)), 4, 4, nil) != nil {
+		t.Error("Expected nil for empty source")
+	}
+}
+
+func BenchmarkResize1024To256(b *testing.B) {
+	src := solid(1024, 1024, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Resize(src, 256, 256, nil)
+	}
+}
@@ -0,0 +1,42 @@
+package resample
+
+import "math"
+
+// srgbToLinear maps 8-bit sRGB-encoded values to linear light in [0, 1].
+var srgbToLinear = func() [256]float32 {
+	var table [256]float32
+	for i := range table {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		table[i] = float32(v)
+	}
+	return table
+}()
+
+// linearSteps is the resolution of the linear-to-sRGB lookup table. 4096
+// steps are enough for 8-bit values to survive a round trip through linear
+// light, even in dark tones where the curve is steepest.
+const linearSteps = 4096
+
+var linearToSRGBTable = func() [linearSteps + 1]float32 {
+	var table [linearSteps + 1]float32
+	for i := range table {
+		v := float64(i) / linearSteps
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		table[i] = float32(v)
+	}
+	return table
+}()
+
+// linearToSRGB encodes a linear value in [0, 1] with the sRGB transfer curve.
+func linearToSRGB(v float32) float32 {
+	return linearToSRGBTable[int(v*linearSteps+0.5)]
+}
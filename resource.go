@@ -0,0 +1,152 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Windows resource types holding icons
+const (
+	resourceTypeIcon      = 3  // RT_ICON: one image, stored like an ICO payload
+	resourceTypeGroupIcon = 14 // RT_GROUP_ICON: the directory of an icon
+)
+
+// IconGroup is an icon read from the RT_GROUP_ICON and RT_ICON resources of
+// an executable, icon library or resource file.
+type IconGroup struct {
+	Name     string // Resource name, or "" if the group is identified by ID
+	ID       uint16 // Resource ID, when Name is empty
+	Language uint16 // Language ID, or 0 where the format has none
+	Icon     *ICO
+}
+
+// resource is a resource read from or written to an executable or .res
+// file. A resource type or name given as a string has the numeric form 0.
+type resource struct {
+	typ      uint16
+	typeName string
+	id       uint16
+	name     string
+	language uint16
+	flags    uint16 // Memory flags, in .res files
+	codepage uint32 // Code page, in PE files
+	data     []byte
+}
+
+// label returns the resource name, or its ID in decimal
+func (res resource) label() string {
+	if res.name != "" {
+		return fmt.Sprintf("%q", res.name)
+	}
+	return fmt.Sprint(res.id)
+}
+
+// groupIconEntry is an entry of an RT_GROUP_ICON directory. It matches a
+// DirectoryEntry, except that the image offset is replaced by the ID of the
+// RT_ICON resource holding the image.
+type groupIconEntry struct {
+	Width        uint8
+	Height       uint8
+	ColorCount   uint8
+	Reserved     uint8
+	ColorPlanes  uint16
+	BitsPerPixel uint16
+	Size         uint32
+	ID           uint16
+}
+
+// decodeIconGroup reassembles the ICO described by an RT_GROUP_ICON
+// directory, looking up each image with icon, which returns nil for an
+// unknown ID. Images whose resource is missing are skipped, as Windows does.
+func decodeIconGroup(dir []byte, icon func(id uint16) []byte) (*ICO, error) {
+	if len(dir) < 6 {
+		return nil, fmt.Errorf("icon group directory too short")
+	}
+	header := Header{}
+	r := bytes.NewReader(dir)
+	binary.Read(r, binary.LittleEndian, &header)
+	if header.Type != TypeICO {
+		return nil, fmt.Errorf("icon group has unsupported type %d", header.Type)
+	}
+	if len(dir) < 6+14*int(header.Count) {
+		return nil, fmt.Errorf("icon group directory truncated: %d entries in %d bytes", header.Count, len(dir))
+	}
+
+	var entries []DirectoryEntry
+	var payloads [][]byte
+	for i := 0; i < int(header.Count); i++ {
+		var ge groupIconEntry
+		binary.Read(r, binary.LittleEndian, &ge)
+
+		data := icon(ge.ID)
+		if data == nil {
+			continue
+		}
+		// Resource data may be padded to an alignment boundary
+		if int(ge.Size) < len(data) && ge.Size > 0 {
+			data = data[:ge.Size]
+		}
+
+		entries = append(entries, DirectoryEntry{
+			Width:        ge.Width,
+			Height:       ge.Height,
+			ColorCount:   ge.ColorCount,
+			ColorPlanes:  ge.ColorPlanes,
+			BitsPerPixel: ge.BitsPerPixel,
+		})
+		payloads = append(payloads, data)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("icon group refers to no existing icons")
+	}
+
+	// Rebuild the ICO file so Decode can do the rest
+	var buf bytes.Buffer
+	if err := writeICO(&buf, header, entries, payloads); err != nil {
+		return nil, err
+	}
+	return Decode(&buf)
+}
+
+// encodeIconGroup encodes ico with Encode and splits the result into an
+// RT_GROUP_ICON directory and one RT_ICON payload per image, numbered from
+// firstID.
+func encodeIconGroup(ico *ICO, opts *EncodeOptions, firstID uint16) ([]byte, [][]byte, error) {
+	if ico.Header.Type == TypeCUR {
+		return nil, nil, fmt.Errorf("cursors cannot be stored as icon resources")
+	}
+	if int(firstID)+len(ico.Images) > 0x10000 {
+		return nil, nil, fmt.Errorf("icon IDs from %d overflow for %d images", firstID, len(ico.Images))
+	}
+
+	var encoded bytes.Buffer
+	if err := Encode(&encoded, ico, opts); err != nil {
+		return nil, nil, err
+	}
+	data := encoded.Bytes()
+
+	header := Header{}
+	r := bytes.NewReader(data)
+	binary.Read(r, binary.LittleEndian, &header)
+
+	var dir bytes.Buffer
+	binary.Write(&dir, binary.LittleEndian, Header{Type: TypeICO, Count: header.Count})
+	icons := make([][]byte, header.Count)
+	for i := range icons {
+		var entry DirectoryEntry
+		binary.Read(r, binary.LittleEndian, &entry)
+		icons[i] = data[entry.Offset : entry.Offset+entry.Size]
+
+		binary.Write(&dir, binary.LittleEndian, groupIconEntry{
+			Width:        entry.Width,
+			Height:       entry.Height,
+			ColorCount:   entry.ColorCount,
+			ColorPlanes:  entry.ColorPlanes,
+			BitsPerPixel: entry.BitsPerPixel,
+			Size:         entry.Size,
+			ID:           firstID + uint16(i),
+		})
+	}
+	return dir.Bytes(), icons, nil
+}
@@ -0,0 +1,263 @@
+package ico
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+// Sizes of the structures of a PE resource section
+const (
+	resourceDirectorySize = 16 // IMAGE_RESOURCE_DIRECTORY
+	resourceEntrySize     = 8  // IMAGE_RESOURCE_DIRECTORY_ENTRY
+	resourceDataEntrySize = 16 // IMAGE_RESOURCE_DATA_ENTRY
+)
+
+// readResourceTree reads the three-level resource tree (type, name,
+// language) of a PE resource section. section holds the section contents,
+// starting at the root directory, and data returns the bytes at an RVA, or
+// nil if they lie outside the image.
+func readResourceTree(section []byte, data func(rva, size uint32) []byte) ([]resource, error) {
+	var resources []resource
+	var walk func(off uint32, level int, res resource) error
+	walk = func(off uint32, level int, res resource) error {
+		if int(off)+resourceDirectorySize > len(section) {
+			return fmt.Errorf("resource directory at offset %d is beyond end of section", off)
+		}
+		named := int(binary.LittleEndian.Uint16(section[off+12:]))
+		ids := int(binary.LittleEndian.Uint16(section[off+14:]))
+		entries := section[off+resourceDirectorySize:]
+		if (named+ids)*resourceEntrySize > len(entries) {
+			return fmt.Errorf("resource directory at offset %d is truncated", off)
+		}
+
+		for i := 0; i < named+ids; i++ {
+			nameField := binary.LittleEndian.Uint32(entries[i*resourceEntrySize:])
+			target := binary.LittleEndian.Uint32(entries[i*resourceEntrySize+4:])
+
+			var id uint16
+			var name string
+			if nameField&0x80000000 != 0 {
+				var err error
+				if name, err = readResourceString(section, nameField&0x7FFFFFFF); err != nil {
+					return err
+				}
+			} else {
+				id = uint16(nameField)
+			}
+			switch level {
+			case 0:
+				res.typ, res.typeName = id, name
+			case 1:
+				res.id, res.name = id, name
+			case 2:
+				res.language = id
+			}
+
+			isDirectory := target&0x80000000 != 0
+			if level < 2 {
+				if !isDirectory {
+					return fmt.Errorf("resource directory at offset %d has data where a subdirectory belongs", off)
+				}
+				if err := walk(target&0x7FFFFFFF, level+1, res); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if isDirectory || int(target)+resourceDataEntrySize > len(section) {
+				return fmt.Errorf("invalid resource data entry at offset %d", target)
+			}
+			rva := binary.LittleEndian.Uint32(section[target:])
+			size := binary.LittleEndian.Uint32(section[target+4:])
+			res.codepage = binary.LittleEndian.Uint32(section[target+8:])
+			if res.data = data(rva, size); res.data == nil {
+				return fmt.Errorf("resource data at RVA %#x is outside the image", rva)
+			}
+			resources = append(resources, res)
+		}
+		return nil
+	}
+
+	if err := walk(0, 0, resource{}); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// readResourceString reads a resource name: a 16-bit length followed by
+// that many UTF-16 code units
+func readResourceString(section []byte, off uint32) (string, error) {
+	if int(off)+2 > len(section) {
+		return "", fmt.Errorf("resource name at offset %d is beyond end of section", off)
+	}
+	n := int(binary.LittleEndian.Uint16(section[off:]))
+	if int(off)+2+2*n > len(section) {
+		return "", fmt.Errorf("resource name at offset %d is truncated", off)
+	}
+	units := make([]uint16, n)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(section[int(off)+2+2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// resourceKeyLess orders resource types or names as Windows expects within
+// a directory: named entries first, sorted by name, then numeric IDs in
+// increasing order
+func resourceKeyLess(id1 uint16, name1 string, id2 uint16, name2 string) bool {
+	if (name1 != "") != (name2 != "") {
+		return name1 != ""
+	}
+	if name1 != "" {
+		return compareUTF16(name1, name2) < 0
+	}
+	return id1 < id2
+}
+
+// compareUTF16 compares two strings by their UTF-16 code units
+func compareUTF16(a, b string) int {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return int(ua[i]) - int(ub[i])
+		}
+	}
+	return len(ua) - len(ub)
+}
+
+// resourceDirectory is a directory of the tree buildResourceSection writes
+type resourceDirectory struct {
+	offset   uint32
+	children []resourceDirectoryEntry
+}
+
+// resourceDirectoryEntry is an entry of a resourceDirectory: a subdirectory
+// or, at the language level, the index of a resource
+type resourceDirectoryEntry struct {
+	id    uint16
+	name  string
+	dir   *resourceDirectory
+	index int
+}
+
+// buildResourceSection lays out resources as the contents of a PE resource
+// section loaded at rva: all directories first, then the data entries, the
+// names and finally the resource data, each aligned to 8 bytes.
+func buildResourceSection(resources []resource, rva uint32) []byte {
+	sorted := append([]resource(nil), resources...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.typ != b.typ || a.typeName != b.typeName {
+			return resourceKeyLess(a.typ, a.typeName, b.typ, b.typeName)
+		}
+		if a.id != b.id || a.name != b.name {
+			return resourceKeyLess(a.id, a.name, b.id, b.name)
+		}
+		return a.language < b.language
+	})
+
+	// Build the tree level by level, so directories are laid out breadth
+	// first as resource compilers do
+	root := &resourceDirectory{}
+	var levels [3][]*resourceDirectory
+	levels[0] = []*resourceDirectory{root}
+	var typeDir, nameDir *resourceDirectory
+	for i, res := range sorted {
+		if typeDir == nil || i == 0 || res.typ != sorted[i-1].typ || res.typeName != sorted[i-1].typeName {
+			typeDir = &resourceDirectory{}
+			root.children = append(root.children, resourceDirectoryEntry{id: res.typ, name: res.typeName, dir: typeDir})
+			levels[1] = append(levels[1], typeDir)
+			nameDir = nil
+		}
+		if nameDir == nil || res.id != sorted[i-1].id || res.name != sorted[i-1].name {
+			nameDir = &resourceDirectory{}
+			typeDir.children = append(typeDir.children, resourceDirectoryEntry{id: res.id, name: res.name, dir: nameDir})
+			levels[2] = append(levels[2], nameDir)
+		}
+		nameDir.children = append(nameDir.children, resourceDirectoryEntry{id: res.language, index: i})
+	}
+
+	var offset uint32
+	for _, level := range levels {
+		for _, dir := range level {
+			dir.offset = offset
+			offset += resourceDirectorySize + resourceEntrySize*uint32(len(dir.children))
+		}
+	}
+	dataEntries := offset
+	offset += resourceDataEntrySize * uint32(len(sorted))
+
+	namesStart := offset
+	nameOffsets := make(map[string]uint32)
+	var names []byte
+	for _, level := range levels {
+		for _, dir := range level {
+			for _, child := range dir.children {
+				if child.name == "" {
+					continue
+				}
+				if _, ok := nameOffsets[child.name]; ok {
+					continue
+				}
+				nameOffsets[child.name] = namesStart + uint32(len(names))
+				units := utf16.Encode([]rune(child.name))
+				names = binary.LittleEndian.AppendUint16(names, uint16(len(units)))
+				for _, u := range units {
+					names = binary.LittleEndian.AppendUint16(names, u)
+				}
+			}
+		}
+	}
+	offset = align(namesStart+uint32(len(names)), 8)
+
+	dataOffsets := make([]uint32, len(sorted))
+	for i, res := range sorted {
+		dataOffsets[i] = offset
+		offset = align(offset+uint32(len(res.data)), 8)
+	}
+
+	out := make([]byte, offset)
+	for _, level := range levels {
+		for _, dir := range level {
+			named := 0
+			for _, child := range dir.children {
+				if child.name != "" {
+					named++
+				}
+			}
+			binary.LittleEndian.PutUint16(out[dir.offset+12:], uint16(named))
+			binary.LittleEndian.PutUint16(out[dir.offset+14:], uint16(len(dir.children)-named))
+
+			for i, child := range dir.children {
+				entry := out[dir.offset+resourceDirectorySize+resourceEntrySize*uint32(i):]
+				if child.name != "" {
+					binary.LittleEndian.PutUint32(entry, 0x80000000|nameOffsets[child.name])
+				} else {
+					binary.LittleEndian.PutUint32(entry, uint32(child.id))
+				}
+				if child.dir != nil {
+					binary.LittleEndian.PutUint32(entry[4:], 0x80000000|child.dir.offset)
+				} else {
+					binary.LittleEndian.PutUint32(entry[4:], dataEntries+resourceDataEntrySize*uint32(child.index))
+				}
+			}
+		}
+	}
+	copy(out[namesStart:], names)
+
+	for i, res := range sorted {
+		entry := out[dataEntries+resourceDataEntrySize*uint32(i):]
+		binary.LittleEndian.PutUint32(entry, rva+dataOffsets[i])
+		binary.LittleEndian.PutUint32(entry[4:], uint32(len(res.data)))
+		binary.LittleEndian.PutUint32(entry[8:], res.codepage)
+		copy(out[dataOffsets[i]:], res.data)
+	}
+	return out
+}
+
+// align rounds n up to a multiple of alignment, which must be a power of two
+func align(n, alignment uint32) uint32 {
+	return (n + alignment - 1) &^ (alignment - 1)
+}
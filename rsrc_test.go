@@ -0,0 +1,88 @@
+package ico
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestResourceSectionRoundTrip(t *testing.T) {
+	const rva = 0x3000
+	resources := []resource{
+		{typ: resourceTypeIcon, id: 2, language: 0x0409, data: []byte("second")},
+		{typ: resourceTypeIcon, id: 1, language: 0x0409, data: []byte("first")},
+		{typ: resourceTypeIcon, id: 1, language: 0x0407, data: []byte("german")},
+		{typeName: "CUSTOM", name: "ICON", data: []byte("custom")},
+		{typ: resourceTypeGroupIcon, name: "ICON", codepage: 1252, data: []byte("group")},
+	}
+	section := buildResourceSection(resources, rva)
+
+	var addrs []uint32
+	read, err := readResourceTree(section, func(addr, size uint32) []byte {
+		addrs = append(addrs, addr)
+		if addr < rva || int(addr-rva+size) > len(section) {
+			return nil
+		}
+		return section[addr-rva : addr-rva+size]
+	})
+	if err != nil {
+		t.Fatalf("Failed to read resource tree: %v", err)
+	}
+
+	// Named types first, then IDs and languages in increasing order
+	want := []resource{resources[3], resources[2], resources[1], resources[0], resources[4]}
+	if len(read) != len(want) {
+		t.Fatalf("Expected %d resources, got %d", len(want), len(read))
+	}
+	for i, res := range read {
+		w := want[i]
+		if res.typ != w.typ || res.typeName != w.typeName || res.id != w.id || res.name != w.name ||
+			res.language != w.language || res.codepage != w.codepage || string(res.data) != string(w.data) {
+			t.Errorf("Resource %d: expected %+v, got %+v", i, w, res)
+		}
+	}
+
+	// Data is 8-byte aligned, and the shared name is stored once
+	for _, addr := range addrs {
+		if addr%8 != 0 {
+			t.Errorf("Resource data at unaligned RVA %#x", addr)
+		}
+	}
+	if n := countUTF16(section, "ICON"); n != 1 {
+		t.Errorf("Expected name to be stored once, found %d copies", n)
+	}
+}
+
+func TestReadResourceTreeErrors(t *testing.T) {
+	section := buildResourceSection([]resource{{typ: resourceTypeIcon, id: 1, data: []byte("icon")}}, 0)
+	data := func(rva, size uint32) []byte { return section[rva : rva+size] }
+
+	if _, err := readResourceTree(section[:20], data); err == nil {
+		t.Error("Expected error for truncated directory")
+	}
+
+	// A data entry at the type level is invalid
+	bad := append([]byte(nil), section...)
+	binary.LittleEndian.PutUint32(bad[20:], binary.LittleEndian.Uint32(bad[20:])&0x7FFFFFFF)
+	if _, err := readResourceTree(bad, data); err == nil {
+		t.Error("Expected error for misplaced data entry")
+	}
+
+	if _, err := readResourceTree(section, func(rva, size uint32) []byte { return nil }); err == nil {
+		t.Error("Expected error for data outside the image")
+	}
+}
+
+// countUTF16 counts the length-prefixed UTF-16 copies of s in data
+func countUTF16(data []byte, s string) int {
+	encoded := binary.LittleEndian.AppendUint16(nil, uint16(len(s)))
+	for _, r := range s {
+		encoded = binary.LittleEndian.AppendUint16(encoded, uint16(r))
+	}
+	count := 0
+	for i := 0; i+len(encoded) <= len(data); i++ {
+		if string(data[i:i+len(encoded)]) == string(encoded) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,188 @@
+package ico
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/thatoddmailbox/go-ico/resample"
+)
+
+// SheetOptions configures ContactSheet. A nil *SheetOptions, like the zero
+// value, scales entries to the size of the largest and uses the default
+// spacing and colors.
+type SheetOptions struct {
+	// Size is the common size every entry is also shown scaled to; zero
+	// means the larger side of the largest entry, and a negative value
+	// leaves out the scaled row
+	Size int
+
+	// Padding is the space in pixels around and between cells; zero
+	// means 8
+	Padding int
+
+	// LabelScale enlarges the labels by a whole factor; zero means 1
+	LabelScale int
+
+	// CheckerSize is the size of the squares of the checkerboard drawn
+	// behind each image; zero means 8
+	CheckerSize int
+
+	// Colors of the sheet, the checkerboard squares and the labels; nil
+	// means white, light and mid gray, and black
+	Background, CheckerLight, CheckerDark, Text color.Color
+}
+
+// Default contact sheet colors
+var (
+	sheetCheckerLight = color.NRGBA{0xE0, 0xE0, 0xE0, 0xFF}
+	sheetCheckerDark  = color.NRGBA{0xB0, 0xB0, 0xB0, 0xFF}
+)
+
+// ContactSheet draws every entry of ico side by side for review: each
+// column shows an entry at its native size, then scaled to a common size,
+// then labelled with its dimensions, bit depth, format and, for cursors,
+// its hotspot. Images are drawn over a checkerboard so transparency shows.
+// Entries are enlarged without smoothing, so their pixels stay visible, and
+// reduced with a Catmull-Rom filter. The labels use a built-in bitmap font.
+// ContactSheet returns nil if ico has no images. It does not modify ico.
+func ContactSheet(ico *ICO, opts *SheetOptions) *image.NRGBA {
+	if len(ico.Images) == 0 {
+		return nil
+	}
+
+	var o SheetOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Padding <= 0 {
+		o.Padding = 8
+	}
+	if o.LabelScale <= 0 {
+		o.LabelScale = 1
+	}
+	if o.CheckerSize <= 0 {
+		o.CheckerSize = 8
+	}
+	if o.Background == nil {
+		o.Background = color.White
+	}
+	if o.CheckerLight == nil {
+		o.CheckerLight = sheetCheckerLight
+	}
+	if o.CheckerDark == nil {
+		o.CheckerDark = sheetCheckerDark
+	}
+	if o.Text == nil {
+		o.Text = color.Black
+	}
+
+	ico = ico.synced()
+	if o.Size == 0 {
+		for _, img := range ico.Images {
+			o.Size = max(o.Size, img.Bounds().Dx(), img.Bounds().Dy())
+		}
+	}
+
+	// Size the columns and rows
+	labels := make([][]string, len(ico.Images))
+	widths := make([]int, len(ico.Images))
+	nativeHeight, labelLines := 0, 0
+	for i, img := range ico.Images {
+		labels[i] = ico.sheetLabels(i)
+		b := img.Bounds()
+		widths[i] = max(b.Dx(), o.Size)
+		for _, label := range labels[i] {
+			widths[i] = max(widths[i], textWidth(label, o.LabelScale))
+		}
+		nativeHeight = max(nativeHeight, b.Dy())
+		labelLines = max(labelLines, len(labels[i]))
+	}
+
+	scaledY := o.Padding + nativeHeight + o.Padding
+	labelY := scaledY
+	if o.Size > 0 {
+		labelY += o.Size + o.Padding
+	}
+	width := o.Padding
+	for _, w := range widths {
+		width += w + o.Padding
+	}
+	height := labelY + (labelLines*lineHeight-(lineHeight-glyphHeight))*o.LabelScale + o.Padding
+
+	sheet := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(o.Background), image.Point{}, draw.Src)
+
+	x := o.Padding
+	for i, img := range ico.Images {
+		center := func(w int) int { return x + (widths[i]-w)/2 }
+
+		native := toNRGBA(img)
+		drawOverChecker(sheet, native, image.Pt(center(native.Bounds().Dx()), o.Padding), &o)
+		if o.Size > 0 {
+			scaled := sheetScale(img, o.Size)
+			drawOverChecker(sheet, scaled, image.Pt(center(o.Size), scaledY), &o)
+		}
+		for line, label := range labels[i] {
+			pt := image.Pt(center(textWidth(label, o.LabelScale)), labelY+line*lineHeight*o.LabelScale)
+			drawText(sheet, pt, label, o.Text, o.LabelScale)
+		}
+		x += widths[i] + o.Padding
+	}
+	return sheet
+}
+
+// sheetLabels returns the label lines of entry i
+func (ico *ICO) sheetLabels(i int) []string {
+	e := ico.entryInfo(i)
+	labels := []string{
+		fmt.Sprintf("%dx%d", e.Width, e.Height),
+		fmt.Sprintf("%d bpp", e.BitsPerPixel),
+		e.Format.String(),
+	}
+	if ico.Header.Type == TypeCUR {
+		hotspot := e.Entry.Hotspot()
+		labels = append(labels, fmt.Sprintf("hot %d,%d", hotspot.X, hotspot.Y))
+	}
+	return labels
+}
+
+// sheetScale fits img in a size-pixel square, enlarging it with nearest
+// neighbour sampling or reducing it as Render does
+func sheetScale(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	if b.Dx() > size || b.Dy() > size {
+		return scaleToSquare(img, size, &resample.Options{Filter: resample.CatmullRom})
+	}
+
+	factor := float64(size) / float64(max(b.Dx(), b.Dy()))
+	w := max(1, int(math.Round(float64(b.Dx())*factor)))
+	h := max(1, int(math.Round(float64(b.Dy())*factor)))
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	offset := image.Pt((size-w)/2, (size-h)/2)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(offset.X+x, offset.Y+y, img.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h))
+		}
+	}
+	return dst
+}
+
+// drawOverChecker draws img at pt over a checkerboard covering its bounds
+func drawOverChecker(dst *image.NRGBA, img *image.NRGBA, pt image.Point, o *SheetOptions) {
+	r := img.Bounds().Add(pt)
+	light, dark := image.NewUniform(o.CheckerLight), image.NewUniform(o.CheckerDark)
+	for y := r.Min.Y; y < r.Max.Y; y += o.CheckerSize {
+		for x := r.Min.X; x < r.Max.X; x += o.CheckerSize {
+			src := light
+			if ((x-r.Min.X)/o.CheckerSize+(y-r.Min.Y)/o.CheckerSize)%2 == 1 {
+				src = dark
+			}
+			square := image.Rect(x, y, x+o.CheckerSize, y+o.CheckerSize).Intersect(r)
+			draw.Draw(dst, square, src, image.Point{}, draw.Src)
+		}
+	}
+	draw.Draw(dst, r, img, image.Point{}, draw.Over)
+}
@@ -0,0 +1,115 @@
+package ico
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestContactSheet(t *testing.T) {
+	sheet := ContactSheet(createMultiSizeICO(16, 32), nil)
+	if sheet == nil {
+		t.Fatal("Expected a contact sheet")
+	}
+
+	// Two 35-pixel columns, as wide as the "32 bpp" label, and rows for
+	// the native images, the images scaled to 32 pixels and three labels
+	if got, want := sheet.Bounds().Size(), image.Pt(8+35+8+35+8, 8+32+8+32+8+3*9-2+8); got != want {
+		t.Fatalf("Expected a %v sheet, got %v", want, got)
+	}
+
+	for _, tt := range []struct {
+		name string
+		pt   image.Point
+		want color.NRGBA
+	}{
+		{"background", image.Pt(0, 0), color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}},
+		{"native 16px", image.Pt(8+(35-16)/2, 8), color.NRGBA{16, 0, 0, 0xFF}},
+		{"native 32px", image.Pt(8+35+8+1, 8), color.NRGBA{32, 0, 0, 0xFF}},
+		{"scaled 16px", image.Pt(8+1+31, 8+32+8+31), color.NRGBA{16, 0, 0, 0xFF}},
+	} {
+		if got := sheet.NRGBAAt(tt.pt.X, tt.pt.Y); got != tt.want {
+			t.Errorf("%s: expected %v at %v, got %v", tt.name, tt.want, tt.pt, got)
+		}
+	}
+
+	// The labels are drawn in black below the images
+	labels := image.Rect(8, 8+32+8+32+8, 8+35, sheet.Bounds().Dy()-8)
+	if !containsColor(sheet, labels, color.NRGBA{0, 0, 0, 0xFF}) {
+		t.Error("Expected black label text")
+	}
+}
+
+func TestContactSheetLeavesEntries(t *testing.T) {
+	icoFile := createMultiSizeICO(16, 32)
+	icoFile.Entries = icoFile.Entries[:1]
+	if ContactSheet(icoFile, nil) == nil {
+		t.Fatal("Expected a contact sheet")
+	}
+	if len(icoFile.Entries) != 1 {
+		t.Errorf("Expected ContactSheet to leave the entries alone, got %d", len(icoFile.Entries))
+	}
+}
+
+func TestContactSheetOptions(t *testing.T) {
+	icoFile := &ICO{Header: Header{Type: TypeCUR}}
+	if err := icoFile.AddImage(image.NewNRGBA(image.Rect(0, 0, 20, 10)), &AddOptions{Hotspot: image.Pt(3, 4)}); err != nil {
+		t.Fatalf("Failed to add image: %v", err)
+	}
+	if labels := icoFile.sheetLabels(0); !slices.Equal(labels, []string{"20x10", "32 bpp", "bmp", "hot 3,4"}) {
+		t.Errorf("Unexpected cursor labels %q", labels)
+	}
+
+	red := color.NRGBA{0xFF, 0, 0, 0xFF}
+	sheet := ContactSheet(icoFile, &SheetOptions{Size: -1, Padding: 2, LabelScale: 2, CheckerLight: red})
+	// One column as wide as "hot 3,4" at double scale, with no scaled row
+	if got, want := sheet.Bounds().Size(), image.Pt(2+82+2, 2+10+2+(4*9-2)*2+2); got != want {
+		t.Fatalf("Expected a %v sheet, got %v", want, got)
+	}
+	// The transparent image shows the checkerboard
+	if got := sheet.NRGBAAt(2+(82-20)/2, 2); got != red {
+		t.Errorf("Expected checkerboard behind transparent pixels, got %v", got)
+	}
+
+	if ContactSheet(&ICO{}, nil) != nil {
+		t.Error("Expected nil for an empty ICO")
+	}
+}
+
+func TestDrawText(t *testing.T) {
+	if w := textWidth("32x32", 1); w != 29 {
+		t.Errorf("Expected width 29, got %d", w)
+	}
+	if w := textWidth("", 2); w != 0 {
+		t.Errorf("Expected width 0, got %d", w)
+	}
+
+	// "1" has a full-width bottom row, and uppercase letters are drawn in
+	// lowercase
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	drawText(img, image.Pt(1, 1), "1", color.Black, 2)
+	if img.NRGBAAt(1+1*2, 1+6*2).A != 0xFF || img.NRGBAAt(1, 1).A != 0 {
+		t.Error("Unexpected pixels for glyph 1")
+	}
+
+	upper := image.NewNRGBA(image.Rect(0, 0, 12, 9))
+	lower := image.NewNRGBA(image.Rect(0, 0, 12, 9))
+	drawText(upper, image.Point{}, "PNG", color.Black, 1)
+	drawText(lower, image.Point{}, "png", color.Black, 1)
+	if !slices.Equal(upper.Pix, lower.Pix) {
+		t.Error("Expected uppercase to be drawn in lowercase")
+	}
+}
+
+// containsColor reports whether any pixel of r in img is c
+func containsColor(img *image.NRGBA, r image.Rectangle, c color.NRGBA) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if img.NRGBAAt(x, y) == c {
+				return true
+			}
+		}
+	}
+	return false
+}
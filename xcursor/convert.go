@@ -0,0 +1,129 @@
+package xcursor
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"time"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+// FromICO converts a decoded cursor to a static Xcursor with one image per
+// entry, each taking its nominal size from its larger dimension and keeping
+// its hotspot. Entries of an icon (type 1) file get their hotspot at the
+// origin. Images are converted to premultiplied RGBA.
+func FromICO(cur *ico.ICO) (*Cursor, error) {
+	if len(cur.Images) == 0 {
+		return nil, fmt.Errorf("cursor contains no images")
+	}
+
+	cursor := &Cursor{}
+	for _, i := range bySize(cur) {
+		cursor.add(cur, i, 0)
+	}
+	return cursor, nil
+}
+
+// FromANI converts an animated cursor to an animated Xcursor. Every size
+// present in the frames becomes a nominal size, and each nominal size gets
+// one image per animation step, with the step's delay. A frame missing a
+// size is represented by its closest entry, resampled to the nominal size.
+func FromANI(anim *ico.Animation) (*Cursor, error) {
+	if len(anim.Steps) == 0 || len(anim.Frames) == 0 {
+		return nil, fmt.Errorf("animation contains no steps")
+	}
+
+	seen := make(map[int]bool)
+	var sizes []int
+	for _, frame := range anim.Frames {
+		for _, img := range frame.Images {
+			if size := longestSide(img); !seen[size] {
+				seen[size] = true
+				sizes = append(sizes, size)
+			}
+		}
+	}
+	sort.Ints(sizes)
+
+	cursor := &Cursor{}
+	for _, size := range sizes {
+		for s, step := range anim.Steps {
+			if step.Frame < 0 || step.Frame >= len(anim.Frames) {
+				return nil, fmt.Errorf("step %d refers to frame %d of %d", s, step.Frame, len(anim.Frames))
+			}
+			frame := anim.Frames[step.Frame]
+
+			i := indexOfSize(frame, size)
+			if i < 0 {
+				// Render scales the source entry to fit a square and centers
+				// it, so move that entry's hotspot the same way
+				img := frame.Render(size, 1)
+				if img == nil {
+					return nil, fmt.Errorf("frame %d contains no images", step.Frame)
+				}
+				source := frame.RenderSource(size)
+				var hotspot image.Point
+				if frame.Header.Type == ico.TypeCUR && source < len(frame.Entries) {
+					sb := frame.Images[source].Bounds()
+					scale := float64(size) / float64(longestSide(frame.Images[source]))
+					offset := image.Pt(
+						(size-int(float64(sb.Dx())*scale+0.5))/2,
+						(size-int(float64(sb.Dy())*scale+0.5))/2,
+					)
+					hs := frame.Entries[source].Hotspot()
+					hotspot = image.Pt(
+						min(size-1, offset.X+int(float64(hs.X)*scale)),
+						min(size-1, offset.Y+int(float64(hs.Y)*scale)),
+					)
+				}
+				cursor.Entries = append(cursor.Entries, Entry{NominalSize: size, Hotspot: hotspot, Delay: step.Delay})
+				cursor.Images = append(cursor.Images, toRGBA(img))
+				continue
+			}
+
+			cursor.add(frame, i, step.Delay)
+			cursor.Entries[len(cursor.Entries)-1].NominalSize = size
+		}
+	}
+	return cursor, nil
+}
+
+// add appends entry i of cur as an image with the given delay
+func (c *Cursor) add(cur *ico.ICO, i int, delay time.Duration) {
+	img := cur.Images[i]
+	var hotspot image.Point
+	if cur.Header.Type == ico.TypeCUR && i < len(cur.Entries) {
+		hotspot = cur.Entries[i].Hotspot()
+	}
+	c.Entries = append(c.Entries, Entry{NominalSize: longestSide(img), Hotspot: hotspot, Delay: delay})
+	c.Images = append(c.Images, toRGBA(img))
+}
+
+// bySize returns the indexes of cur's images ordered by increasing size
+func bySize(cur *ico.ICO) []int {
+	order := make([]int, len(cur.Images))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return longestSide(cur.Images[order[a]]) < longestSide(cur.Images[order[b]])
+	})
+	return order
+}
+
+// indexOfSize returns the index of the first image of cur whose larger
+// dimension is size, or -1
+func indexOfSize(cur *ico.ICO, size int) int {
+	for i, img := range cur.Images {
+		if longestSide(img) == size {
+			return i
+		}
+	}
+	return -1
+}
+
+func longestSide(img image.Image) int {
+	b := img.Bounds()
+	return max(b.Dx(), b.Dy())
+}
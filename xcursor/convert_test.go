@@ -0,0 +1,90 @@
+package xcursor
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/thatoddmailbox/go-ico"
+)
+
+// createCursor returns a CUR with one entry per size, each with its hotspot
+// at a quarter of its size.
+func createCursor(sizes ...int) *ico.ICO {
+	cur := &ico.ICO{Header: ico.Header{Type: ico.TypeCUR}}
+	for _, size := range sizes {
+		img := image.NewNRGBA(image.Rect(0, 0, size, size))
+		img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 128})
+		cur.AddImage(img, &ico.AddOptions{Hotspot: image.Pt(size/4, size/4)})
+	}
+	return cur
+}
+
+func TestFromICO(t *testing.T) {
+	cursor, err := FromICO(createCursor(48, 32))
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	want := []Entry{{NominalSize: 32, Hotspot: image.Pt(8, 8)}, {NominalSize: 48, Hotspot: image.Pt(12, 12)}}
+	if len(cursor.Entries) != len(want) {
+		t.Fatalf("Got %d entries, want %d", len(cursor.Entries), len(want))
+	}
+	for i := range want {
+		if cursor.Entries[i] != want[i] {
+			t.Errorf("Entry %d: %+v, want %+v", i, cursor.Entries[i], want[i])
+		}
+	}
+
+	// Premultiplied: half-transparent red becomes R=128
+	if c := cursor.Images[0].(*image.RGBA).RGBAAt(0, 0); c.R != 128 || c.A != 128 {
+		t.Errorf("Expected premultiplied pixel, got %v", c)
+	}
+
+	if _, err := FromICO(&ico.ICO{}); err == nil {
+		t.Error("Expected error for empty cursor")
+	}
+}
+
+func TestFromANI(t *testing.T) {
+	anim := &ico.Animation{
+		Frames: []*ico.ICO{createCursor(32, 64), createCursor(32)},
+		Steps: []ico.AnimationStep{
+			{Frame: 0, Delay: 100 * time.Millisecond},
+			{Frame: 1, Delay: 200 * time.Millisecond},
+			{Frame: 0, Delay: 300 * time.Millisecond},
+		},
+	}
+
+	cursor, err := FromANI(anim)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	if len(cursor.Images) != 6 {
+		t.Fatalf("Expected 3 steps at 2 sizes, got %d images", len(cursor.Images))
+	}
+	for i, entry := range cursor.Entries {
+		wantSize := 32
+		if i >= 3 {
+			wantSize = 64
+		}
+		wantDelay := anim.Steps[i%3].Delay
+		if entry.NominalSize != wantSize || entry.Delay != wantDelay || cursor.Images[i].Bounds().Dx() != wantSize {
+			t.Errorf("Entry %d: %+v with %v image, want size %d delay %v", i, entry, cursor.Images[i].Bounds(), wantSize, wantDelay)
+		}
+	}
+
+	// The second frame has no 64px entry, so its 32px one is scaled up
+	if hs := cursor.Entries[4].Hotspot; hs != image.Pt(16, 16) {
+		t.Errorf("Expected scaled hotspot 16,16, got %v", hs)
+	}
+	if frames := cursor.Frames(64); len(frames) != 3 {
+		t.Errorf("Expected 3 frames at 64px, got %v", frames)
+	}
+
+	if _, err := FromANI(&ico.Animation{}); err == nil {
+		t.Error("Expected error for empty animation")
+	}
+}
@@ -0,0 +1,291 @@
+// Package xcursor reads and writes X11 Xcursor files, the cursor format of
+// Linux and other Unix desktops. An Xcursor file holds any number of images,
+// each tagged with a nominal size; a cursor theme picks the nominal size
+// closest to the configured cursor size, and when several images share that
+// size they play in file order as an animation.
+package xcursor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// magic identifies an Xcursor file
+const magic = "Xcur"
+
+// Format constants from the Xcursor specification
+const (
+	fileHeaderSize  = 16
+	fileVersion     = 0x00010000
+	tocEntrySize    = 12
+	imageType       = 0xFFFD0002
+	imageHeaderSize = 36
+	imageVersion    = 1
+
+	// maxImageSize is the largest width or height an image may have
+	maxImageSize = 0x7FFF
+)
+
+// Entry describes one image of an Xcursor file
+type Entry struct {
+	NominalSize int           // Cursor size the image is intended for
+	Hotspot     image.Point   // Position of the pointer within the image
+	Delay       time.Duration // Display time when the image is an animation frame
+}
+
+// Cursor represents a decoded Xcursor file. Images are premultiplied, as
+// the file stores them, so decoded images are *image.RGBA.
+type Cursor struct {
+	Entries []Entry
+	Images  []image.Image
+}
+
+// Decode decodes an Xcursor file from the given reader. Chunks other than
+// images, such as comments, are skipped.
+func Decode(r io.Reader) (*Cursor, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Xcursor data: %w", err)
+	}
+
+	if len(data) < fileHeaderSize || string(data[:4]) != magic {
+		return nil, fmt.Errorf("invalid Xcursor file: missing %q signature", magic)
+	}
+	headerSize := binary.LittleEndian.Uint32(data[4:8])
+	count := binary.LittleEndian.Uint32(data[12:16])
+	if headerSize < fileHeaderSize || uint64(headerSize)+uint64(count)*tocEntrySize > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid Xcursor file: table of contents extends beyond file boundary")
+	}
+
+	cursor := &Cursor{}
+	for i := 0; i < int(count); i++ {
+		toc := data[int(headerSize)+i*tocEntrySize:]
+		typ := binary.LittleEndian.Uint32(toc[0:4])
+		nominal := binary.LittleEndian.Uint32(toc[4:8])
+		position := binary.LittleEndian.Uint32(toc[8:12])
+		if typ != imageType {
+			continue
+		}
+
+		entry, img, err := decodeImage(data, int64(position))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image %d: %w", i, err)
+		}
+		entry.NominalSize = int(nominal)
+		cursor.Entries = append(cursor.Entries, entry)
+		cursor.Images = append(cursor.Images, img)
+	}
+
+	if len(cursor.Images) == 0 {
+		return nil, fmt.Errorf("Xcursor file contains no images")
+	}
+	return cursor, nil
+}
+
+// decodeImage decodes the image chunk at position
+func decodeImage(data []byte, position int64) (Entry, image.Image, error) {
+	if position+imageHeaderSize > int64(len(data)) {
+		return Entry{}, nil, fmt.Errorf("chunk at offset %d extends beyond file boundary", position)
+	}
+	chunk := data[position:]
+
+	var header struct {
+		HeaderSize, Type, Subtype, Version uint32
+		Width, Height, XHot, YHot, Delay   uint32
+	}
+	binary.Read(bytes.NewReader(chunk), binary.LittleEndian, &header)
+
+	if header.Type != imageType || header.HeaderSize < imageHeaderSize {
+		return Entry{}, nil, fmt.Errorf("chunk at offset %d is not an image", position)
+	}
+	if header.Width < 1 || header.Height < 1 || header.Width > maxImageSize || header.Height > maxImageSize {
+		return Entry{}, nil, fmt.Errorf("invalid image size %dx%d", header.Width, header.Height)
+	}
+	// libXcursor accepts a hotspot on the far edge, so this does too
+	if header.XHot > header.Width || header.YHot > header.Height {
+		return Entry{}, nil, fmt.Errorf("hotspot %d,%d is outside the %dx%d image", header.XHot, header.YHot, header.Width, header.Height)
+	}
+
+	w, h := int(header.Width), int(header.Height)
+	pixels := int64(header.HeaderSize)
+	if pixels+4*int64(w)*int64(h) > int64(len(chunk)) {
+		return Entry{}, nil, fmt.Errorf("%dx%d image extends beyond file boundary", w, h)
+	}
+
+	// Pixels are little-endian ARGB words, so B, G, R, A in memory
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	src := chunk[pixels:]
+	for i := 0; i < w*h; i++ {
+		b, g, r, a := src[4*i], src[4*i+1], src[4*i+2], src[4*i+3]
+		// Clamp malformed pixels so the image stays validly premultiplied
+		img.Pix[4*i] = min(r, a)
+		img.Pix[4*i+1] = min(g, a)
+		img.Pix[4*i+2] = min(b, a)
+		img.Pix[4*i+3] = a
+	}
+
+	entry := Entry{
+		Hotspot: image.Pt(int(header.XHot), int(header.YHot)),
+		Delay:   time.Duration(header.Delay) * time.Millisecond,
+	}
+	return entry, img, nil
+}
+
+// Encode writes cursor as an Xcursor file. Images are written in order, so
+// images sharing a nominal size play as an animation in the order given.
+// An entry with no nominal size uses the larger dimension of its image.
+func Encode(w io.Writer, cursor *Cursor) error {
+	if len(cursor.Images) == 0 {
+		return fmt.Errorf("cursor contains no images")
+	}
+
+	count := len(cursor.Images)
+	var toc, chunks bytes.Buffer
+	position := fileHeaderSize + tocEntrySize*count
+	for i, img := range cursor.Images {
+		b := img.Bounds()
+		if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > maxImageSize || b.Dy() > maxImageSize {
+			return fmt.Errorf("image %d is %dx%d: Xcursor images must be 1 to %d pixels on each side", i, b.Dx(), b.Dy(), maxImageSize)
+		}
+
+		var entry Entry
+		if i < len(cursor.Entries) {
+			entry = cursor.Entries[i]
+		}
+		if entry.NominalSize == 0 {
+			entry.NominalSize = max(b.Dx(), b.Dy())
+		}
+		if entry.Hotspot.X < 0 || entry.Hotspot.Y < 0 || entry.Hotspot.X > b.Dx() || entry.Hotspot.Y > b.Dy() {
+			return fmt.Errorf("image %d hotspot %d,%d is outside the %dx%d image", i, entry.Hotspot.X, entry.Hotspot.Y, b.Dx(), b.Dy())
+		}
+		// A hotspot on the far edge, as Decode accepts, is written as the
+		// last pixel so that it lies within the image
+		hotspot := image.Pt(min(entry.Hotspot.X, b.Dx()-1), min(entry.Hotspot.Y, b.Dy()-1))
+		delay := entry.Delay.Round(time.Millisecond) / time.Millisecond
+		if entry.NominalSize < 0 || delay < 0 || delay > math.MaxUint32 {
+			return fmt.Errorf("image %d has invalid nominal size %d or delay %v", i, entry.NominalSize, entry.Delay)
+		}
+
+		binary.Write(&toc, binary.LittleEndian, []uint32{imageType, uint32(entry.NominalSize), uint32(position)})
+
+		binary.Write(&chunks, binary.LittleEndian, []uint32{
+			imageHeaderSize, imageType, uint32(entry.NominalSize), imageVersion,
+			uint32(b.Dx()), uint32(b.Dy()), uint32(hotspot.X), uint32(hotspot.Y), uint32(delay),
+		})
+		src := toRGBA(img)
+		pixels := make([]byte, len(src.Pix))
+		for p := 0; p < len(pixels); p += 4 {
+			pixels[p], pixels[p+1], pixels[p+2], pixels[p+3] = src.Pix[p+2], src.Pix[p+1], src.Pix[p], src.Pix[p+3]
+		}
+		chunks.Write(pixels)
+		position += imageHeaderSize + len(pixels)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	binary.Write(&buf, binary.LittleEndian, []uint32{fileHeaderSize, fileVersion, uint32(count)})
+	buf.Write(toc.Bytes())
+	buf.Write(chunks.Bytes())
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write Xcursor data: %w", err)
+	}
+	return nil
+}
+
+// NominalSizes returns the distinct nominal sizes of the cursor, in
+// increasing order.
+func (c *Cursor) NominalSizes() []int {
+	seen := make(map[int]bool)
+	var sizes []int
+	for _, entry := range c.Entries {
+		if !seen[entry.NominalSize] {
+			seen[entry.NominalSize] = true
+			sizes = append(sizes, entry.NominalSize)
+		}
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// Frames returns the indexes of the images for the nominal size closest to
+// size, in playback order, following the selection rule of libXcursor.
+// A static cursor has a single frame.
+func (c *Cursor) Frames(size int) []int {
+	best := -1
+	for _, nominal := range c.NominalSizes() {
+		if best < 0 || abs(nominal-size) < abs(best-size) {
+			best = nominal
+		}
+	}
+
+	var frames []int
+	for i, entry := range c.Entries {
+		if entry.NominalSize == best {
+			frames = append(frames, i)
+		}
+	}
+	return frames
+}
+
+// GetImageBySize returns the first frame of the nominal size closest to
+// size, or nil if the cursor has no images.
+func (c *Cursor) GetImageBySize(size int) image.Image {
+	frames := c.Frames(size)
+	if len(frames) == 0 {
+		return nil
+	}
+	return c.Images[frames[0]]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// toRGBA returns img as a premultiplied RGBA image with its origin at (0, 0)
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) && rgba.Stride == 4*rgba.Rect.Dx() {
+		return rgba
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// decode returns the largest first frame for image package compatibility
+func decode(r io.Reader) (image.Image, error) {
+	cursor, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return cursor.GetImageBySize(math.MaxInt32), nil
+}
+
+// decodeConfig returns config for the image decode returns
+func decodeConfig(r io.Reader) (image.Config, error) {
+	img, err := decode(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.RGBAModel,
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+	}, nil
+}
+
+func init() {
+	image.RegisterFormat("xcursor", magic, decode, decodeConfig)
+}
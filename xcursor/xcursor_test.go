@@ -0,0 +1,183 @@
+package xcursor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// createTestImage returns a size x size premultiplied image with a
+// half-transparent diagonal.
+func createTestImage(size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			switch {
+			case x == y:
+				img.SetRGBA(x, y, color.RGBA{R: 64, G: 32, A: 128})
+			case x < y:
+				img.SetRGBA(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	cursor := &Cursor{
+		Entries: []Entry{
+			{NominalSize: 24, Hotspot: image.Pt(1, 2), Delay: 50 * time.Millisecond},
+			{NominalSize: 24, Hotspot: image.Pt(3, 4), Delay: 70 * time.Millisecond},
+			{Hotspot: image.Pt(5, 6)},
+		},
+		Images: []image.Image{createTestImage(24), createTestImage(24), createTestImage(48)},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, cursor); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	if len(decoded.Images) != 3 {
+		t.Fatalf("Expected 3 images, got %d", len(decoded.Images))
+	}
+	want := []Entry{cursor.Entries[0], cursor.Entries[1], {NominalSize: 48, Hotspot: image.Pt(5, 6)}}
+	for i, entry := range decoded.Entries {
+		if entry != want[i] {
+			t.Errorf("Entry %d: %+v, want %+v", i, entry, want[i])
+		}
+		if !bytes.Equal(decoded.Images[i].(*image.RGBA).Pix, cursor.Images[i].(*image.RGBA).Pix) {
+			t.Errorf("Image %d pixels differ", i)
+		}
+	}
+}
+
+func TestEncodeLayout(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0x44})
+	if err := Encode(&buf, &Cursor{Images: []image.Image{img}}); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	data := buf.Bytes()
+
+	words := make([]uint32, len(data)/4)
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, words)
+	want := []uint32{
+		0x72756358, 16, 0x10000, 1, // Header: "Xcur", size, version, TOC entries
+		0xFFFD0002, 1, 28, // TOC: type, nominal size, position
+		36, 0xFFFD0002, 1, 1, // Chunk header: size, type, nominal size, version
+		1, 1, 0, 0, 0, // Width, height, hotspot, delay
+		0x44112233, // ARGB pixel
+	}
+	if len(words) != len(want) {
+		t.Fatalf("Got %d words, want %d", len(words), len(want))
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Errorf("Word %d: %#x, want %#x", i, words[i], want[i])
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var buf bytes.Buffer
+	Encode(&buf, &Cursor{Images: []image.Image{createTestImage(8)}})
+	valid := buf.Bytes()
+
+	badSize := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(badSize[44:], 0)
+
+	badHotspot := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(badHotspot[52:], 9)
+
+	cases := map[string][]byte{
+		"empty":       {},
+		"bad magic":   append([]byte("Xcux"), valid[4:]...),
+		"short TOC":   valid[:20],
+		"truncated":   valid[:len(valid)-4],
+		"zero width":  badSize,
+		"bad hotspot": badHotspot,
+	}
+	for name, data := range cases {
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestEdgeHotspot(t *testing.T) {
+	// libXcursor accepts a hotspot on the far edge, so Decode does too, and
+	// Encode writes it as the last pixel
+	var buf bytes.Buffer
+	Encode(&buf, &Cursor{Images: []image.Image{createTestImage(8)}})
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[52:], 8)
+	binary.LittleEndian.PutUint32(data[56:], 8)
+
+	cursor, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if hs := cursor.Entries[0].Hotspot; hs != image.Pt(8, 8) {
+		t.Fatalf("Expected hotspot 8,8, got %v", hs)
+	}
+
+	buf.Reset()
+	if err := Encode(&buf, cursor); err != nil {
+		t.Fatalf("Failed to re-encode: %v", err)
+	}
+	if x, y := binary.LittleEndian.Uint32(buf.Bytes()[52:]), binary.LittleEndian.Uint32(buf.Bytes()[56:]); x != 7 || y != 7 {
+		t.Errorf("Expected the hotspot written as 7,7, got %d,%d", x, y)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	cases := map[string]*Cursor{
+		"empty":       {},
+		"bad hotspot": {Entries: []Entry{{Hotspot: image.Pt(9, 0)}}, Images: []image.Image{createTestImage(8)}},
+		"bad delay":   {Entries: []Entry{{Delay: -time.Second}}, Images: []image.Image{createTestImage(8)}},
+	}
+	for name, cursor := range cases {
+		if err := Encode(&bytes.Buffer{}, cursor); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestFrames(t *testing.T) {
+	cursor := &Cursor{
+		Entries: []Entry{{NominalSize: 32}, {NominalSize: 24}, {NominalSize: 32}, {NominalSize: 48}},
+		Images:  []image.Image{createTestImage(32), createTestImage(24), createTestImage(32), createTestImage(48)},
+	}
+
+	if sizes := cursor.NominalSizes(); len(sizes) != 3 || sizes[0] != 24 || sizes[2] != 48 {
+		t.Errorf("Unexpected nominal sizes %v", sizes)
+	}
+	if frames := cursor.Frames(30); len(frames) != 2 || frames[0] != 0 || frames[1] != 2 {
+		t.Errorf("Expected frames 0 and 2 for size 30, got %v", frames)
+	}
+	if img := cursor.GetImageBySize(100); img.Bounds().Dx() != 48 {
+		t.Errorf("Expected 48px image for size 100, got %v", img.Bounds())
+	}
+}
+
+func TestImagePackage(t *testing.T) {
+	var buf bytes.Buffer
+	Encode(&buf, &Cursor{Images: []image.Image{createTestImage(16), createTestImage(32)}})
+
+	img, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if format != "xcursor" || img.Bounds().Dx() != 32 {
+		t.Errorf("Expected 32px xcursor image, got %q %v", format, img.Bounds())
+	}
+}